@@ -51,12 +51,115 @@ func (pl *ProofList) UnmarshalJSON(bytes []byte) error {
 			proofs = append(proofs, proofu)
 			continue
 		}
+		proofnym := &ProofNym{}
+		if err := json.Unmarshal(proofbytes, proofnym); err != nil {
+			return err
+		}
+		if proofnym.Nym != nil {
+			proofs = append(proofs, proofnym)
+			continue
+		}
 		return errors.New("Unknown proof type found in ProofList")
 	}
 	*pl = proofs
 	return nil
 }
 
+// Verify verifies the proofs contained in the IssueCommitmentMessage against
+// the public keys, context and nonce (see ProofList.Verify). If proofPs is not
+// nil, it must contain for each proof the ProofP of its keyshare server (or nil
+// if the proof does not involve a keyshare server), and proofPCommitments the
+// corresponding ProofPCommitments. Each ProofP is verified against its
+// commitment and the challenge of its proof, and merged into a copy of the proof
+// before the proofs are verified; the proofs in the message are not modified.
+func (msg *IssueCommitmentMessage) Verify(
+	publicKeys []*gabikeys.PublicKey,
+	context, nonce *big.Int,
+	keyshareServers []string,
+	proofPs []*ProofP,
+	proofPCommitments []*ProofPCommitment,
+) error {
+	_, err := msg.verify(publicKeys, context, nonce, keyshareServers, proofPs, proofPCommitments)
+	return err
+}
+
+// verify verifies the message like Verify, returning the proofs into which the
+// ProofPs have been merged.
+func (msg *IssueCommitmentMessage) verify(
+	publicKeys []*gabikeys.PublicKey,
+	context, nonce *big.Int,
+	keyshareServers []string,
+	proofPs []*ProofP,
+	proofPCommitments []*ProofPCommitment,
+) (ProofList, error) {
+	if len(msg.Proofs) == 0 || len(msg.Proofs) != len(publicKeys) {
+		return nil, ErrInvalidCommitmentProofs
+	}
+	proofs := msg.Proofs
+	if proofPs != nil {
+		if len(proofPs) != len(msg.Proofs) || len(proofPCommitments) != len(msg.Proofs) {
+			return nil, ErrInvalidProofP
+		}
+		proofs = make(ProofList, len(msg.Proofs))
+		for i, proof := range msg.Proofs {
+			proofs[i] = proof
+			if proofPs[i] == nil {
+				continue
+			}
+			challenged, ok := proof.(interface{ Challenge() *big.Int })
+			if !ok || challenged.Challenge() == nil {
+				return nil, ErrInvalidProofP
+			}
+			if err := proofPs[i].Verify(publicKeys[i], proofPCommitments[i], challenged.Challenge()); err != nil {
+				return nil, err
+			}
+			if proofs[i] = mergedProof(proof, proofPs[i], publicKeys[i]); proofs[i] == nil {
+				return nil, ErrInvalidCommitmentProofs
+			}
+		}
+	}
+	if !proofs.Verify(publicKeys, context, nonce, false, keyshareServers) {
+		return nil, ErrInvalidCommitmentProofs
+	}
+	return proofs, nil
+}
+
+// mergedProof returns a copy of the proof into which the ProofP is merged,
+// leaving the proof itself unmodified, or nil if the proof is incomplete.
+func mergedProof(proof Proof, proofP *ProofP, pk *gabikeys.PublicKey) Proof {
+	switch p := proof.(type) {
+	case *ProofU:
+		if p.U == nil || p.SResponse == nil {
+			return nil
+		}
+		c := *p
+		c.U, c.SResponse = new(big.Int).Set(p.U), new(big.Int).Set(p.SResponse)
+		proof = &c
+	case *ProofD:
+		if p.SecretKeyResponse() == nil {
+			return nil
+		}
+		c := *p
+		c.AResponses = make(map[int]*big.Int, len(p.AResponses))
+		for i, response := range p.AResponses {
+			c.AResponses[i] = response
+		}
+		c.AResponses[0] = new(big.Int).Set(p.SecretKeyResponse())
+		proof = &c
+	case *ProofNym:
+		if p.SResponse == nil {
+			return nil
+		}
+		c := *p
+		c.SResponse = new(big.Int).Set(p.SResponse)
+		proof = &c
+	default:
+		return nil
+	}
+	proof.MergeProofP(proofP, pk)
+	return proof
+}
+
 // IssueSignatureMessage encapsulates the messages sent from the issuer to the
 // reciver in the final step of the issuance protocol.
 type IssueSignatureMessage struct {
@@ -165,6 +268,15 @@ func (b *CredentialBuilder) ConstructCredential(msg *IssueSignatureMessage, attr
 		}
 		ms[i] = new(big.Int).Add(msg.MIssuer[i], miUser) // mi = mi' + mi", for i \in randomblind
 	}
+	for i, mi := range b.carried {
+		if i >= len(ms) {
+			return nil, errors.New("got too few attributes")
+		}
+		if ms[i] != nil && ms[i].Cmp(mi) != 0 {
+			return nil, errors.New("attribute at carried over index does not match carried over value")
+		}
+		ms[i] = mi
+	}
 
 	if msg.NonRevocationWitness != nil {
 		if err := msg.NonRevocationWitness.Verify(b.pk); err != nil {
@@ -190,9 +302,46 @@ func (b *CredentialBuilder) ConstructCredential(msg *IssueSignatureMessage, attr
 	return cred, nil
 }
 
+// ConstructCredentials creates a credential for each of the builders using the
+// corresponding IssueSignatureMessage and attributes (see ConstructCredential).
+// If any of the credentials cannot be constructed, none are returned and the
+// messages are left untouched.
+func ConstructCredentials(builders []*CredentialBuilder, msgs []*IssueSignatureMessage, attributes [][]*big.Int) ([]*Credential, error) {
+	if len(builders) != len(msgs) || len(builders) != len(attributes) {
+		return nil, errors.New("amount of builders, messages and attributes do not match")
+	}
+
+	// ConstructCredential sets the time of the nonrevocation witness, which we restore on failure
+	updated := make([]time.Time, len(msgs))
+	for i, msg := range msgs {
+		if msg.NonRevocationWitness != nil {
+			updated[i] = msg.NonRevocationWitness.Updated
+		}
+	}
+
+	creds := make([]*Credential, 0, len(builders))
+	for i, b := range builders {
+		cred, err := b.ConstructCredential(msgs[i], attributes[i])
+		if err != nil {
+			for j, msg := range msgs {
+				if msg.NonRevocationWitness != nil {
+					msg.NonRevocationWitness.Updated = updated[j]
+				}
+			}
+			return nil, err
+		}
+		creds = append(creds, cred)
+	}
+	return creds, nil
+}
+
 // Creates a proofU using a provided nonce
 func (b *CredentialBuilder) proveCommitment(nonce1 *big.Int) (Proof, error) {
-	sCommit, err := common.RandomBigInt(b.pk.Params.LsCommit)
+	length := b.pk.Params.LsCommit
+	if isRefreshedKeyshareSecret(b.secret) && length < refreshedKeyshareRandomizerLength() {
+		length = refreshedKeyshareRandomizerLength()
+	}
+	sCommit, err := common.RandomBigInt(length)
 	if err != nil {
 		return nil, err
 	}
@@ -222,6 +371,52 @@ type CredentialBuilder struct {
 
 	mUser       map[int]*big.Int // Map of users shares of random blind attributes
 	mUserCommit map[int]*big.Int
+
+	carried           map[int]*big.Int // Map of carried over attributes, see CarryOver
+	sharedRandomizers map[int]string   // names of randomizers shared with other builders
+}
+
+// CarryOver commits the builder to the specified value for the attribute at the
+// specified index (where the secret key has index 0), without disclosing it to the
+// issuer. Typically, the value is an undisclosed attribute of another credential
+// whose disclosure proof is part of the same ProofList, to which it can then be
+// proven equal using ProofBuilderList.SetAttributeEqualities. The issuer must
+// sign the carried over attribute as 0 (see IssueRequest). This must be called
+// before Commit.
+func (b *CredentialBuilder) CarryOver(index int, value *big.Int) error {
+	if index <= 0 || index >= len(b.pk.R) {
+		return errors.New("invalid attribute index")
+	}
+	if _, ok := b.mUser[index]; ok {
+		return errors.New("cannot carry over random blind attribute")
+	}
+	// The randomizer of the attribute is shared with credentials of other key sizes
+	// (see SetAttributeEqualities), so it must fit within the smallest size
+	if value.Sign() < 0 || value.BitLen() > int(gabikeys.DefaultSystemParameters[1024].Lm) {
+		return errors.New("attribute too large to carry over")
+	}
+	if b.carried == nil {
+		b.carried = make(map[int]*big.Int)
+	}
+	if _, ok := b.carried[index]; ok {
+		return errors.New("attribute already carried over")
+	}
+	b.carried[index] = new(big.Int).Set(value)
+	b.u.Mul(b.u, new(big.Int).Exp(b.pk.R[index], value, b.pk.N)).Mod(b.u, b.pk.N)
+	return nil
+}
+
+// shareRandomizer configures the builder to use the randomizer with the specified name
+// for the specified carried over attribute (see ProofBuilderList.SetAttributeEqualities).
+func (b *CredentialBuilder) shareRandomizer(index int, name string) error {
+	if _, ok := b.carried[index]; !ok {
+		return errors.New("attribute equality requires a carried over attribute")
+	}
+	if b.sharedRandomizers == nil {
+		b.sharedRandomizers = make(map[int]string)
+	}
+	b.sharedRandomizers[index] = name
+	return nil
 }
 
 func (b *CredentialBuilder) MergeProofPCommitment(commitment *ProofPCommitment) {
@@ -237,6 +432,10 @@ func (b *CredentialBuilder) PublicKey() *gabikeys.PublicKey {
 	return b.pk
 }
 
+func (b *CredentialBuilder) secretKey() *big.Int {
+	return b.secret
+}
+
 // Commit commits to the secret (first attribute) using the provided randomizer.
 // Optionally commits to the user shares of random blind attributes if any are present.
 func (b *CredentialBuilder) Commit(randomizers map[string]*big.Int) ([]*big.Int, error) {
@@ -253,6 +452,16 @@ func (b *CredentialBuilder) Commit(randomizers map[string]*big.Int) ([]*big.Int,
 			return nil, err
 		}
 	}
+	for i := range b.carried {
+		if name, ok := b.sharedRandomizers[i]; ok {
+			b.mUserCommit[i], err = sharedRandomizer(randomizers, name)
+		} else {
+			b.mUserCommit[i], err = common.RandomBigInt(b.pk.Params.LmCommit)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
 
 	// U_commit = U_commit * S^{v_prime_commit} * R_0^{s_commit}
 	sv := new(big.Int).Exp(b.pk.S, b.vPrimeCommit, b.pk.N)
@@ -260,8 +469,8 @@ func (b *CredentialBuilder) Commit(randomizers map[string]*big.Int) ([]*big.Int,
 	b.uCommit.Mul(b.uCommit, sv).Mul(b.uCommit, r0s)
 	b.uCommit.Mod(b.uCommit, b.pk.N)
 
-	// U_commit = U_commit * R_i^{m_iUserCommit} for i in random blind
-	for i := range b.mUser {
+	// U_commit = U_commit * R_i^{m_iUserCommit} for i in random blind or carried over
+	for i := range b.mUserCommit {
 		b.uCommit.Mul(b.uCommit, new(big.Int).Exp(b.pk.R[i], b.mUserCommit[i], b.pk.N))
 		b.uCommit.Mod(b.uCommit, b.pk.N)
 	}
@@ -283,6 +492,9 @@ func (b *CredentialBuilder) CreateProof(challenge *big.Int) Proof {
 	for i, miUser := range b.mUser {
 		mUserResponses[i] = new(big.Int).Add(b.mUserCommit[i], new(big.Int).Mul(challenge, miUser))
 	}
+	for i, mi := range b.carried {
+		mUserResponses[i] = new(big.Int).Add(b.mUserCommit[i], new(big.Int).Mul(challenge, mi))
+	}
 
 	return &ProofU{
 		U:              b.u,
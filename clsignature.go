@@ -17,9 +17,15 @@ import (
 // from the public key. For example given exponents exps[1],...,exps[k] this function returns
 //   R[1]^{exps[1]}*...*R[k]^{exps[k]} (mod N)
 // with R and N coming from the public key. The exponents are hashed if their length
-// exceeds the maximum message length from the public key.
+// exceeds the maximum message length from the public key, except for the first one, the
+// secret key, which may be larger if it is a refreshed keyshare secret (see KeyshareRefresh).
 func RepresentToPublicKey(pk *gabikeys.PublicKey, exps []*big.Int) (*big.Int, error) {
-	return common.RepresentToBases(pk.R, exps, pk.N, pk.Params.Lm), nil
+	if len(exps) == 0 {
+		return big.NewInt(1), nil
+	}
+	r := common.RepresentToBases(pk.R[1:], exps[1:], pk.N, pk.Params.Lm)
+	r.Mul(r, new(big.Int).Exp(pk.R[0], exps[0], pk.N))
+	return r.Mod(r, pk.N), nil
 }
 
 // CLSignature is a data structure for holding a Camenisch-Lysyanskaya signature.
@@ -0,0 +1,283 @@
+package gabi
+
+import (
+	"fmt"
+
+	"github.com/go-errors/errors"
+	"github.com/privacybydesign/gabi/big"
+	"github.com/privacybydesign/gabi/gabikeys"
+	"github.com/privacybydesign/gabi/rangeproof"
+)
+
+// Attribute comparisons prove linear inequalities between undisclosed attributes, possibly of
+// different credentials in a ProofList, using a rangeproof.LinearProof. The linear proof is
+// contained in the ProofD of the first attribute of the comparison (the host), and is computed in
+// the group of its public key. Attributes of other proofs are linked to it as in attribute
+// equalities: they share their randomizer with the linear proof, so that their responses in the
+// linear proof, which are included in the ComparisonProof, equal their responses in their own
+// ProofD. Verifiers check this using ProofList.VerifyAttributeComparisons.
+
+type (
+	// AttributeComparison states that the attributes of its terms satisfy
+	// \sum_i Terms[i].Factor * m_i - Bound >= 0. For example, m_1 >= 3*m_2 is expressed using terms
+	// with factors 1 and -3, and bound 0. The bound must be nonnegative.
+	AttributeComparison struct {
+		Terms []AttributeTerm `json:"terms"`
+		Bound *big.Int        `json:"bound"`
+	}
+
+	// AttributeTerm is a term Factor * m of an AttributeComparison, m being the attribute at
+	// the AttributeIndex.
+	AttributeTerm struct {
+		AttributeIndex
+		Factor int64 `json:"factor"`
+	}
+
+	// ComparisonProof proves an AttributeComparison whose first attribute is contained in the
+	// ProofD containing it.
+	ComparisonProof struct {
+		rangeproof.LinearProof
+
+		Attributes []AttributeIndex `json:"attributes"`
+		// Responses of the attributes of other proofs, by term
+		ExternalResponses map[int]*big.Int `json:"external_responses,omitempty"`
+	}
+
+	// comparison holds the state of a DisclosureProofBuilder for proving an AttributeComparison.
+	comparison struct {
+		statement AttributeComparison
+		structure *rangeproof.LinearProofStructure
+		commit    *rangeproof.LinearProofCommit
+		// Values and randomizer names of the attributes of other proofs, by term
+		externalValues      map[int]*big.Int
+		externalRandomizers map[int]string
+	}
+)
+
+// SetAttributeComparisons configures the builders to prove each of the specified comparisons.
+// This must be called before Challenge, and after SetAttributeEqualities if that is used.
+// Supported are undisclosed attributes of DisclosureProofBuilders, excluding the secret key and
+// the revocation attribute.
+func (builders ProofBuilderList) SetAttributeComparisons(comparisons []AttributeComparison) error {
+	for i, c := range comparisons {
+		if len(c.Terms) == 0 {
+			return errors.New("attribute comparison has no terms")
+		}
+		builder := func(attr AttributeIndex) (*DisclosureProofBuilder, error) {
+			if attr.Proof < 0 || attr.Proof >= len(builders) {
+				return nil, errors.New("attribute comparison refers to nonexisting proof")
+			}
+			d, ok := builders[attr.Proof].(*DisclosureProofBuilder)
+			if !ok {
+				return nil, errors.New("attribute comparison refers to unsupported proof builder")
+			}
+			return d, nil
+		}
+
+		host, err := builder(c.Terms[0].AttributeIndex)
+		if err != nil {
+			return err
+		}
+		factors := make([]int64, len(c.Terms))
+		for j, term := range c.Terms {
+			factors[j] = term.Factor
+		}
+		statement, err := rangeproof.NewLinearStatement(factors, c.Bound)
+		if err != nil {
+			return err
+		}
+		structure, err := statement.ProofStructure(c.Terms[0].Attribute)
+		if err != nil {
+			return err
+		}
+
+		cmp := &comparison{
+			statement:           c,
+			structure:           structure,
+			externalValues:      map[int]*big.Int{},
+			externalRandomizers: map[int]string{},
+		}
+		for j, term := range c.Terms {
+			d, err := builder(term.AttributeIndex)
+			if err != nil {
+				return err
+			}
+			if d == host {
+				if err = host.checkComparisonAttribute(term.Attribute); err != nil {
+					return err
+				}
+				continue
+			}
+			name, ok := d.sharedRandomizers[term.Attribute]
+			if !ok {
+				name = fmt.Sprintf("attribute-comparison-%d-%d", i, j)
+				if err = d.shareRandomizer(term.Attribute, name); err != nil {
+					return err
+				}
+			}
+			cmp.externalValues[j] = d.attributeExponent(term.Attribute)
+			cmp.externalRandomizers[j] = name
+		}
+		host.comparisons = append(host.comparisons, cmp)
+	}
+	return nil
+}
+
+// VerifyAttributeComparisons returns true when each of the specified comparisons is proven by
+// the proof list. This should be called only after the proof list has been verified (see Verify).
+func (pl ProofList) VerifyAttributeComparisons(comparisons []AttributeComparison) bool {
+	for _, c := range comparisons {
+		if len(c.Terms) == 0 || c.Terms[0].Proof < 0 || c.Terms[0].Proof >= len(pl) {
+			return false
+		}
+		host, ok := pl[c.Terms[0].Proof].(*ProofD)
+		if !ok {
+			return false
+		}
+		proven := false
+		for _, proof := range host.ComparisonProofs {
+			if proof.proves(pl, c) {
+				proven = true
+				break
+			}
+		}
+		if !proven {
+			return false
+		}
+	}
+	return true
+}
+
+// proves returns whether the (verified) proof proves the comparison.
+func (p *ComparisonProof) proves(pl ProofList, c AttributeComparison) bool {
+	factors := make([]int64, len(c.Terms))
+	for i, term := range c.Terms {
+		factors[i] = term.Factor
+	}
+	if !p.Proves(&rangeproof.LinearStatement{Factors: factors, Bound: c.Bound}) ||
+		len(p.Attributes) != len(c.Terms) {
+		return false
+	}
+	for i, term := range c.Terms {
+		if p.Attributes[i] != term.AttributeIndex {
+			return false
+		}
+		if term.Proof == c.Terms[0].Proof {
+			continue
+		}
+		// The response of the attribute in the linear proof must equal that in its own proof
+		if term.Proof < 0 || term.Proof >= len(pl) {
+			return false
+		}
+		proof, ok := pl[term.Proof].(*ProofD)
+		if !ok || proof.AResponses[term.Attribute] == nil || p.ExternalResponses[i] == nil ||
+			proof.AResponses[term.Attribute].Cmp(p.ExternalResponses[i]) != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// checkComparisonAttribute checks that the attribute at the specified index can be used in an
+// attribute comparison.
+func (d *DisclosureProofBuilder) checkComparisonAttribute(index int) error {
+	if index <= 0 || index >= len(d.attributes) || !isUndisclosedAttribute(d.disclosedAttributes, index) {
+		return errors.New("attribute comparison requires an undisclosed attribute")
+	}
+	if d.nonrevBuilder != nil && d.attrRandomizers[index] == d.nonrevBuilder.randomizer {
+		return errors.New("attribute comparison on revocation attribute not supported")
+	}
+	return nil
+}
+
+// commitComparisons computes the commitments of the linear proofs of the attribute comparisons
+// of which the builder is the host.
+func (d *DisclosureProofBuilder) commitComparisons(randomizers map[string]*big.Int) ([]*big.Int, error) {
+	var list []*big.Int
+	for _, c := range d.comparisons {
+		values := make([]*big.Int, len(c.statement.Terms))
+		mRandomizers := make([]*big.Int, len(c.statement.Terms))
+		for i, term := range c.statement.Terms {
+			name, ok := c.externalRandomizers[i]
+			if !ok {
+				values[i] = d.attributeExponent(term.Attribute)
+				mRandomizers[i] = d.attrRandomizers[term.Attribute]
+				continue
+			}
+			r, err := sharedRandomizer(randomizers, name)
+			if err != nil {
+				return nil, err
+			}
+			values[i] = c.externalValues[i]
+			mRandomizers[i] = r
+		}
+		contributions, commit, err := c.structure.CommitmentsFromSecrets(d.pk, values, mRandomizers)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, contributions...)
+		c.commit = commit
+	}
+	return list, nil
+}
+
+// createComparisonProofs creates the proofs of the attribute comparisons of which the builder is
+// the host.
+func (d *DisclosureProofBuilder) createComparisonProofs(challenge *big.Int) []*ComparisonProof {
+	var proofs []*ComparisonProof
+	for _, c := range d.comparisons {
+		proof := &ComparisonProof{
+			LinearProof: *c.structure.BuildProof(c.commit, challenge),
+			Attributes:  make([]AttributeIndex, len(c.statement.Terms)),
+		}
+		for i, term := range c.statement.Terms {
+			proof.Attributes[i] = term.AttributeIndex
+			if _, ok := c.externalRandomizers[i]; ok {
+				if proof.ExternalResponses == nil {
+					proof.ExternalResponses = map[int]*big.Int{}
+				}
+				proof.ExternalResponses[i] = proof.MResponses[i]
+			}
+		}
+		proofs = append(proofs, proof)
+	}
+	return proofs
+}
+
+// comparisonContributions returns the challenge contributions of the comparison proofs of the
+// ProofD, setting the responses of its own attributes in them from its attribute responses.
+func (p *ProofD) comparisonContributions(pk *gabikeys.PublicKey) ([]*big.Int, error) {
+	var l []*big.Int
+	for _, proof := range p.ComparisonProofs {
+		if proof == nil || len(proof.Attributes) == 0 || len(proof.Attributes) != len(proof.Factors) {
+			return nil, &VerificationError{Reason: ErrInvalidRangeProof, Err: errors.New("malformed comparison proof")}
+		}
+		if len(proof.Attributes) > rangeproof.MaxLinearTerms {
+			return nil, &VerificationError{Reason: ErrInvalidRangeProof, Err: rangeproof.ErrTooManyTerms}
+		}
+		host := proof.Attributes[0]
+		proof.MResponses = make([]*big.Int, len(proof.Attributes))
+		for i, attr := range proof.Attributes {
+			var response *big.Int
+			if attr.Proof != host.Proof {
+				response = proof.ExternalResponses[i]
+			} else if attr.Attribute > 0 && attr.Attribute < len(pk.R) {
+				response = p.AResponses[attr.Attribute]
+			}
+			if response == nil {
+				return nil, &VerificationError{Reason: ErrInvalidRangeProof,
+					Err: errors.New("comparison proof on nonexisting or disclosed attribute")}
+			}
+			proof.MResponses[i] = new(big.Int).Set(response)
+		}
+		s, err := proof.ExtractStructure(host.Attribute, pk)
+		if err != nil {
+			return nil, &VerificationError{Reason: ErrInvalidRangeProof, Err: err}
+		}
+		if !s.VerifyProofStructure(pk, &proof.LinearProof) {
+			return nil, &VerificationError{Reason: ErrInvalidRangeProof, Err: errors.New("invalid comparison proof structure")}
+		}
+		l = append(l, s.CommitmentsFromProof(pk, &proof.LinearProof, p.C)...)
+	}
+	return l, nil
+}
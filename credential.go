@@ -11,6 +11,7 @@ import (
 	"github.com/privacybydesign/gabi/internal/common"
 	"github.com/privacybydesign/gabi/rangeproof"
 	"github.com/privacybydesign/gabi/revocation"
+	"github.com/privacybydesign/gabi/verenc"
 )
 
 // Credential represents an Idemix credential.
@@ -38,6 +39,19 @@ type DisclosureProofBuilder struct {
 
 	rpStructures map[int][]*rangeproof.ProofStructure
 	rpCommits    map[int][]*rangeproof.ProofCommit
+
+	smStructures map[int][]*rangeproof.SetMembershipProofStructure
+	smCommits    map[int][]*rangeproof.SetMembershipProofCommit
+	neStructures map[int][]*rangeproof.NotEqualProofStructure
+	neCommits    map[int][]*rangeproof.NotEqualProofCommit
+	ivStructures map[int][]*rangeproof.IntervalProofStructure
+	ivCommits    map[int][]*rangeproof.IntervalProofCommit
+	veStructures map[int][]*verenc.ProofStructure
+	veCommits    map[int][]*verenc.ProofCommit
+
+	comparisons []*comparison // attribute comparisons of which this builder is the host
+
+	sharedRandomizers map[int]string // names of randomizers shared with other builders
 }
 
 type NonRevocationProofBuilder struct {
@@ -105,6 +119,30 @@ func isUndisclosedAttribute(disclosedAttributes []int, attribute int) bool {
 	return true
 }
 
+// RefreshKeyshare applies a refresh of the keyshare secret (see KeyshareRefresh) to the
+// credential, by adding the delta returned by KeyshareRefresh.Verify to its secret key and
+// replacing the P of the keyshare server by the specified one. The credential is left unchanged
+// and an error is returned if the refreshed secret key would be out of range (see
+// KeyshareRefresh), or if the refreshed credential would not be valid.
+func (ic *Credential) RefreshKeyshare(delta, keyshareP *big.Int) error {
+	if ic.Signature.KeyshareP == nil {
+		return errors.New("credential does not involve a keyshare server")
+	}
+	secret := new(big.Int).Add(ic.Attributes[0], delta)
+	if secret.Sign() < 0 || uint(secret.BitLen()) > keyshareShareLength() {
+		return ErrInvalidKeyshareRefresh
+	}
+	attrs := append([]*big.Int{secret}, ic.Attributes[1:]...)
+	signature := *ic.Signature
+	signature.KeyshareP = keyshareP
+	if !signature.Verify(ic.Pk, attrs) {
+		return ErrInvalidKeyshareRefresh
+	}
+	ic.Attributes = attrs
+	ic.Signature = &signature
+	return nil
+}
+
 // CreateDisclosureProof creates a disclosure proof (ProofD) voor the provided
 // indices of disclosed attributes.
 func (ic *Credential) CreateDisclosureProof(
@@ -272,6 +310,111 @@ func (ic *Credential) NonrevIndex() (int, error) {
 	return -1, errors.New("revocation attribute not included in credential")
 }
 
+// shareRandomizer configures the builder to use the randomizer with the specified name
+// for the specified attribute (see ProofBuilderList.SetAttributeEqualities).
+func (d *DisclosureProofBuilder) shareRandomizer(index int, name string) error {
+	if index <= 0 || index >= len(d.attributes) || !isUndisclosedAttribute(d.disclosedAttributes, index) {
+		return errors.New("attribute equality requires an undisclosed attribute")
+	}
+	if d.nonrevBuilder != nil && d.attrRandomizers[index] == d.nonrevBuilder.randomizer {
+		return errors.New("attribute equality on revocation attribute not supported")
+	}
+	// The randomizer is shared with credentials of other key sizes, so like the secret key randomizer
+	// it fits within the smallest size, which allows only attributes (or their hashes) of that size
+	if d.attributeExponent(index).BitLen() > int(gabikeys.DefaultSystemParameters[1024].Lm) {
+		return errors.New("attribute too large for attribute equality")
+	}
+	if d.sharedRandomizers == nil {
+		d.sharedRandomizers = make(map[int]string)
+	}
+	d.sharedRandomizers[index] = name
+	return nil
+}
+
+// AddSetMembershipStatement adds a statement to the builder that the undisclosed
+// attribute at the specified index is an element of a set. This must be called
+// before Commit.
+func (d *DisclosureProofBuilder) AddSetMembershipStatement(index int, statement *rangeproof.SetMembershipStatement) error {
+	if index <= 0 || index >= len(d.attributes) || !isUndisclosedAttribute(d.disclosedAttributes, index) {
+		return errors.New("Set membership statements on revealed attributes are not supported")
+	}
+	structure, err := statement.ProofStructure(index)
+	if err != nil {
+		return err
+	}
+	if d.smStructures == nil {
+		d.smStructures = make(map[int][]*rangeproof.SetMembershipProofStructure)
+	}
+	d.smStructures[index] = append(d.smStructures[index], structure)
+	return nil
+}
+
+// AddNotEqualStatement adds a statement to the builder that the undisclosed
+// attribute at the specified index is not equal to a value. This must be called
+// before Commit.
+func (d *DisclosureProofBuilder) AddNotEqualStatement(index int, statement *rangeproof.NotEqualStatement) error {
+	if index <= 0 || index >= len(d.attributes) || !isUndisclosedAttribute(d.disclosedAttributes, index) {
+		return errors.New("Not-equal statements on revealed attributes are not supported")
+	}
+	structure, err := statement.ProofStructure(index)
+	if err != nil {
+		return err
+	}
+	if d.neStructures == nil {
+		d.neStructures = make(map[int][]*rangeproof.NotEqualProofStructure)
+	}
+	d.neStructures[index] = append(d.neStructures[index], structure)
+	return nil
+}
+
+// AddIntervalStatement adds a statement to the builder that the undisclosed
+// attribute at the specified index lies in an interval. This must be called
+// before Commit.
+func (d *DisclosureProofBuilder) AddIntervalStatement(index int, statement *rangeproof.IntervalStatement) error {
+	if index <= 0 || index >= len(d.attributes) || !isUndisclosedAttribute(d.disclosedAttributes, index) {
+		return errors.New("Interval statements on revealed attributes are not supported")
+	}
+	structure, err := statement.ProofStructure(index)
+	if err != nil {
+		return err
+	}
+	if d.ivStructures == nil {
+		d.ivStructures = make(map[int][]*rangeproof.IntervalProofStructure)
+	}
+	d.ivStructures[index] = append(d.ivStructures[index], structure)
+	return nil
+}
+
+// AddVerifiableEncryption adds an encryption of the undisclosed attribute at the
+// specified index to the inspector having the specified public key, under the
+// specified label, to the builder. The proof proves that the ciphertext encrypts
+// the attribute (or its hash if it is too large). This must be called before Commit.
+func (d *DisclosureProofBuilder) AddVerifiableEncryption(index int, pk *verenc.PublicKey, label []byte) error {
+	if index <= 0 || index >= len(d.attributes) || !isUndisclosedAttribute(d.disclosedAttributes, index) {
+		return errors.New("Verifiable encryption of revealed attributes is not supported")
+	}
+	structure, err := verenc.NewProofStructure(index, pk, label)
+	if err != nil {
+		return err
+	}
+	if d.veStructures == nil {
+		d.veStructures = make(map[int][]*verenc.ProofStructure)
+	}
+	d.veStructures[index] = append(d.veStructures[index], structure)
+	return nil
+}
+
+// attributeExponent returns the exponent of the specified attribute in the
+// signature, i.e. its hash if it is too large. The secret key is never hashed (see
+// RepresentToPublicKey).
+func (d *DisclosureProofBuilder) attributeExponent(index int) *big.Int {
+	exp := d.attributes[index]
+	if index != 0 && exp.BitLen() > int(d.pk.Params.Lm) {
+		exp = common.IntHashSha256(exp.Bytes())
+	}
+	return exp
+}
+
 func (d *DisclosureProofBuilder) MergeProofPCommitment(commitment *ProofPCommitment) {
 	d.z.Mod(
 		d.z.Mul(d.z, commitment.Pcommit),
@@ -284,10 +427,21 @@ func (d *DisclosureProofBuilder) PublicKey() *gabikeys.PublicKey {
 	return d.pk
 }
 
+func (d *DisclosureProofBuilder) secretKey() *big.Int {
+	return d.attributes[0]
+}
+
 // Commit commits to the first attribute (the secret) using the provided
 // randomizer.
 func (d *DisclosureProofBuilder) Commit(randomizers map[string]*big.Int) ([]*big.Int, error) {
 	d.attrRandomizers[0] = randomizers["secretkey"]
+	for index, name := range d.sharedRandomizers {
+		r, err := sharedRandomizer(randomizers, name)
+		if err != nil {
+			return nil, err
+		}
+		d.attrRandomizers[index] = r
+	}
 
 	// Z = A^{e_commit} * S^{v_commit}
 	//     PROD_{i \in undisclosed} ( R_i^{a_commits{i}} )
@@ -339,6 +493,70 @@ func (d *DisclosureProofBuilder) Commit(randomizers map[string]*big.Int) ([]*big
 		}
 	}
 
+	if d.smStructures != nil {
+		d.smCommits = make(map[int][]*rangeproof.SetMembershipProofCommit)
+		for index := 0; index < len(d.attributes); index++ {
+			for _, s := range d.smStructures[index] {
+				contributions, commit, err := s.CommitmentsFromSecrets(d.pk, d.attributes[index], d.attrRandomizers[index])
+				if err != nil {
+					return nil, err
+				}
+				list = append(list, contributions...)
+				d.smCommits[index] = append(d.smCommits[index], commit)
+			}
+		}
+	}
+
+	if d.neStructures != nil {
+		d.neCommits = make(map[int][]*rangeproof.NotEqualProofCommit)
+		for index := 0; index < len(d.attributes); index++ {
+			for _, s := range d.neStructures[index] {
+				contributions, commit, err := s.CommitmentsFromSecrets(d.pk, d.attributes[index], d.attrRandomizers[index])
+				if err != nil {
+					return nil, err
+				}
+				list = append(list, contributions...)
+				d.neCommits[index] = append(d.neCommits[index], commit)
+			}
+		}
+	}
+
+	if d.ivStructures != nil {
+		d.ivCommits = make(map[int][]*rangeproof.IntervalProofCommit)
+		for index := 0; index < len(d.attributes); index++ {
+			for _, s := range d.ivStructures[index] {
+				contributions, commit, err := s.CommitmentsFromSecrets(d.pk, d.attributes[index], d.attrRandomizers[index])
+				if err != nil {
+					return nil, err
+				}
+				list = append(list, contributions...)
+				d.ivCommits[index] = append(d.ivCommits[index], commit)
+			}
+		}
+	}
+
+	if d.comparisons != nil {
+		contributions, err := d.commitComparisons(randomizers)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, contributions...)
+	}
+
+	if d.veStructures != nil {
+		d.veCommits = make(map[int][]*verenc.ProofCommit)
+		for index := 0; index < len(d.attributes); index++ {
+			for _, s := range d.veStructures[index] {
+				contributions, commit, err := s.CommitmentsFromSecrets(d.pk, d.attributeExponent(index), d.attrRandomizers[index])
+				if err != nil {
+					return nil, err
+				}
+				list = append(list, contributions...)
+				d.veCommits[index] = append(d.veCommits[index], commit)
+			}
+		}
+	}
+
 	return list, nil
 }
 
@@ -352,11 +570,7 @@ func (d *DisclosureProofBuilder) CreateProof(challenge *big.Int) Proof {
 
 	aResponses := make(map[int]*big.Int)
 	for _, v := range d.undisclosedAttributes {
-		exp := d.attributes[v]
-		if exp.BitLen() > int(d.pk.Params.Lm) {
-			exp = common.IntHashSha256(exp.Bytes())
-		}
-		t := new(big.Int).Mul(challenge, exp)
+		t := new(big.Int).Mul(challenge, d.attributeExponent(v))
 		aResponses[v] = t.Add(d.attrRandomizers[v], t)
 	}
 
@@ -382,6 +596,50 @@ func (d *DisclosureProofBuilder) CreateProof(challenge *big.Int) Proof {
 		}
 	}
 
+	var setMembershipProofs map[int][]*rangeproof.SetMembershipProof
+	if d.smStructures != nil {
+		setMembershipProofs = make(map[int][]*rangeproof.SetMembershipProof)
+		for index, structures := range d.smStructures {
+			for i, s := range structures {
+				setMembershipProofs[index] = append(setMembershipProofs[index],
+					s.BuildProof(d.smCommits[index][i], challenge))
+			}
+		}
+	}
+
+	var notEqualProofs map[int][]*rangeproof.NotEqualProof
+	if d.neStructures != nil {
+		notEqualProofs = make(map[int][]*rangeproof.NotEqualProof)
+		for index, structures := range d.neStructures {
+			for i, s := range structures {
+				notEqualProofs[index] = append(notEqualProofs[index],
+					s.BuildProof(d.neCommits[index][i], challenge))
+			}
+		}
+	}
+
+	var intervalProofs map[int][]*rangeproof.IntervalProof
+	if d.ivStructures != nil {
+		intervalProofs = make(map[int][]*rangeproof.IntervalProof)
+		for index, structures := range d.ivStructures {
+			for i, s := range structures {
+				intervalProofs[index] = append(intervalProofs[index],
+					s.BuildProof(d.ivCommits[index][i], challenge))
+			}
+		}
+	}
+
+	var verifiableEncryptions map[int][]*verenc.Proof
+	if d.veStructures != nil {
+		verifiableEncryptions = make(map[int][]*verenc.Proof)
+		for index, structures := range d.veStructures {
+			for i, s := range structures {
+				verifiableEncryptions[index] = append(verifiableEncryptions[index],
+					s.BuildProof(d.veCommits[index][i], challenge))
+			}
+		}
+	}
+
 	return &ProofD{
 		C:                  challenge,
 		A:                  d.randomizedSignature.A,
@@ -391,6 +649,12 @@ func (d *DisclosureProofBuilder) CreateProof(challenge *big.Int) Proof {
 		ADisclosed:         aDisclosed,
 		NonRevocationProof: nonrevProof,
 		RangeProofs:        rangeProofs,
+
+		SetMembershipProofs:   setMembershipProofs,
+		NotEqualProofs:        notEqualProofs,
+		IntervalProofs:        intervalProofs,
+		ComparisonProofs:      d.createComparisonProofs(challenge),
+		VerifiableEncryptions: verifiableEncryptions,
 	}
 }
 
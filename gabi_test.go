@@ -5,8 +5,11 @@
 package gabi
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"os"
+	"runtime"
 	"testing"
 	"time"
 
@@ -17,6 +20,7 @@ import (
 	"github.com/privacybydesign/gabi/rangeproof"
 	"github.com/privacybydesign/gabi/revocation"
 	"github.com/privacybydesign/gabi/safeprime"
+	"github.com/privacybydesign/gabi/verenc"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -683,6 +687,12 @@ func testRangeProofs(t *testing.T, trueStatements bool, statements []*rangeproof
 		// verifier part: verify disclosure proof and check the proven statement
 		require.NoError(t, err)
 		assert.True(t, proof.Verify(testPubK1, context, nonce, false))
+		requested := map[int][]*rangeproof.Statement{1: statements}
+		assert.True(t, proof.VerifyRangeStatements(requested))
+		assert.True(t, ProofList{proof}.VerifyRangeStatements([]map[int][]*rangeproof.Statement{requested}))
+		assert.False(t, proof.VerifyRangeStatements(map[int][]*rangeproof.Statement{1: statements[1:]}))
+		assert.False(t, proof.VerifyRangeStatements(map[int][]*rangeproof.Statement{3: statements}))
+		assert.False(t, proof.VerifyRangeStatements(nil))
 
 		for i, statement := range statements {
 			typ, factor, bound := proof.RangeProofs[1][i].ProvenStatement()
@@ -704,6 +714,9 @@ func testRangeProofs(t *testing.T, trueStatements bool, statements []*rangeproof
 			}
 			assert.True(t, proof.RangeProofs[1][i].Proves(statement))
 		}
+
+		// Proofs of statements implying the requested ones are not accepted as exact
+		assert.False(t, proof.VerifyRangeStatements(requested))
 	}
 }
 
@@ -813,7 +826,7 @@ func TestBigAttribute(t *testing.T) {
 	assert.True(t, proof.Verify(testPubK, context, nonce1, false), "Failed to verify ProofD with large undisclosed attribute")
 }
 
-func setupRevocation(t *testing.T) (*revocation.Witness, *revocation.Update, *revocation.Accumulator) {
+func setupRevocation(t testing.TB) (*revocation.Witness, *revocation.Update, *revocation.Accumulator) {
 	if !testPrivK.RevocationSupported() {
 		require.NoError(t, gabikeys.GenerateRevocationKeypair(testPrivK, testPubK))
 	}
@@ -864,6 +877,79 @@ func TestNotRevoked(t *testing.T) {
 	require.True(t, ProofList{proofd}.Verify([]*gabikeys.PublicKey{testPubK}, context, nonce, false, nil))
 }
 
+func TestNonRevocationPolicy(t *testing.T) {
+	witness, update, acc := setupRevocation(t)
+	attrs := revocationAttrs(witness)
+	signature, err := SignMessageBlock(testPrivK, testPubK, attrs)
+	require.NoError(t, err)
+	cred := &Credential{
+		Signature:            signature,
+		Pk:                   testPubK,
+		Attributes:           attrs,
+		NonRevocationWitness: witness,
+	}
+	context, err := common.RandomBigInt(testPubK.Params.Lh)
+	require.NoError(t, err)
+	nonce, err := common.RandomBigInt(testPubK.Params.Lstatzk)
+	require.NoError(t, err)
+	proofd, err := cred.CreateDisclosureProof([]int{1, 2}, nil, true, context, nonce)
+	require.NoError(t, err)
+	require.True(t, ProofList{proofd}.Verify([]*gabikeys.PublicKey{testPubK}, context, nonce, false, nil))
+
+	require.NoError(t, proofd.VerifyNonRevocationPolicy(testPubK, &revocation.VerificationPolicy{}))
+	require.NoError(t, proofd.VerifyNonRevocationPolicy(testPubK, &revocation.VerificationPolicy{
+		MaxAge: time.Hour, MinIndex: acc.Index, Latest: acc,
+	}))
+
+	// The verifier knows of a newer accumulator
+	newAcc, _, err := acc.Remove(testPrivK, big.NewInt(7), update.Events[0])
+	require.NoError(t, err)
+	err = proofd.VerifyNonRevocationPolicy(testPubK, &revocation.VerificationPolicy{Latest: newAcc})
+	require.IsType(t, &VerificationError{}, err)
+	require.Equal(t, ErrStaleAccumulator, err.(*VerificationError).Reason)
+	require.IsType(t, &revocation.StaleAccumulatorError{}, err.(*VerificationError).Err)
+	require.Equal(t, acc.Index, err.(*VerificationError).Err.(*revocation.StaleAccumulatorError).Index)
+	err = proofd.VerifyNonRevocationPolicy(testPubK, &revocation.VerificationPolicy{MinIndex: newAcc.Index})
+	require.IsType(t, &VerificationError{}, err)
+	require.Equal(t, ErrStaleAccumulator, err.(*VerificationError).Reason)
+
+	// The policy is checked for each proof in the list, after verifying the list
+	proof, err := cred.CreateDisclosureProof([]int{1, 2}, nil, false, context, nonce)
+	require.NoError(t, err)
+	builders := ProofBuilderList{}
+	for _, nonrev := range []bool{false, true} {
+		builder, err := cred.CreateDisclosureProofBuilder([]int{1}, nil, nonrev)
+		require.NoError(t, err)
+		builders = append(builders, builder)
+	}
+	proofs, err := builders.BuildProofList(context, nonce, false)
+	require.NoError(t, err)
+	keys := []*gabikeys.PublicKey{testPubK, testPubK}
+	require.NoError(t, proofs.VerifyWithNonRevocationPolicy(keys, context, nonce, false, nil, nil))
+	require.NoError(t, proofs.VerifyWithNonRevocationPolicy(keys, context, nonce, false, nil,
+		&revocation.VerificationPolicy{Latest: acc}))
+	err = proofs.VerifyWithNonRevocationPolicy(keys, context, nonce, false, nil,
+		&revocation.VerificationPolicy{Latest: newAcc})
+	require.IsType(t, &VerificationError{}, err)
+	require.Equal(t, 1, err.(*VerificationError).Index)
+	require.Equal(t, ErrStaleAccumulator, err.(*VerificationError).Reason)
+	err = ProofList{proofs[0], proofd}.VerifyWithNonRevocationPolicy(keys, context, nonce, false, nil,
+		&revocation.VerificationPolicy{})
+	require.IsType(t, &VerificationError{}, err)
+	require.Equal(t, ErrChallengeMismatch, err.(*VerificationError).Reason)
+
+	// Proofs without or with invalid nonrevocation proofs
+	err = proof.VerifyNonRevocationPolicy(testPubK, &revocation.VerificationPolicy{})
+	require.IsType(t, &VerificationError{}, err)
+	require.Equal(t, ErrInvalidNonRevocationProof, err.(*VerificationError).Reason)
+	sacc := *proofd.NonRevocationProof.SignedAccumulator
+	sacc.Accumulator, sacc.PKCounter = nil, sacc.PKCounter+1
+	proofd.NonRevocationProof.SignedAccumulator = &sacc
+	err = proofd.VerifyNonRevocationPolicy(testPubK, &revocation.VerificationPolicy{})
+	require.IsType(t, &VerificationError{}, err)
+	require.Equal(t, ErrInvalidNonRevocationProof, err.(*VerificationError).Reason)
+}
+
 func TestRevoked(t *testing.T) {
 	witness, update, acc := setupRevocation(t)
 
@@ -941,6 +1027,71 @@ func TestFullIssueAndShowWithRevocation(t *testing.T) {
 	require.Equal(t, cache.index, acc.Index)
 }
 
+func TestRevocationAuthority(t *testing.T) {
+	p, err := safeprime.Generate(128, nil)
+	require.NoError(t, err)
+	var q *big.Int
+	for q == nil || q.Cmp(p) == 0 {
+		q, err = safeprime.Generate(128, nil)
+		require.NoError(t, err)
+	}
+	raSk, raPk, err := gabikeys.NewRevocationKeys(p, q, 3)
+	require.NoError(t, err)
+
+	// The issuer public key references the key of the revocation authority, surviving serialization
+	pk := *testPubK
+	pk.RevocationAuthority = raPk
+	var buf bytes.Buffer
+	_, err = pk.WriteTo(&buf)
+	require.NoError(t, err)
+	parsed, err := gabikeys.NewPublicKeyFromBytes(buf.Bytes())
+	require.NoError(t, err)
+	require.NotNil(t, parsed.RevocationAuthority)
+	require.Equal(t, raPk.N, parsed.RevocationAuthority.N)
+	require.Equal(t, raPk.ECDSA, parsed.RevocationAuthority.ECDSA)
+	require.True(t, parsed.RevocationSupported())
+	parsed.Params = testPubK.Params
+
+	// The revocation authority manages the accumulator using its own key
+	update, err := revocation.NewAccumulator(raSk.PrivateKey())
+	require.NoError(t, err)
+	acc, err := update.Verify(parsed)
+	require.NoError(t, err)
+	witness, err := revocation.RandomWitness(raSk.PrivateKey(), acc)
+	require.NoError(t, err)
+	witness.SignedAccumulator = update.SignedAccumulator
+	require.NoError(t, witness.Verify(parsed))
+	sacc := &revocation.SignedAccumulator{Data: update.SignedAccumulator.Data, PKCounter: testPubK.Counter}
+	_, err = sacc.UnmarshalVerify(testPubK)
+	require.Error(t, err)
+
+	attrs := revocationAttrs(witness)
+	signature, err := SignMessageBlock(testPrivK, parsed, attrs)
+	require.NoError(t, err)
+	cred := &Credential{
+		Signature:            signature,
+		Pk:                   parsed,
+		Attributes:           attrs,
+		NonRevocationWitness: witness,
+	}
+
+	context, err := common.RandomBigInt(testPubK.Params.Lh)
+	require.NoError(t, err)
+	nonce, err := common.RandomBigInt(testPubK.Params.Lstatzk)
+	require.NoError(t, err)
+	proofd, err := cred.CreateDisclosureProof([]int{1, 2}, nil, true, context, nonce)
+	require.NoError(t, err)
+	require.NotNil(t, proofd.NonRevocationProof)
+	require.True(t, ProofList{proofd}.Verify([]*gabikeys.PublicKey{parsed}, context, nonce, false, nil))
+
+	// Revocation by the revocation authority invalidates the witness
+	acc, event, err := acc.Remove(raSk.PrivateKey(), witness.E, update.Events[0])
+	require.NoError(t, err)
+	update, err = revocation.NewUpdate(raSk.PrivateKey(), acc, []*revocation.Event{event})
+	require.NoError(t, err)
+	require.Equal(t, revocation.ErrorRevoked, witness.Update(parsed, update))
+}
+
 func TestKeyshare(t *testing.T) {
 	secret, err := NewKeyshareSecret()
 	require.NoError(t, err)
@@ -958,6 +1109,1029 @@ func TestKeyshare(t *testing.T) {
 			testPubK.N))
 }
 
+func TestKeyshareProofPVerify(t *testing.T) {
+	secret, err := NewKeyshareSecret()
+	require.NoError(t, err)
+	commit, W, err := NewKeyshareCommitments(secret, []*gabikeys.PublicKey{testPubK})
+	require.NoError(t, err)
+	challenge := big.NewInt(123)
+
+	response := KeyshareResponse(secret, commit, challenge, testPubK)
+	require.NoError(t, response.Verify(testPubK, W[0], challenge))
+	require.Equal(t, ErrInvalidProofP, response.Verify(testPubK, W[0], big.NewInt(124)))
+	require.Equal(t, ErrInvalidProofP, response.Verify(testPubK1, W[0], challenge))
+
+	// A response using another secret or commit is detected
+	other, err := NewKeyshareSecret()
+	require.NoError(t, err)
+	response = KeyshareResponse(other, commit, challenge, testPubK)
+	require.Equal(t, ErrInvalidProofP, response.Verify(testPubK, W[0], challenge))
+	response = KeyshareResponse(secret, commit, challenge, testPubK)
+	response.P = W[0].P
+	response.SResponse.Add(response.SResponse, big.NewInt(1))
+	require.Equal(t, ErrInvalidProofP, response.Verify(testPubK, W[0], challenge))
+}
+
+func TestKeyshareRefresh(t *testing.T) {
+	context, err := common.RandomBigInt(testPubK.Params.Lh)
+	require.NoError(t, err)
+	nonce1, err := common.RandomBigInt(testPubK.Params.Lstatzk)
+	require.NoError(t, err)
+	nonce2, err := common.RandomBigInt(testPubK.Params.Lstatzk)
+	require.NoError(t, err)
+	secret, err := common.RandomBigInt(testPubK.Params.Lm - 1)
+	require.NoError(t, err)
+	keyshareSecret, err := NewKeyshareSecret()
+	require.NoError(t, err)
+	keys := []*gabikeys.PublicKey{testPubK}
+	sum := new(big.Int).Add(secret, keyshareSecret)
+
+	// Issue a credential involving the keyshare server, which verifies its ProofP
+	issue := func(secret, keyshareSecret *big.Int) *Credential {
+		commit, W, err := NewKeyshareCommitments(keyshareSecret, keys)
+		require.NoError(t, err)
+		b, err := NewCredentialBuilder(testPubK, context, secret, nonce2, nil)
+		require.NoError(t, err)
+		b.MergeProofPCommitment(W[0])
+		builders := ProofBuilderList{b}
+		challenge, err := builders.Challenge(context, nonce1, false)
+		require.NoError(t, err)
+		proofs, err := builders.BuildDistributedProofList(challenge, nil)
+		require.NoError(t, err)
+		proofP := KeyshareResponse(keyshareSecret, commit, challenge, testPubK)
+		sigs, err := NewIssuer(testPrivK, testPubK, context).IssueSignatures(b.CreateIssueCommitmentMessage(proofs), keys,
+			context, nonce1, nil, []*ProofP{proofP}, W, []*IssueRequest{{Attributes: testAttributes1}})
+		require.NoError(t, err)
+		cred, err := b.ConstructCredential(sigs[0], testAttributes1)
+		require.NoError(t, err)
+		return cred
+	}
+	cred := issue(secret, keyshareSecret)
+	userPublicShare := KeyshareUserPublicShare(secret)
+
+	// Contributions of the client out of range are rejected by the keyshare server
+	for _, contribution := range []*big.Int{
+		big.NewInt(-1),
+		new(big.Int).Lsh(big.NewInt(1), keyshareRefreshLength()),
+	} {
+		session, _, err := NewKeyshareRefreshSession(keyshareSecret)
+		require.NoError(t, err)
+		_, _, err = session.Refresh(contribution, keys)
+		require.Equal(t, ErrInvalidKeyshareRefresh, err)
+		require.Nil(t, session.Delta())
+	}
+
+	refreshKeyshare := func() {
+		// The keyshare server commits to its contribution, the client sends its own,
+		// and the keyshare server refreshes its secret
+		session, commitment, err := NewKeyshareRefreshSession(keyshareSecret)
+		require.NoError(t, err)
+		contribution, err := NewKeyshareRefreshContribution()
+		require.NoError(t, err)
+		newKeyshareSecret, refresh, err := session.Refresh(contribution, keys)
+		require.NoError(t, err)
+		require.True(t, newKeyshareSecret.Sign() < 0)
+		require.True(t, uint(newKeyshareSecret.BitLen()) < keyshareShareLength())
+		_, _, err = session.Refresh(contribution, keys)
+		require.Error(t, err)
+
+		// The client checks the opening and the new P's, and applies the delta
+		previous := []*big.Int{cred.Signature.KeyshareP}
+		delta, err := refresh.Verify(keys, previous, commitment, contribution)
+		require.NoError(t, err)
+		require.Equal(t, session.Delta(), delta)
+		_, err = refresh.Verify(keys, []*big.Int{refresh.P[0]}, commitment, contribution)
+		require.Equal(t, ErrInvalidKeyshareRefresh, err)
+		_, err = refresh.Verify(keys, previous, commitment, new(big.Int).Add(contribution, big.NewInt(1)))
+		require.Equal(t, ErrInvalidKeyshareRefresh, err)
+		_, err = refresh.Verify(keys, previous, &KeyshareRefreshCommitment{Commitment: big.NewInt(1)}, contribution)
+		require.Equal(t, ErrInvalidKeyshareRefresh, err)
+		invalid := *refresh
+		invalid.Opening = new(big.Int).Add(refresh.Opening, big.NewInt(1))
+		_, err = invalid.Verify(keys, previous, commitment, contribution)
+		require.Equal(t, ErrInvalidKeyshareRefresh, err)
+		invalid = *refresh
+		invalid.Delta = big.NewInt(-1)
+		_, err = invalid.Verify(keys, previous, commitment, contribution)
+		require.Equal(t, ErrInvalidKeyshareRefresh, err)
+
+		oldSecret := cred.Attributes[0]
+		require.Equal(t, ErrInvalidKeyshareRefresh, cred.RefreshKeyshare(new(big.Int).Add(delta, big.NewInt(1)), refresh.P[0]))
+		require.Equal(t, ErrInvalidKeyshareRefresh, cred.RefreshKeyshare(new(big.Int).Neg(new(big.Int).Add(oldSecret, big.NewInt(1))), refresh.P[0]))
+		require.Equal(t, oldSecret, cred.Attributes[0])
+		require.NoError(t, cred.RefreshKeyshare(delta, refresh.P[0]))
+		newSecret := cred.Attributes[0]
+		require.True(t, newSecret.Sign() >= 0 && uint(newSecret.BitLen()) <= keyshareShareLength())
+		require.Equal(t, sum, new(big.Int).Add(newSecret, newKeyshareSecret))
+
+		// The client proves that it correctly refreshed its secret
+		proofs, err := ProofBuilderList{NewKeyshareRefreshProofBuilder(newSecret)}.BuildProofList(context, nonce1, false)
+		require.NoError(t, err)
+		proof := proofs[0].(*ProofNym)
+		require.NoError(t, VerifyKeyshareRefreshProof(proof, userPublicShare, session.Delta(), context, nonce1))
+		require.Equal(t, KeyshareUserPublicShare(newSecret), proof.Nym)
+		require.Equal(t, ErrInvalidKeyshareRefresh,
+			VerifyKeyshareRefreshProof(proof, userPublicShare, new(big.Int).Add(delta, big.NewInt(1)), context, nonce1))
+		require.Equal(t, ErrInvalidKeyshareRefresh, VerifyKeyshareRefreshProof(proof, userPublicShare, delta, context, nonce2))
+		proofs, err = ProofBuilderList{NewKeyshareRefreshProofBuilder(oldSecret)}.BuildProofList(context, nonce1, false)
+		require.NoError(t, err)
+		require.Equal(t, ErrInvalidKeyshareRefresh,
+			VerifyKeyshareRefreshProof(proofs[0].(*ProofNym), userPublicShare, delta, context, nonce1))
+
+		keyshareSecret = newKeyshareSecret
+		userPublicShare = proof.Nym
+	}
+
+	// Refreshed shares do not grow under repeated refreshes
+	refreshKeyshare()
+	refreshKeyshare()
+	secret = cred.Attributes[0]
+
+	// The refreshed shares can be used to disclose attributes and pseudonyms
+	commit, W, err := NewKeyshareCommitments(keyshareSecret, keys)
+	require.NoError(t, err)
+	db, err := cred.CreateDisclosureProofBuilder([]int{1}, nil, false)
+	require.NoError(t, err)
+	db.MergeProofPCommitment(W[0])
+	nb := NewPseudonymProofBuilder("example.com", secret)
+	nb.MergeProofPCommitment(NewKeysharePseudonymCommitment(keyshareSecret, commit, "example.com"))
+	require.Equal(t, Pseudonym("example.com", sum), nb.Pseudonym())
+	builders := ProofBuilderList{db, nb}
+	challenge, err := builders.Challenge(context, nonce1, false)
+	require.NoError(t, err)
+	proofP := KeyshareResponse(keyshareSecret, commit, challenge, testPubK)
+	require.NoError(t, proofP.Verify(testPubK, W[0], challenge))
+	proofs, err := builders.BuildDistributedProofList(challenge, []*ProofP{proofP, proofP})
+	require.NoError(t, err)
+	require.True(t, proofs.Verify([]*gabikeys.PublicKey{testPubK, testPubK}, context, nonce1, false, nil))
+
+	// and to issue new credentials
+	issue(secret, keyshareSecret)
+}
+
+func TestThresholdKeyshare(t *testing.T) {
+	context, err := common.RandomBigInt(testPubK.Params.Lh)
+	require.NoError(t, err)
+	nonce1, err := common.RandomBigInt(testPubK.Params.Lstatzk)
+	require.NoError(t, err)
+	nonce2, err := common.RandomBigInt(testPubK.Params.Lstatzk)
+	require.NoError(t, err)
+	secret, err := common.RandomBigInt(testPubK.Params.Lm - 1)
+	require.NoError(t, err)
+
+	_, err = NewThresholdKeyshareSecret(1, 3)
+	require.Error(t, err)
+	_, err = NewThresholdKeyshareSecret(15, 30)
+	require.Equal(t, ErrTooManyKeyshareSubShares, err)
+	shares, err := NewThresholdKeyshareSecret(4, 20) // 20 choose 3 = 1140
+	require.Equal(t, ErrTooManyKeyshareSubShares, err)
+	require.Nil(t, shares)
+	shares, err = NewThresholdKeyshareSecret(11, 11)
+	require.NoError(t, err)
+	require.Len(t, shares[0].SubShares, 1)
+	shares, err = NewThresholdKeyshareSecret(2, 3)
+	require.NoError(t, err)
+	for _, share := range shares {
+		require.NoError(t, share.Verify())
+	}
+
+	// The keyshare secret is the sum of all distinct subshares, and fits within the size of NewKeyshareSecret
+	keyshareSecret := big.NewInt(0)
+	for _, subshare := range append(shares[0].SubShares, shares[1].SubShares[0]) {
+		keyshareSecret.Add(keyshareSecret, subshare.Value)
+	}
+	require.LessOrEqual(t, uint(keyshareSecret.BitLen()), gabikeys.DefaultSystemParameters[1024].Lm-1)
+	keyshareP := new(big.Int).Exp(testPubK.R[0], keyshareSecret, testPubK.N)
+
+	// runs a session with the specified keyshare servers, returning the combined ProofP and commitment
+	session := func(builder ProofBuilder, participants []int) (ProofList, *ProofP, *ProofPCommitment) {
+		commits := make([]*big.Int, len(participants))
+		commitments := make([]*ProofPCommitment, len(participants))
+		for i, p := range participants {
+			var W []*ProofPCommitment
+			commits[i], W, err = NewThresholdKeyshareCommitments(shares[p], participants, []*gabikeys.PublicKey{testPubK})
+			require.NoError(t, err)
+			commitments[i] = W[0]
+		}
+		combined := CombineProofPCommitments(testPubK, commitments)
+		require.Equal(t, keyshareP, combined.P)
+		builder.MergeProofPCommitment(combined)
+
+		builders := ProofBuilderList{builder}
+		challenge, err := builders.Challenge(context, nonce1, false)
+		require.NoError(t, err)
+		proofPs := make([]*ProofP, len(participants))
+		for i, p := range participants {
+			proofPs[i], err = ThresholdKeyshareResponse(shares[p], participants, commits[i], challenge, testPubK)
+			require.NoError(t, err)
+			require.NoError(t, proofPs[i].Verify(testPubK, commitments[i], challenge))
+		}
+		proofP, err := CombineProofPs(testPubK, proofPs)
+		require.NoError(t, err)
+		require.NoError(t, proofP.Verify(testPubK, combined, challenge))
+		proofs, err := builders.BuildDistributedProofList(challenge, nil)
+		require.NoError(t, err)
+		return proofs, proofP, combined
+	}
+
+	// Any two keyshare servers can cooperate in issuance
+	issuer := NewIssuer(testPrivK, testPubK, context)
+	for _, participants := range [][]int{{0, 1}, {0, 2}, {1, 2}, {0, 1, 2}} {
+		b, err := NewCredentialBuilder(testPubK, context, secret, nonce2, nil)
+		require.NoError(t, err)
+		proofs, proofP, commitment := session(b, participants)
+		sigs, err := issuer.IssueSignatures(b.CreateIssueCommitmentMessage(proofs), []*gabikeys.PublicKey{testPubK},
+			context, nonce1, nil, []*ProofP{proofP}, []*ProofPCommitment{commitment}, []*IssueRequest{{Attributes: testAttributes1}})
+		require.NoError(t, err)
+		cred, err := b.ConstructCredential(sigs[0], testAttributes1)
+		require.NoError(t, err)
+		require.Equal(t, keyshareP, cred.Signature.KeyshareP)
+	}
+
+	// A single keyshare server cannot act on behalf of the user
+	_, _, err = NewThresholdKeyshareCommitments(shares[0], []int{0}, []*gabikeys.PublicKey{testPubK})
+	require.Equal(t, ErrTooFewKeyshareServers, err)
+	_, _, err = NewThresholdKeyshareCommitments(shares[0], []int{1, 2}, []*gabikeys.PublicKey{testPubK})
+	require.Error(t, err)
+
+	// Shares with missing subshares are rejected
+	shares[0].SubShares = shares[0].SubShares[1:]
+	require.Equal(t, ErrInvalidKeyshareShare, shares[0].Verify())
+}
+
+func TestIssueSignatures(t *testing.T) {
+	context, err := common.RandomBigInt(testPubK.Params.Lh)
+	require.NoError(t, err)
+	nonce1, err := common.RandomBigInt(testPubK.Params.Lstatzk)
+	require.NoError(t, err)
+	nonce2, err := common.RandomBigInt(testPubK.Params.Lstatzk)
+	require.NoError(t, err)
+	secret, err := common.RandomBigInt(testPubK.Params.Lm - 1)
+	require.NoError(t, err)
+	keyshareSecret, err := NewKeyshareSecret()
+	require.NoError(t, err)
+
+	createMessage := func() (*CredentialBuilder, *IssueCommitmentMessage, *ProofP, []*ProofPCommitment) {
+		commit, W, err := NewKeyshareCommitments(keyshareSecret, []*gabikeys.PublicKey{testPubK})
+		require.NoError(t, err)
+		b, err := NewCredentialBuilder(testPubK, context, secret, nonce2, nil)
+		require.NoError(t, err)
+		b.MergeProofPCommitment(W[0])
+		builders := ProofBuilderList([]ProofBuilder{b})
+		challenge, err := builders.Challenge(context, nonce1, false)
+		require.NoError(t, err)
+		proofs, err := builders.BuildDistributedProofList(challenge, nil)
+		require.NoError(t, err)
+		return b, b.CreateIssueCommitmentMessage(proofs), KeyshareResponse(keyshareSecret, commit, challenge, testPubK), W
+	}
+	issuer := NewIssuer(testPrivK, testPubK, context)
+	pks := []*gabikeys.PublicKey{testPubK}
+	requests := []*IssueRequest{{Attributes: testAttributes1}}
+
+	b, msg, proofP, W := createMessage()
+	sigs, err := issuer.IssueSignatures(msg, pks, context, nonce1, nil, []*ProofP{proofP}, W, requests)
+	require.NoError(t, err)
+	require.Len(t, sigs, 1)
+	_, err = b.ConstructCredential(sigs[0], testAttributes1)
+	require.NoError(t, err)
+
+	// Verification merges the ProofP into a copy of the proofs, so it can be repeated
+	sResponse := new(big.Int).Set(msg.Proofs[0].(*ProofU).SResponse)
+	require.NoError(t, msg.Verify(pks, context, nonce1, nil, []*ProofP{proofP}, W))
+	require.NoError(t, msg.Verify(pks, context, nonce1, nil, []*ProofP{proofP}, W))
+	require.Equal(t, sResponse, msg.Proofs[0].(*ProofU).SResponse)
+
+	// ProofPs must be accompanied by the commitments of the keyshare server
+	require.True(t, errors.Is(msg.Verify(pks, context, nonce1, nil, []*ProofP{proofP}, nil), ErrInvalidProofP))
+
+	// A ProofP having the right challenge but not matching the commitment is rejected
+	_, msg, proofP, W = createMessage()
+	proofP.SResponse.Add(proofP.SResponse, big.NewInt(1))
+	_, err = issuer.IssueSignatures(msg, pks, context, nonce1, nil, []*ProofP{proofP}, W, requests)
+	require.True(t, errors.Is(err, ErrInvalidProofP))
+
+	// Without the ProofP of the keyshare server the proofs do not verify
+	_, msg, _, _ = createMessage()
+	_, err = issuer.IssueSignatures(msg, pks, context, nonce1, nil, nil, nil, requests)
+	require.True(t, errors.Is(err, ErrInvalidCommitmentProofs))
+
+	// A ProofP over another challenge is rejected
+	_, msg, proofP, W = createMessage()
+	proofP.C.Add(proofP.C, big.NewInt(1))
+	_, err = issuer.IssueSignatures(msg, pks, context, nonce1, nil, []*ProofP{proofP}, W, requests)
+	require.True(t, errors.Is(err, ErrInvalidProofP))
+
+	// Proofs bound to another nonce are rejected
+	_, msg, proofP, W = createMessage()
+	_, err = issuer.IssueSignatures(msg, pks, context, nonce2, nil, []*ProofP{proofP}, W, requests)
+	require.True(t, errors.Is(err, ErrInvalidCommitmentProofs))
+
+	// The amount of requests must match the amount of ProofU's
+	_, msg, proofP, W = createMessage()
+	_, err = issuer.IssueSignatures(msg, pks, context, nonce1, nil, []*ProofP{proofP}, W, append(requests, requests...))
+	require.True(t, errors.Is(err, ErrIssueRequestCount))
+
+	// User shares are only accepted for random blind attributes
+	_, msg, proofP, W = createMessage()
+	_, err = issuer.IssueSignatures(msg, pks, context, nonce1, nil, []*ProofP{proofP}, W,
+		[]*IssueRequest{{Attributes: testAttributes3, Blind: []int{2}}})
+	require.True(t, errors.Is(err, ErrUnexpectedUserShares))
+}
+
+func TestBatchIssuance(t *testing.T) {
+	witness, _, _ := setupRevocation(t)
+	context, err := common.RandomBigInt(testPubK.Params.Lh)
+	require.NoError(t, err)
+	nonce1, err := common.RandomBigInt(testPubK.Params.Lstatzk)
+	require.NoError(t, err)
+	nonce2, err := common.RandomBigInt(testPubK.Params.Lstatzk)
+	require.NoError(t, err)
+	secret, err := common.RandomBigInt(testPubK.Params.Lm)
+	require.NoError(t, err)
+
+	pks := []*gabikeys.PublicKey{testPubK, testPubK1, testPubK2}
+	issuers := BatchIssuer{
+		NewIssuer(testPrivK, testPubK, context),
+		NewIssuer(testPrivK1, testPubK1, context),
+		NewIssuer(testPrivK2, testPubK2, context),
+	}
+	builders := make([]*CredentialBuilder, len(pks))
+	proofBuilders := make(ProofBuilderList, len(pks))
+	for i, pk := range pks {
+		builders[i], err = NewCredentialBuilder(pk, context, secret, nonce2, nil)
+		require.NoError(t, err)
+		proofBuilders[i] = builders[i]
+	}
+	proofs, err := proofBuilders.BuildProofList(context, nonce1, false)
+	require.NoError(t, err)
+	msg := builders[0].CreateIssueCommitmentMessage(proofs)
+
+	attrs := [][]*big.Int{revocationAttrs(witness), testAttributes1, testAttributes2}
+	requests := []*IssueRequest{{Attributes: attrs[0], Witness: witness}, {Attributes: attrs[1]}, {Attributes: attrs[2]}}
+	_, err = issuers[1:].IssueSignatures(msg, pks, context, nonce1, nil, nil, nil, requests)
+	require.True(t, errors.Is(err, ErrIssuerPublicKeyMismatch))
+	sigs, err := issuers.IssueSignatures(msg, pks, context, nonce1, nil, nil, nil, requests)
+	require.NoError(t, err)
+	require.Len(t, sigs, len(pks))
+
+	// A single invalid signature causes all credentials to be rejected
+	invalid := *sigs[2]
+	invalid.Signature = &CLSignature{A: sigs[2].Signature.A, E: sigs[2].Signature.E, V: big.NewInt(1)}
+	creds, err := ConstructCredentials(builders, []*IssueSignatureMessage{sigs[0], sigs[1], &invalid}, attrs)
+	require.Equal(t, ErrIncorrectAttributeSignature, err)
+	require.Nil(t, creds)
+	require.True(t, witness.Updated.IsZero())
+
+	creds, err = ConstructCredentials(builders, sigs, attrs)
+	require.NoError(t, err)
+	require.Len(t, creds, len(pks))
+	require.False(t, witness.Updated.IsZero())
+
+	// The credentials can be used together
+	disclosureBuilders := make(ProofBuilderList, len(creds))
+	for i, cred := range creds {
+		disclosureBuilders[i], err = cred.CreateDisclosureProofBuilder([]int{1}, nil, false)
+		require.NoError(t, err)
+	}
+	proofs, err = disclosureBuilders.BuildProofList(context, nonce1, false)
+	require.NoError(t, err)
+	require.True(t, proofs.Verify(pks, context, nonce1, false, nil))
+}
+
+func TestPseudonym(t *testing.T) {
+	context, err := common.RandomBigInt(testPubK.Params.Lh)
+	require.NoError(t, err)
+	nonce, err := common.RandomBigInt(testPubK.Params.Lstatzk)
+	require.NoError(t, err)
+	secret, err := common.RandomBigInt(testPubK.Params.Lm)
+	require.NoError(t, err)
+
+	cred := createCredential(t, context, secret, NewIssuer(testPrivK, testPubK, context))
+	db, err := cred.CreateDisclosureProofBuilder([]int{1}, nil, false)
+	require.NoError(t, err)
+	nb := NewPseudonymProofBuilder("example.com", secret)
+	require.Equal(t, Pseudonym("example.com", secret), nb.Pseudonym())
+	require.NotEqual(t, Pseudonym("example.org", secret), nb.Pseudonym())
+
+	proofs, err := ProofBuilderList{db, nb}.BuildProofList(context, nonce, false)
+	require.NoError(t, err)
+	pks := []*gabikeys.PublicKey{testPubK, nil}
+	require.True(t, proofs.Verify(pks, context, nonce, false, nil))
+
+	// Proofs survive JSON serialization
+	bts, err := json.Marshal(proofs)
+	require.NoError(t, err)
+	var decoded ProofList
+	require.NoError(t, json.Unmarshal(bts, &decoded))
+	require.IsType(t, &ProofNym{}, decoded[1])
+	require.True(t, decoded.Verify(pks, context, nonce, false, nil))
+
+	// A pseudonym of another secret key does not verify
+	other, err := common.RandomBigInt(testPubK.Params.Lm)
+	require.NoError(t, err)
+	proofs, err = ProofBuilderList{db, NewPseudonymProofBuilder("example.com", other)}.BuildProofList(context, nonce, false)
+	require.NoError(t, err)
+	require.False(t, proofs.Verify(pks, context, nonce, false, nil))
+
+	// A proof for one scope is not accepted in another
+	proofs, err = ProofBuilderList{NewPseudonymProofBuilder("example.com", secret)}.BuildProofList(context, nonce, false)
+	require.NoError(t, err)
+	require.True(t, proofs[0].(*ProofNym).Verify("example.com", context, nonce, false))
+	require.False(t, proofs[0].(*ProofNym).Verify("example.org", context, nonce, false))
+
+	// Oversized responses are rejected before they are used
+	proof := proofs[0].(*ProofNym)
+	proof.SResponse.Add(proof.SResponse, new(big.Int).Lsh(big.NewInt(1), secretKeyResponseLength()))
+	_, err = proof.ChallengeContribution(nil)
+	require.Error(t, err)
+	require.False(t, proof.Verify("example.com", context, nonce, false))
+}
+
+func TestPseudonymKeyshare(t *testing.T) {
+	context, err := common.RandomBigInt(testPubK.Params.Lh)
+	require.NoError(t, err)
+	nonce, err := common.RandomBigInt(testPubK.Params.Lstatzk)
+	require.NoError(t, err)
+	secret, err := common.RandomBigInt(testPubK.Params.Lm - 1)
+	require.NoError(t, err)
+	keyshareSecret, err := NewKeyshareSecret()
+	require.NoError(t, err)
+
+	randomizer, _, err := NewKeyshareCommitments(keyshareSecret, []*gabikeys.PublicKey{testPubK})
+	require.NoError(t, err)
+	nb := NewPseudonymProofBuilder("example.com", secret)
+	nb.MergeProofPCommitment(NewKeysharePseudonymCommitment(keyshareSecret, randomizer, "example.com"))
+	require.Equal(t, Pseudonym("example.com", new(big.Int).Add(secret, keyshareSecret)), nb.Pseudonym())
+
+	builders := ProofBuilderList{nb}
+	challenge, err := builders.Challenge(context, nonce, false)
+	require.NoError(t, err)
+	proofP := KeyshareResponse(keyshareSecret, randomizer, challenge, testPubK)
+	proofs, err := builders.BuildDistributedProofList(challenge, []*ProofP{proofP})
+	require.NoError(t, err)
+	require.True(t, proofs[0].(*ProofNym).Verify("example.com", context, nonce, false))
+}
+
+func TestAttributeEquality(t *testing.T) {
+	context, err := common.RandomBigInt(testPubK.Params.Lh)
+	require.NoError(t, err)
+	nonce, err := common.RandomBigInt(testPubK.Params.Lstatzk)
+	require.NoError(t, err)
+	secret, err := common.RandomBigInt(testPubK.Params.Lm)
+	require.NoError(t, err)
+
+	newCredential := func(sk *gabikeys.PrivateKey, pk *gabikeys.PublicKey, attrs []*big.Int) *Credential {
+		attrs = append([]*big.Int{secret}, attrs...)
+		signature, err := SignMessageBlock(sk, pk, attrs)
+		require.NoError(t, err)
+		return &Credential{Pk: pk, Attributes: attrs, Signature: signature}
+	}
+	cred1 := newCredential(testPrivK1, testPubK1, testAttributes1)
+	cred2 := newCredential(testPrivK2, testPubK2, testAttributes1)
+	cred3 := newCredential(testPrivK, testPubK, testAttributes2)
+	pks := []*gabikeys.PublicKey{testPubK1, testPubK2, testPubK}
+	equalities := []AttributeEquality{{{Proof: 0, Attribute: 2}, {Proof: 1, Attribute: 2}}}
+
+	prove := func(equalities []AttributeEquality) ProofList {
+		var builders ProofBuilderList
+		for _, cred := range []*Credential{cred1, cred2, cred3} {
+			b, err := cred.CreateDisclosureProofBuilder([]int{1}, nil, false)
+			require.NoError(t, err)
+			builders = append(builders, b)
+		}
+		require.NoError(t, builders.SetAttributeEqualities(equalities))
+		proofs, err := builders.BuildProofList(context, nonce, false)
+		require.NoError(t, err)
+		require.True(t, proofs.Verify(pks, context, nonce, false, nil))
+		return proofs
+	}
+
+	proofs := prove(equalities)
+	require.True(t, proofs.VerifyAttributeEqualities(equalities))
+	require.False(t, prove(nil).VerifyAttributeEqualities(equalities))
+
+	// Proving equality of attributes that are not equal fails
+	unequal := []AttributeEquality{{{Proof: 0, Attribute: 2}, {Proof: 2, Attribute: 2}}}
+	require.False(t, prove(unequal).VerifyAttributeEqualities(unequal))
+
+	// Disclosed attributes and the secret key are not supported
+	builder, err := cred1.CreateDisclosureProofBuilder([]int{1}, nil, false)
+	require.NoError(t, err)
+	require.Error(t, ProofBuilderList{builder}.SetAttributeEqualities([]AttributeEquality{{{Proof: 0, Attribute: 1}}}))
+	require.Error(t, ProofBuilderList{builder}.SetAttributeEqualities([]AttributeEquality{{{Proof: 0, Attribute: 0}}}))
+	require.False(t, proofs.VerifyAttributeEqualities([]AttributeEquality{{{Proof: 0, Attribute: 1}, {Proof: 1, Attribute: 1}}}))
+}
+
+func TestAttributeComparison(t *testing.T) {
+	context, err := common.RandomBigInt(testPubK.Params.Lh)
+	require.NoError(t, err)
+	nonce, err := common.RandomBigInt(testPubK.Params.Lstatzk)
+	require.NoError(t, err)
+	secret, err := common.RandomBigInt(testPubK.Params.Lm)
+	require.NoError(t, err)
+
+	newCredential := func(sk *gabikeys.PrivateKey, pk *gabikeys.PublicKey, attrs []*big.Int) *Credential {
+		attrs = append([]*big.Int{secret}, attrs...)
+		signature, err := SignMessageBlock(sk, pk, attrs)
+		require.NoError(t, err)
+		return &Credential{Pk: pk, Attributes: attrs, Signature: signature}
+	}
+	// Attributes 2 and 3 of the first credential are 100 and 30, attribute 2 of the second is 40
+	cred1 := newCredential(testPrivK1, testPubK1, []*big.Int{big.NewInt(1), big.NewInt(100), big.NewInt(30)})
+	cred2 := newCredential(testPrivK, testPubK, []*big.Int{big.NewInt(1), big.NewInt(40)})
+	pks := []*gabikeys.PublicKey{testPubK1, testPubK}
+
+	// 100 >= 3*30 + 10, and 100 - 30 - 40 >= 30
+	comparisons := []AttributeComparison{
+		{
+			Terms: []AttributeTerm{{AttributeIndex{Proof: 0, Attribute: 2}, 1}, {AttributeIndex{Proof: 0, Attribute: 3}, -3}},
+			Bound: big.NewInt(10),
+		},
+		{
+			Terms: []AttributeTerm{
+				{AttributeIndex{Proof: 0, Attribute: 2}, 1},
+				{AttributeIndex{Proof: 0, Attribute: 3}, -1},
+				{AttributeIndex{Proof: 1, Attribute: 2}, -1},
+			},
+			Bound: big.NewInt(30),
+		},
+	}
+
+	prove := func(comparisons []AttributeComparison) (ProofList, error) {
+		var builders ProofBuilderList
+		for _, cred := range []*Credential{cred1, cred2} {
+			b, err := cred.CreateDisclosureProofBuilder([]int{1}, nil, false)
+			require.NoError(t, err)
+			builders = append(builders, b)
+		}
+		if err := builders.SetAttributeComparisons(comparisons); err != nil {
+			return nil, err
+		}
+		return builders.BuildProofList(context, nonce, false)
+	}
+
+	proofs, err := prove(comparisons)
+	require.NoError(t, err)
+	require.True(t, proofs.Verify(pks, context, nonce, false, nil))
+	require.True(t, proofs.VerifyAttributeComparisons(comparisons))
+
+	// Verify after serialization
+	bts, err := json.Marshal(proofs[0])
+	require.NoError(t, err)
+	var proof ProofD
+	require.NoError(t, json.Unmarshal(bts, &proof))
+	proofs2 := ProofList{&proof, proofs[1]}
+	require.True(t, proofs2.Verify(pks, context, nonce, false, nil))
+	require.True(t, proofs2.VerifyAttributeComparisons(comparisons))
+
+	// The proofs do not prove other comparisons
+	other := []AttributeComparison{{Terms: comparisons[0].Terms, Bound: big.NewInt(9)}}
+	require.False(t, proofs.VerifyAttributeComparisons(other))
+	proof.ComparisonProofs[0].K = big.NewInt(9)
+	require.False(t, proofs2.Verify(pks, context, nonce, false, nil))
+
+	// Modifying the response of an attribute of another proof is detected
+	require.NoError(t, json.Unmarshal(bts, &proof))
+	proof.ComparisonProofs[1].ExternalResponses[2].Add(proof.ComparisonProofs[1].ExternalResponses[2], big.NewInt(1))
+	require.False(t, proofs2.Verify(pks, context, nonce, false, nil))
+	require.False(t, proofs2.VerifyAttributeComparisons(comparisons[1:]))
+
+	// Malformed comparison proofs and those with too many terms are rejected
+	require.NoError(t, json.Unmarshal(bts, &proof))
+	proof.ComparisonProofs[0].V5Response = nil
+	err = proofs2.VerifyWithError(pks, context, nonce, false, nil)
+	require.IsType(t, &VerificationError{}, err)
+	require.Equal(t, ErrInvalidRangeProof, err.(*VerificationError).Reason)
+	require.NoError(t, json.Unmarshal(bts, &proof))
+	proof.ComparisonProofs[0].Attributes = make([]AttributeIndex, rangeproof.MaxLinearTerms+1)
+	proof.ComparisonProofs[0].Factors = make([]int64, rangeproof.MaxLinearTerms+1)
+	err = proofs2.VerifyWithError(pks, context, nonce, false, nil)
+	require.IsType(t, &VerificationError{}, err)
+	require.Equal(t, ErrInvalidRangeProof, err.(*VerificationError).Reason)
+
+	// Proving a false comparison fails
+	_, err = prove([]AttributeComparison{{Terms: comparisons[1].Terms, Bound: big.NewInt(31)}})
+	require.Equal(t, rangeproof.ErrFalseStatement, err)
+
+	// Disclosed attributes and the secret key are not supported
+	_, err = prove([]AttributeComparison{{Terms: []AttributeTerm{{AttributeIndex{Proof: 1, Attribute: 1}, 1}}, Bound: big.NewInt(0)}})
+	require.Error(t, err)
+	_, err = prove([]AttributeComparison{{Terms: []AttributeTerm{{AttributeIndex{Proof: 0, Attribute: 0}, 1}}, Bound: big.NewInt(0)}})
+	require.Error(t, err)
+}
+
+func TestAttributeCarryOver(t *testing.T) {
+	context, err := common.RandomBigInt(testPubK.Params.Lh)
+	require.NoError(t, err)
+	nonce1, err := common.RandomBigInt(testPubK.Params.Lstatzk)
+	require.NoError(t, err)
+	nonce2, err := common.RandomBigInt(testPubK.Params.Lstatzk)
+	require.NoError(t, err)
+	secret, err := common.RandomBigInt(testPubK.Params.Lm)
+	require.NoError(t, err)
+
+	attrs := append([]*big.Int{secret}, testAttributes1...)
+	signature, err := SignMessageBlock(testPrivK1, testPubK1, attrs)
+	require.NoError(t, err)
+	source := &Credential{Pk: testPubK1, Attributes: attrs, Signature: signature}
+	pks := []*gabikeys.PublicKey{testPubK1, testPubK2}
+	issuer := NewIssuer(testPrivK2, testPubK2, context)
+	equalities := []AttributeEquality{{{Proof: 0, Attribute: 2}, {Proof: 1, Attribute: 2}}}
+
+	issue := func(value *big.Int, equalities []AttributeEquality) (*CredentialBuilder, []*IssueSignatureMessage, error) {
+		db, err := source.CreateDisclosureProofBuilder([]int{1}, nil, false)
+		require.NoError(t, err)
+		cb, err := NewCredentialBuilder(testPubK2, context, secret, nonce2, nil)
+		require.NoError(t, err)
+		require.NoError(t, cb.CarryOver(2, value))
+		builders := ProofBuilderList{db, cb}
+		require.NoError(t, builders.SetAttributeEqualities(equalities))
+		proofs, err := builders.BuildProofList(context, nonce1, false)
+		require.NoError(t, err)
+		msg := cb.CreateIssueCommitmentMessage(proofs)
+		requests := []*IssueRequest{{
+			Attributes: []*big.Int{testAttributes2[0], nil, testAttributes2[2]},
+			Carried:    map[int]AttributeIndex{1: {Proof: 0, Attribute: 2}},
+		}}
+		sigs, err := BatchIssuer{issuer}.IssueSignatures(msg, pks, context, nonce1, nil, nil, nil, requests)
+		return cb, sigs, err
+	}
+
+	cb, sigs, err := issue(testAttributes1[1], equalities)
+	require.NoError(t, err)
+	require.Len(t, sigs, 1)
+	cred, err := cb.ConstructCredential(sigs[0], []*big.Int{testAttributes2[0], nil, testAttributes2[2]})
+	require.NoError(t, err)
+	require.Equal(t, testAttributes1[1], cred.Attributes[2])
+
+	// The new credential can be used like any other
+	db, err := cred.CreateDisclosureProofBuilder([]int{2}, nil, false)
+	require.NoError(t, err)
+	proofs, err := ProofBuilderList{db}.BuildProofList(context, nonce1, false)
+	require.NoError(t, err)
+	require.True(t, proofs.Verify([]*gabikeys.PublicKey{testPubK2}, context, nonce1, false, nil))
+	require.Equal(t, testAttributes1[1], proofs[0].(*ProofD).ADisclosed[2])
+
+	// The issuer rejects carried over attributes that are not proven equal to the source
+	_, _, err = issue(testAttributes1[1], nil)
+	require.Equal(t, ErrInvalidCarriedAttribute, err)
+	_, _, err = issue(testAttributes1[0], equalities)
+	require.Equal(t, ErrInvalidCarriedAttribute, err)
+
+	// Random blind attributes cannot be carried over
+	cb, err = NewCredentialBuilder(testPubK2, context, secret, nonce2, []int{1})
+	require.NoError(t, err)
+	require.Error(t, cb.CarryOver(2, testAttributes1[1]))
+}
+
+func TestSetMembershipProof(t *testing.T) {
+	context, err := common.RandomBigInt(testPubK1.Params.Lh)
+	require.NoError(t, err)
+	nonce, err := common.RandomBigInt(testPubK1.Params.Lstatzk)
+	require.NoError(t, err)
+	secret, err := common.RandomBigInt(testPubK1.Params.Lm)
+	require.NoError(t, err)
+
+	issuer := NewIssuer(testPrivK1, testPubK1, context)
+	cred := createCredential(t, context, secret, issuer)
+
+	set := []*big.Int{big.NewInt(31), testAttributes1[1], big.NewInt(12345)}
+	statement, err := rangeproof.NewSetMembershipStatement(set)
+	require.NoError(t, err)
+
+	builder, err := cred.CreateDisclosureProofBuilder([]int{1}, nil, false)
+	require.NoError(t, err)
+	require.NoError(t, builder.AddSetMembershipStatement(2, statement))
+	require.Error(t, builder.AddSetMembershipStatement(1, statement))
+	proofs, err := ProofBuilderList{builder}.BuildProofList(context, nonce, false)
+	require.NoError(t, err)
+	proof := proofs[0].(*ProofD)
+	require.True(t, proof.Verify(testPubK1, context, nonce, false))
+	require.True(t, proof.SetMembershipProofs[2][0].Proves(statement))
+
+	// Verify after serialization
+	bts, err := json.Marshal(proof)
+	require.NoError(t, err)
+	var proof2 ProofD
+	require.NoError(t, json.Unmarshal(bts, &proof2))
+	require.True(t, proof2.Verify(testPubK1, context, nonce, false))
+
+	// Modifying the set invalidates the proof
+	proof2.cachedSetMembershipStructures = nil
+	proof2.SetMembershipProofs[2][0].Set[1] = big.NewInt(1)
+	require.False(t, proof2.Verify(testPubK1, context, nonce, false))
+
+	// Moving the proof to another attribute invalidates it
+	var proof3 ProofD
+	require.NoError(t, json.Unmarshal(bts, &proof3))
+	proof3.SetMembershipProofs[3] = proof3.SetMembershipProofs[2]
+	delete(proof3.SetMembershipProofs, 2)
+	require.False(t, proof3.Verify(testPubK1, context, nonce, false))
+
+	// Proving a false statement fails
+	builder, err = cred.CreateDisclosureProofBuilder([]int{1}, nil, false)
+	require.NoError(t, err)
+	require.NoError(t, builder.AddSetMembershipStatement(3, statement))
+	_, err = ProofBuilderList{builder}.BuildProofList(context, nonce, false)
+	require.Equal(t, rangeproof.ErrFalseStatement, err)
+}
+
+func TestNotEqualProof(t *testing.T) {
+	context, err := common.RandomBigInt(testPubK1.Params.Lh)
+	require.NoError(t, err)
+	nonce, err := common.RandomBigInt(testPubK1.Params.Lstatzk)
+	require.NoError(t, err)
+	secret, err := common.RandomBigInt(testPubK1.Params.Lm)
+	require.NoError(t, err)
+
+	issuer := NewIssuer(testPrivK1, testPubK1, context)
+	cred := createCredential(t, context, secret, issuer)
+
+	statement, err := rangeproof.NewNotEqualStatement(testAttributes1[2])
+	require.NoError(t, err)
+
+	builder, err := cred.CreateDisclosureProofBuilder([]int{1}, nil, false)
+	require.NoError(t, err)
+	require.NoError(t, builder.AddNotEqualStatement(2, statement))
+	require.Error(t, builder.AddNotEqualStatement(1, statement))
+	proofs, err := ProofBuilderList{builder}.BuildProofList(context, nonce, false)
+	require.NoError(t, err)
+	proof := proofs[0].(*ProofD)
+	require.True(t, proof.Verify(testPubK1, context, nonce, false))
+	require.True(t, proof.NotEqualProofs[2][0].Proves(statement))
+
+	// Verify after serialization
+	bts, err := json.Marshal(proof)
+	require.NoError(t, err)
+	var proof2 ProofD
+	require.NoError(t, json.Unmarshal(bts, &proof2))
+	require.True(t, proof2.Verify(testPubK1, context, nonce, false))
+
+	// Modifying the value invalidates the proof
+	var proof3 ProofD
+	require.NoError(t, json.Unmarshal(bts, &proof3))
+	proof3.NotEqualProofs[2][0].Value = testAttributes1[1]
+	require.False(t, proof3.Verify(testPubK1, context, nonce, false))
+
+	// Proving a false statement fails
+	builder, err = cred.CreateDisclosureProofBuilder([]int{1}, nil, false)
+	require.NoError(t, err)
+	require.NoError(t, builder.AddNotEqualStatement(3, statement))
+	_, err = ProofBuilderList{builder}.BuildProofList(context, nonce, false)
+	require.Equal(t, rangeproof.ErrFalseStatement, err)
+}
+
+func TestIntervalProof(t *testing.T) {
+	context, err := common.RandomBigInt(testPubK1.Params.Lh)
+	require.NoError(t, err)
+	nonce, err := common.RandomBigInt(testPubK1.Params.Lstatzk)
+	require.NoError(t, err)
+	secret, err := common.RandomBigInt(testPubK1.Params.Lm)
+	require.NoError(t, err)
+
+	issuer := NewIssuer(testPrivK1, testPubK1, context)
+	cred := createCredential(t, context, secret, issuer)
+
+	// The attribute at index 2 is testAttributes1[1]
+	lower := new(big.Int).Sub(testAttributes1[1], big.NewInt(10))
+	upper := new(big.Int).Add(testAttributes1[1], big.NewInt(10))
+	statement, err := rangeproof.NewIntervalStatement(lower, upper)
+	require.NoError(t, err)
+
+	builder, err := cred.CreateDisclosureProofBuilder([]int{1}, nil, false)
+	require.NoError(t, err)
+	require.NoError(t, builder.AddIntervalStatement(2, statement))
+	require.Error(t, builder.AddIntervalStatement(1, statement))
+	proofs, err := ProofBuilderList{builder}.BuildProofList(context, nonce, false)
+	require.NoError(t, err)
+	proof := proofs[0].(*ProofD)
+	require.True(t, proof.Verify(testPubK1, context, nonce, false))
+	require.True(t, proof.IntervalProofs[2][0].Proves(statement))
+
+	// Verify after serialization
+	bts, err := json.Marshal(proof)
+	require.NoError(t, err)
+	var proof2 ProofD
+	require.NoError(t, json.Unmarshal(bts, &proof2))
+	require.True(t, proof2.Verify(testPubK1, context, nonce, false))
+
+	// Modifying the bounds invalidates the proof
+	var proof3 ProofD
+	require.NoError(t, json.Unmarshal(bts, &proof3))
+	proof3.IntervalProofs[2][0].Lower = new(big.Int).Add(lower, big.NewInt(1))
+	require.False(t, proof3.Verify(testPubK1, context, nonce, false))
+
+	// Proving a false statement fails
+	builder, err = cred.CreateDisclosureProofBuilder([]int{1}, nil, false)
+	require.NoError(t, err)
+	require.NoError(t, builder.AddIntervalStatement(3, statement))
+	_, err = ProofBuilderList{builder}.BuildProofList(context, nonce, false)
+	require.Equal(t, rangeproof.ErrFalseStatement, err)
+}
+
+func TestVerifiableEncryption(t *testing.T) {
+	context, err := common.RandomBigInt(testPubK1.Params.Lh)
+	require.NoError(t, err)
+	nonce, err := common.RandomBigInt(testPubK1.Params.Lstatzk)
+	require.NoError(t, err)
+	secret, err := common.RandomBigInt(testPubK1.Params.Lm)
+	require.NoError(t, err)
+
+	issuer := NewIssuer(testPrivK1, testPubK1, context)
+	cred := createCredential(t, context, secret, issuer)
+	inspector, err := verenc.NewPrivateKey(testPrivK.P, testPrivK.Q)
+	require.NoError(t, err)
+	label := []byte("court order")
+
+	builder, err := cred.CreateDisclosureProofBuilder([]int{1}, nil, false)
+	require.NoError(t, err)
+	require.NoError(t, builder.AddVerifiableEncryption(2, inspector.PublicKey, label))
+	require.Error(t, builder.AddVerifiableEncryption(1, inspector.PublicKey, label))
+	proofs, err := ProofBuilderList{builder}.BuildProofList(context, nonce, false)
+	require.NoError(t, err)
+	proof := proofs[0].(*ProofD)
+	require.True(t, proof.Verify(testPubK1, context, nonce, false))
+	require.True(t, proof.VerifiableEncryptions[2][0].Proves(inspector.PublicKey, label))
+	require.False(t, proof.VerifiableEncryptions[2][0].Proves(inspector.PublicKey, []byte("other")))
+
+	// The inspector can decrypt the attribute
+	m, err := inspector.Decrypt(&proof.VerifiableEncryptions[2][0].Ciphertext)
+	require.NoError(t, err)
+	require.Equal(t, testAttributes1[1], m)
+
+	// Verify after serialization
+	bts, err := json.Marshal(proof)
+	require.NoError(t, err)
+	var proof2 ProofD
+	require.NoError(t, json.Unmarshal(bts, &proof2))
+	require.True(t, proof2.Verify(testPubK1, context, nonce, false))
+
+	// Replacing the ciphertext invalidates the proof
+	var proof3 ProofD
+	require.NoError(t, json.Unmarshal(bts, &proof3))
+	ct, err := verenc.Encrypt(inspector.PublicKey, testAttributes1[2], label)
+	require.NoError(t, err)
+	proof3.VerifiableEncryptions[2][0].Ciphertext = *ct
+	require.False(t, proof3.Verify(testPubK1, context, nonce, false))
+
+	// Removing the response invalidates the proof
+	var proof4 ProofD
+	require.NoError(t, json.Unmarshal(bts, &proof4))
+	proof4.VerifiableEncryptions[2][0].RResponse = nil
+	err = proof4.VerifyWithError(testPubK1, context, nonce, false)
+	require.IsType(t, &VerificationError{}, err)
+	require.Equal(t, ErrInvalidEncryptionProof, err.(*VerificationError).Reason)
+
+	// Oversized inspector moduli are rejected before doing any work with them
+	var proof5 ProofD
+	require.NoError(t, json.Unmarshal(bts, &proof5))
+	pk := *proof5.VerifiableEncryptions[2][0].PublicKey
+	pk.N = new(big.Int).Add(new(big.Int).Lsh(big.NewInt(1), verenc.MaxModulusBits), big.NewInt(1))
+	proof5.VerifiableEncryptions[2][0].PublicKey = &pk
+	err = proof5.VerifyWithError(testPubK1, context, nonce, false)
+	require.IsType(t, &VerificationError{}, err)
+	require.Equal(t, ErrInvalidEncryptionProof, err.(*VerificationError).Reason)
+	_, err = proof5.VerifiableEncryptions[2][0].ExtractStructure(2, testPubK1)
+	require.Equal(t, verenc.ErrInvalidPublicKey, err)
+}
+
+func TestVerificationErrors(t *testing.T) {
+	context, err := common.RandomBigInt(testPubK.Params.Lh)
+	require.NoError(t, err)
+	nonce, err := common.RandomBigInt(testPubK.Params.Lstatzk)
+	require.NoError(t, err)
+	secret, err := common.RandomBigInt(testPubK.Params.Lm)
+	require.NoError(t, err)
+	otherSecret, err := common.RandomBigInt(testPubK.Params.Lm)
+	require.NoError(t, err)
+
+	cred1 := createCredential(t, context, secret, NewIssuer(testPrivK1, testPubK1, context))
+	cred2 := createCredential(t, context, secret, NewIssuer(testPrivK2, testPubK2, context))
+	cred3 := createCredential(t, context, otherSecret, NewIssuer(testPrivK2, testPubK2, context))
+	pks := []*gabikeys.PublicKey{testPubK1, testPubK2}
+
+	statement, err := rangeproof.NewStatement(rangeproof.GreaterOrEqual, big.NewInt(1))
+	require.NoError(t, err)
+	prove := func(creds ...*Credential) ProofList {
+		var builders ProofBuilderList
+		for _, cred := range creds {
+			b, err := cred.CreateDisclosureProofBuilder([]int{1}, map[int][]*rangeproof.Statement{2: {statement}}, false)
+			require.NoError(t, err)
+			builders = append(builders, b)
+		}
+		proofs, err := builders.BuildProofList(context, nonce, false)
+		require.NoError(t, err)
+		return proofs
+	}
+	requireReason := func(err error, index int, reason error) {
+		require.Error(t, err)
+		verr, ok := err.(*VerificationError)
+		require.True(t, ok)
+		require.Equal(t, index, verr.Index)
+		require.Equal(t, reason, verr.Reason)
+		require.Equal(t, reason, verr.Unwrap())
+	}
+
+	require.NoError(t, prove(cred1, cred2).VerifyWithError(pks, context, nonce, false, nil))
+	requireReason(prove(cred1, cred2).VerifyWithError(pks[:1], context, nonce, false, nil), -1, ErrProofListLength)
+	requireReason(prove(cred1, cred3).VerifyWithError(pks, context, nonce, false, nil), 1, ErrSecretKeyMismatch)
+	require.NoError(t, prove(cred1, cred3).VerifyWithError(pks, context, nonce, false, []string{"a", "b"}))
+
+	proofs := prove(cred1, cred2)
+	proofs[1].(*ProofD).AResponses[3].Add(proofs[1].(*ProofD).AResponses[3], big.NewInt(1))
+	// The challenge is shared, so this is reported for the first proof
+	requireReason(proofs.VerifyWithError(pks, context, nonce, false, nil), 0, ErrChallengeMismatch)
+	require.False(t, proofs.Verify(pks, context, nonce, false, nil))
+
+	proofs = prove(cred1, cred2)
+	proofs[0].(*ProofD).AResponses[3].Lsh(proofs[0].(*ProofD).AResponses[3], 1024)
+	requireReason(proofs.VerifyWithError(pks, context, nonce, false, nil), 0, ErrResponseSize)
+
+	proofs = prove(cred1, cred2)
+	proofs[1].(*ProofD).RangeProofs[2][0].Cs = proofs[1].(*ProofD).RangeProofs[2][0].Cs[:2]
+	requireReason(proofs.VerifyWithError(pks, context, nonce, false, nil), 1, ErrInvalidRangeProof)
+	requireReason(proofs[1].(*ProofD).VerifyWithError(testPubK2, context, nonce, false), 0, ErrInvalidRangeProof)
+}
+
+// concurrentVerificationProofs creates a ProofList of n credentials, each having a
+// nonrevocation proof and a range proof.
+func concurrentVerificationProofs(t testing.TB, n int) (ProofList, []*gabikeys.PublicKey, *big.Int, *big.Int) {
+	_, update, acc := setupRevocation(t)
+	context, err := common.RandomBigInt(testPubK.Params.Lh)
+	require.NoError(t, err)
+	nonce, err := common.RandomBigInt(testPubK.Params.Lstatzk)
+	require.NoError(t, err)
+	secret, err := common.RandomBigInt(testPubK.Params.Lm)
+	require.NoError(t, err)
+
+	statement, err := rangeproof.NewStatement(rangeproof.GreaterOrEqual, big.NewInt(1))
+	require.NoError(t, err)
+	var builders ProofBuilderList
+	var pks []*gabikeys.PublicKey
+	for i := 0; i < n; i++ {
+		witness, err := revocation.RandomWitness(testPrivK, acc)
+		require.NoError(t, err)
+		witness.SignedAccumulator = update.SignedAccumulator
+		attrs := append([]*big.Int{secret}, revocationAttrs(witness)...)
+		signature, err := SignMessageBlock(testPrivK, testPubK, attrs)
+		require.NoError(t, err)
+		cred := &Credential{Signature: signature, Pk: testPubK, Attributes: attrs, NonRevocationWitness: witness}
+		b, err := cred.CreateDisclosureProofBuilder([]int{1}, map[int][]*rangeproof.Statement{2: {statement}}, true)
+		require.NoError(t, err)
+		builders = append(builders, b)
+		pks = append(pks, testPubK)
+	}
+	proofs, err := builders.BuildProofList(context, nonce, false)
+	require.NoError(t, err)
+	return proofs, pks, context, nonce
+}
+
+// copyProofList returns a deep copy of the proof list, without cached values.
+func copyProofList(t testing.TB, proofs ProofList) ProofList {
+	bts, err := json.Marshal(proofs)
+	require.NoError(t, err)
+	var copied ProofList
+	require.NoError(t, json.Unmarshal(bts, &copied))
+	return copied
+}
+
+func TestVerifyConcurrently(t *testing.T) {
+	proofs, pks, context, nonce := concurrentVerificationProofs(t, 4)
+	for _, workers := range []int{0, 1, 2, 8} {
+		require.NoError(t, copyProofList(t, proofs).VerifyConcurrently(pks, context, nonce, false, nil, workers))
+
+		invalid := copyProofList(t, proofs)
+		invalid[2].(*ProofD).RangeProofs[2][0].Cs = nil
+		invalid[3].(*ProofD).RangeProofs[2][0].Cs = nil
+		err := invalid.VerifyConcurrently(pks, context, nonce, false, nil, workers)
+		require.Error(t, err)
+		require.Equal(t, 2, err.(*VerificationError).Index)
+		require.Equal(t, ErrInvalidRangeProof, err.(*VerificationError).Reason)
+	}
+}
+
+func benchmarkVerifyConcurrently(b *testing.B, workers int) {
+	proofs, pks, context, nonce := concurrentVerificationProofs(b, 10)
+	lists := make([]ProofList, b.N)
+	for i := range lists {
+		lists[i] = copyProofList(b, proofs)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := lists[i].VerifyConcurrently(pks, context, nonce, false, nil, workers); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkVerifySerial(b *testing.B) {
+	benchmarkVerifyConcurrently(b, 1)
+}
+
+func BenchmarkVerifyConcurrently(b *testing.B) {
+	benchmarkVerifyConcurrently(b, runtime.NumCPU())
+}
+
 // TODO: tests to add:
 // - Reading/writing key files
 // - Tests with expiration dates?
@@ -34,6 +34,10 @@ type (
 		EpochLength EpochLength `xml:"Features"`
 		ECDSAString string      `xml:"ECDSA,omitempty"`
 
+		// RevocationAuthority is set if revocation is managed by a revocation authority
+		// separate from the issuer.
+		RevocationAuthority *RevocationPublicKey `xml:"RevocationPublicKey,omitempty"`
+
 		ECDSA  *ecdsa.PublicKey  `xml:"-"`
 		Params *SystemParameters `xml:"-"`
 		Issuer string            `xml:"-"`
@@ -309,7 +313,12 @@ func NewPublicKeyFromFile(filename string) (*PublicKey, error) {
 }
 
 func (pubk *PublicKey) parseRevocationKey() error {
-	if pubk.ECDSA != nil || !pubk.RevocationSupported() {
+	if pubk.RevocationAuthority != nil {
+		if err := pubk.RevocationAuthority.parse(); err != nil {
+			return err
+		}
+	}
+	if pubk.ECDSA != nil || len(pubk.ECDSAString) == 0 {
 		return nil
 	}
 	bts, err := base64.StdEncoding.DecodeString(pubk.ECDSAString)
@@ -325,7 +334,7 @@ func (pubk *PublicKey) parseRevocationKey() error {
 }
 
 func (pubk *PublicKey) RevocationSupported() bool {
-	return pubk.G != nil && pubk.H != nil && len(pubk.ECDSAString) > 0
+	return pubk.RevocationAuthority != nil || pubk.G != nil && pubk.H != nil && len(pubk.ECDSAString) > 0
 }
 
 // Print prints the key to stdout.
@@ -0,0 +1,210 @@
+package gabikeys
+
+import (
+	"crypto/ecdsa"
+	"encoding/base64"
+	"encoding/xml"
+	"io"
+
+	"github.com/privacybydesign/gabi/big"
+	"github.com/privacybydesign/gabi/internal/common"
+	"github.com/privacybydesign/gabi/safeprime"
+	"github.com/privacybydesign/gabi/signed"
+
+	"github.com/go-errors/errors"
+)
+
+type (
+	// RevocationPublicKey is the public key of a revocation authority that is separate from the
+	// issuer. It has its own modulus, in which nonrevocation is proven, and its own ECDSA key,
+	// with which accumulator updates are signed. Issuer public keys whose revocation is managed by
+	// the revocation authority contain it in their RevocationAuthority field.
+	RevocationPublicKey struct {
+		XMLName     xml.Name `xml:"http://www.zurich.ibm.com/security/idemix RevocationPublicKey"`
+		Counter     uint     `xml:"Counter"`
+		N           *big.Int `xml:"Elements>n"` // Modulus n
+		G           *big.Int `xml:"Elements>G"` // Generator G
+		H           *big.Int `xml:"Elements>H"` // Generator H
+		ECDSAString string   `xml:"ECDSA"`
+
+		ECDSA *ecdsa.PublicKey `xml:"-"`
+	}
+
+	// RevocationPrivateKey is the private key of a revocation authority.
+	RevocationPrivateKey struct {
+		XMLName     xml.Name `xml:"http://www.zurich.ibm.com/security/idemix RevocationPrivateKey"`
+		Counter     uint     `xml:"Counter"`
+		P           *big.Int `xml:"Elements>p"`
+		Q           *big.Int `xml:"Elements>q"`
+		PPrime      *big.Int `xml:"Elements>pPrime"`
+		QPrime      *big.Int `xml:"Elements>qPrime"`
+		ECDSAString string   `xml:"ECDSA"`
+
+		N     *big.Int          `xml:"-"`
+		ECDSA *ecdsa.PrivateKey `xml:"-"`
+		Order *big.Int          `xml:"-"`
+	}
+)
+
+// GenerateRevocationKeys generates a new revocation authority keypair with a modulus of the size
+// specified by the system parameters.
+func GenerateRevocationKeys(param *SystemParameters, counter uint) (*RevocationPrivateKey, *RevocationPublicKey, error) {
+	p, q, err := generateSafePrimePair(param)
+	if err != nil {
+		return nil, nil, err
+	}
+	return NewRevocationKeys(p, q, counter)
+}
+
+// NewRevocationKeys creates a new revocation authority keypair using the given safe primes,
+// generating new generators and a new ECDSA key.
+func NewRevocationKeys(p, q *big.Int, counter uint) (*RevocationPrivateKey, *RevocationPublicKey, error) {
+	if p.Cmp(q) == 0 || !safeprime.ProbablySafePrime(p, 40) || !safeprime.ProbablySafePrime(q, 40) {
+		return nil, nil, errors.New("p and q must be distinct safe primes")
+	}
+	key, err := signed.GenerateKey()
+	if err != nil {
+		return nil, nil, err
+	}
+	dsabts, err := signed.MarshalPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	pubdsabts, err := signed.MarshalPublicKey(&key.PublicKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sk := &RevocationPrivateKey{
+		Counter:     counter,
+		P:           p,
+		Q:           q,
+		PPrime:      new(big.Int).Rsh(p, 1),
+		QPrime:      new(big.Int).Rsh(q, 1),
+		ECDSAString: base64.StdEncoding.EncodeToString(dsabts),
+		N:           new(big.Int).Mul(p, q),
+		ECDSA:       key,
+	}
+	sk.Order = new(big.Int).Mul(sk.PPrime, sk.QPrime)
+	pk := &RevocationPublicKey{
+		Counter:     counter,
+		N:           sk.N,
+		G:           common.RandomQR(sk.N),
+		H:           common.RandomQR(sk.N),
+		ECDSAString: base64.StdEncoding.EncodeToString(pubdsabts),
+		ECDSA:       &key.PublicKey,
+	}
+	return sk, pk, nil
+}
+
+// NewRevocationPrivateKeyFromXML creates a new revocation authority private key using the xml
+// data provided.
+func NewRevocationPrivateKeyFromXML(xmlInput string) (*RevocationPrivateKey, error) {
+	sk := &RevocationPrivateKey{}
+	if err := xml.Unmarshal([]byte(xmlInput), sk); err != nil {
+		return nil, err
+	}
+	if sk.P == nil || sk.Q == nil || sk.PPrime == nil || sk.QPrime == nil {
+		return nil, errors.New("incomplete revocation private key")
+	}
+	sk.N = new(big.Int).Mul(sk.P, sk.Q)
+	sk.Order = new(big.Int).Mul(sk.PPrime, sk.QPrime)
+	bts, err := base64.StdEncoding.DecodeString(sk.ECDSAString)
+	if err != nil {
+		return nil, err
+	}
+	if sk.ECDSA, err = signed.UnmarshalPrivateKey(bts); err != nil {
+		return nil, err
+	}
+	return sk, nil
+}
+
+// NewRevocationPublicKeyFromXML creates a new revocation authority public key using the xml
+// data provided.
+func NewRevocationPublicKeyFromXML(xmlInput string) (*RevocationPublicKey, error) {
+	pk := &RevocationPublicKey{}
+	if err := xml.Unmarshal([]byte(xmlInput), pk); err != nil {
+		return nil, err
+	}
+	if err := pk.parse(); err != nil {
+		return nil, err
+	}
+	return pk, nil
+}
+
+func (pk *RevocationPublicKey) parse() error {
+	if pk.N == nil || pk.G == nil || pk.H == nil {
+		return errors.New("incomplete revocation public key")
+	}
+	if pk.ECDSA != nil {
+		return nil
+	}
+	bts, err := base64.StdEncoding.DecodeString(pk.ECDSAString)
+	if err != nil {
+		return err
+	}
+	pk.ECDSA, err = signed.UnmarshalPublicKey(bts)
+	return err
+}
+
+// WriteTo writes the XML-serialized revocation private key to the given writer.
+func (sk *RevocationPrivateKey) WriteTo(writer io.Writer) (int64, error) {
+	return writeXML(writer, sk)
+}
+
+// WriteTo writes the XML-serialized revocation public key to the given writer.
+func (pk *RevocationPublicKey) WriteTo(writer io.Writer) (int64, error) {
+	return writeXML(writer, pk)
+}
+
+// PrivateKey returns the revocation private key in the form of an issuer private key, containing
+// only the values used by the revocation package, so that it can be passed to it.
+func (sk *RevocationPrivateKey) PrivateKey() *PrivateKey {
+	return &PrivateKey{
+		Counter:     sk.Counter,
+		P:           sk.P,
+		Q:           sk.Q,
+		PPrime:      sk.PPrime,
+		QPrime:      sk.QPrime,
+		ECDSAString: sk.ECDSAString,
+		N:           sk.N,
+		ECDSA:       sk.ECDSA,
+		Order:       sk.Order,
+	}
+}
+
+// PublicKey returns the revocation public key in the form of an issuer public key, containing
+// only the values used by the revocation package, so that it can be passed to it.
+func (pk *RevocationPublicKey) PublicKey() *PublicKey {
+	return &PublicKey{
+		Counter:     pk.Counter,
+		N:           pk.N,
+		G:           pk.G,
+		H:           pk.H,
+		ECDSAString: pk.ECDSAString,
+		ECDSA:       pk.ECDSA,
+	}
+}
+
+// RevocationKey returns the key against which nonrevocation is proven and accumulator updates
+// are verified: that of the revocation authority if the public key references one, and the
+// public key itself otherwise.
+func (pubk *PublicKey) RevocationKey() *PublicKey {
+	if pubk.RevocationAuthority == nil {
+		return pubk
+	}
+	return pubk.RevocationAuthority.PublicKey()
+}
+
+func writeXML(writer io.Writer, v interface{}) (int64, error) {
+	numHeaderBytes, err := writer.Write([]byte(XMLHeader))
+	if err != nil {
+		return 0, err
+	}
+	b, err := xml.MarshalIndent(v, "", "   ")
+	if err != nil {
+		return int64(numHeaderBytes), err
+	}
+	numBodyBytes, err := writer.Write(b)
+	return int64(numHeaderBytes + numBodyBytes), err
+}
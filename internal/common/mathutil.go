@@ -293,6 +293,124 @@ func sumFourSquaresSpecial(n *big.Int) (*big.Int, *big.Int, *big.Int, *big.Int)
 	}
 }
 
+// ErrNotSumOfThreeSquares is returned by SumThreeSquares for numbers of the form 4^a(8b+7).
+var ErrNotSumOfThreeSquares = errors.New("number is not a sum of three squares")
+
+// Express a number as sum of three squares, which is possible if and only if it is not of the form
+// 4^a(8b+7). This uses the randomized algorithm from "Randomized algorithms in number theory" by
+// M. Rabin and J. Shallit: subtract a random square x^2 (or, if n == 3 (mod 8), take
+// (n - x^2)/2), until a prime p == 1 (mod 4) remains, which is then written as a sum of two
+// squares.
+func SumThreeSquares(n *big.Int) (*big.Int, *big.Int, *big.Int, error) {
+	if n.Sign() < 0 {
+		return nil, nil, nil, ErrNotSumOfThreeSquares
+	}
+	if n.BitLen() == 0 {
+		return big.NewInt(0), big.NewInt(0), big.NewInt(0), nil
+	}
+
+	// extract out 4^k, then calculate subanswer and modify it by multiplying all values by 2^k
+	k := uint(0)
+	for n.Bit(int(2*k)) == 0 && n.Bit(int(2*k+1)) == 0 {
+		k++
+	}
+	m := new(big.Int).Rsh(n, 2*k)
+	mod8 := new(big.Int).And(m, big.NewInt(7)).Int64()
+	if mod8 == 7 {
+		return nil, nil, nil, ErrNotSumOfThreeSquares
+	}
+
+	var x, y, z *big.Int
+	if m.BitLen() <= 16 {
+		x, y, z = sumThreeSquaresSmall(m)
+	} else {
+		x, y, z = sumThreeSquaresSpecial(m, mod8)
+	}
+	return x.Lsh(x, k), y.Lsh(y, k), z.Lsh(z, k), nil
+}
+
+// sumThreeSquaresSmall expresses a small number n, not of the form 4^a(8b+7), as sum of three
+// squares by exhaustive search.
+func sumThreeSquaresSmall(n *big.Int) (*big.Int, *big.Int, *big.Int) {
+	v := n.Int64()
+	for x := int64(0); x*x <= v; x++ {
+		for y := int64(0); x*x+y*y <= v; y++ {
+			r := v - x*x - y*y
+			z := new(big.Int).Sqrt(big.NewInt(r))
+			if z.Int64()*z.Int64() == r {
+				return big.NewInt(x), big.NewInt(y), z
+			}
+		}
+	}
+	panic("Not found")
+}
+
+// sumThreeSquaresSpecial expresses a number n that is not divisible by 4 and not == 7 (mod 8)
+// as sum of three squares.
+func sumThreeSquaresSpecial(n *big.Int, mod8 int64) (*big.Int, *big.Int, *big.Int) {
+	rootN := new(big.Int).Sqrt(n)
+	x := new(big.Int)
+	p := new(big.Int)
+
+	// x must have a parity such that p below is == 1 (mod 4): odd if n == 3 (mod 8) or
+	// n == 2 (mod 4), and even if n == 1 (mod 4)
+	xOdd := uint(0)
+	if mod8 == 3 || mod8%4 == 2 {
+		xOdd = 1
+	}
+
+	// As in sumFourSquaresSpecial, the randomness need not be cryptographically secure
+	randomSource := mathRand.New(mathRand.NewSource(1))
+	for {
+		x.Rand(randomSource, rootN)
+		x.SetBit(x, 0, xOdd)
+
+		p.Mul(x, x)
+		p.Sub(n, p)
+		if p.Sign() <= 0 {
+			continue
+		}
+		if mod8 == 3 {
+			p.Rsh(p, 1)
+		}
+		if !p.ProbablyPrime(10) {
+			continue // p unsuitable
+		}
+
+		a, b, ok := sumTwoSquaresPrime(p)
+		if !ok {
+			continue
+		}
+		if mod8 == 3 {
+			// n - x^2 = 2p = (a+b)^2 + (a-b)^2
+			a, b = new(big.Int).Add(a, b), new(big.Int).Sub(a, b)
+			b.Abs(b)
+		}
+		return new(big.Int).Set(x), a, b
+	}
+}
+
+// sumTwoSquaresPrime expresses a prime p == 1 (mod 4) as sum of two squares, by running the
+// Euclidean algorithm on p and a square root of -1 modulo p until the remainder is below sqrt(p).
+func sumTwoSquaresPrime(p *big.Int) (*big.Int, *big.Int, bool) {
+	w := new(big.Int).Sub(p, bigONE)
+	if w.ModSqrt(w, p) == nil {
+		return nil, nil, false
+	}
+	rootP := new(big.Int).Sqrt(p)
+	r0, r1 := new(big.Int).Set(p), w
+	for r1.Cmp(rootP) > 0 {
+		r0, r1 = r1, r0.Mod(r0, r1)
+	}
+	rest := new(big.Int).Mul(r1, r1)
+	rest.Sub(p, rest)
+	b := new(big.Int).Sqrt(rest)
+	if new(big.Int).Mul(b, b).Cmp(rest) != 0 {
+		return nil, nil, false
+	}
+	return r1, b, true
+}
+
 // Calculate sqrt modulo a prime
 func PrimeSqrt(a *big.Int, pa *big.Int) (*big.Int, bool) {
 	// Handle the case a == 0
@@ -24,6 +24,36 @@ func benchmarkFourSquares(b *testing.B, limit *big.Int) {
 	}
 }
 
+func TestSumThreeSquares(t *testing.T) {
+	randomSource := rand.New(rand.NewSource(1))
+	values := []*big.Int{big.NewInt(0), big.NewInt(1), big.NewInt(3), big.NewInt(6), big.NewInt(65538)}
+	for _, bits := range []uint{16, 17, 64, 256, 1024} {
+		for i := 0; i < 10; i++ {
+			values = append(values, new(big.Int).Rand(randomSource, new(big.Int).Lsh(big.NewInt(1), bits)))
+		}
+	}
+	for _, val := range values {
+		x, y, z, err := SumThreeSquares(val)
+		if mod := new(big.Int).Set(val); mod.BitLen() > 0 {
+			for mod.Bit(0) == 0 && mod.Bit(1) == 0 {
+				mod.Rsh(mod, 2)
+			}
+			if mod.Bit(0) == 1 && mod.Bit(1) == 1 && mod.Bit(2) == 1 {
+				assert.Equal(t, ErrNotSumOfThreeSquares, err)
+				continue
+			}
+		}
+		assert.NoError(t, err)
+		s := new(big.Int).Mul(x, x)
+		s.Add(s, new(big.Int).Mul(y, y))
+		s.Add(s, new(big.Int).Mul(z, z))
+		assert.True(t, s.Cmp(val) == 0)
+	}
+
+	_, _, _, err := SumThreeSquares(big.NewInt(28))
+	assert.Equal(t, ErrNotSumOfThreeSquares, err)
+}
+
 func BenchmarkFourSquares256(b *testing.B) {
 	benchmarkFourSquares(b, new(big.Int).Lsh(big.NewInt(1), 256))
 }
@@ -43,6 +43,162 @@ func (i *Issuer) IssueSignature(U *big.Int, attributes []*big.Int, witness *revo
 	return &IssueSignatureMessage{Signature: signature, Proof: proof, NonRevocationWitness: witness, MIssuer: mIssuer}, nil
 }
 
+// IssueRequest contains what the issuer wants to sign for a single credential
+// in an issuance session: its attributes, optionally a nonrevocation witness,
+// the indices of random blind attributes (see IssueSignature), and the
+// attributes that the user carries over from other credentials (see
+// CredentialBuilder.CarryOver). Like Blind, the keys of Carried are indices
+// in Attributes, at which Attributes must be nil; the values specify the
+// (undisclosed) attribute of a ProofD in the IssueCommitmentMessage that the
+// carried over attribute must equal.
+type IssueRequest struct {
+	Attributes []*big.Int
+	Witness    *revocation.Witness
+	Blind      []int
+	Carried    map[int]AttributeIndex
+}
+
+var (
+	// ErrInvalidCommitmentProofs is returned when the proofs in an
+	// IssueCommitmentMessage do not verify.
+	ErrInvalidCommitmentProofs = errors.New("Proofs in IssueCommitmentMessage do not verify")
+	// ErrInvalidProofP is returned when a keyshare server's ProofP does not
+	// belong to the proof it is to be merged into.
+	ErrInvalidProofP = errors.New("ProofP of keyshare server does not match proof")
+	// ErrIssueRequestCount is returned when the number of IssueRequests does not
+	// match the number of ProofU's in the IssueCommitmentMessage.
+	ErrIssueRequestCount = errors.New("Number of issue requests does not match number of ProofU's")
+	// ErrIssuerPublicKeyMismatch is returned when a ProofU to be signed was not
+	// created against the public key of (one of) the issuer(s).
+	ErrIssuerPublicKeyMismatch = errors.New("ProofU was not created against the public key of the issuer")
+	// ErrUnexpectedUserShares is returned when a ProofU contains user shares for
+	// attributes that are not random blind attributes, or vice versa.
+	ErrUnexpectedUserShares = errors.New("ProofU user shares do not match random blind attributes")
+	// ErrInvalidCarriedAttribute is returned when a ProofU does not prove that a
+	// carried over attribute equals the attribute specified in the IssueRequest.
+	ErrInvalidCarriedAttribute = errors.New("Carried over attribute does not match source attribute")
+)
+
+// IssueSignatures verifies the proofs contained in the IssueCommitmentMessage
+// (see IssueCommitmentMessage.Verify) and, if they are valid, produces an
+// IssueSignatureMessage for each of the ProofU's in it, in order, using the
+// corresponding IssueRequest. All ProofU's must have been created against the
+// public key of the issuer; see BatchIssuer for sessions involving multiple issuers.
+func (i *Issuer) IssueSignatures(
+	msg *IssueCommitmentMessage,
+	publicKeys []*gabikeys.PublicKey,
+	context, nonce *big.Int,
+	keyshareServers []string,
+	proofPs []*ProofP,
+	proofPCommitments []*ProofPCommitment,
+	requests []*IssueRequest,
+) ([]*IssueSignatureMessage, error) {
+	return BatchIssuer{i}.IssueSignatures(msg, publicKeys, context, nonce, keyshareServers, proofPs, proofPCommitments, requests)
+}
+
+// BatchIssuer is a list of issuers, for issuing several credentials of
+// (possibly) different issuers in a single session.
+type BatchIssuer []*Issuer
+
+// issuer returns the issuer having the specified public key, if present.
+func (bi BatchIssuer) issuer(pk *gabikeys.PublicKey) *Issuer {
+	for _, i := range bi {
+		if i.Pk.N.Cmp(pk.N) == 0 {
+			return i
+		}
+	}
+	return nil
+}
+
+// IssueSignatures verifies the proofs contained in the IssueCommitmentMessage
+// (see IssueCommitmentMessage.Verify) and, if they are valid, produces an
+// IssueSignatureMessage for each of the ProofU's in it, in order, using the
+// corresponding IssueRequest. Each ProofU is signed by the issuer whose public
+// key it was created against. No signatures are returned if any of them fails.
+func (bi BatchIssuer) IssueSignatures(
+	msg *IssueCommitmentMessage,
+	publicKeys []*gabikeys.PublicKey,
+	context, nonce *big.Int,
+	keyshareServers []string,
+	proofPs []*ProofP,
+	proofPCommitments []*ProofPCommitment,
+	requests []*IssueRequest,
+) ([]*IssueSignatureMessage, error) {
+	proofs, err := msg.verify(publicKeys, context, nonce, keyshareServers, proofPs, proofPCommitments)
+	if err != nil {
+		return nil, err
+	}
+
+	sigs := make([]*IssueSignatureMessage, 0, len(requests))
+	for n := 0; ; n++ {
+		proofU, err := proofs.GetProofU(n)
+		if err == ErrMissingProofU {
+			break
+		}
+		if n >= len(requests) {
+			return nil, ErrIssueRequestCount
+		}
+		i := bi.issuer(publicKeys[proofs.indexOf(proofU)])
+		if i == nil {
+			return nil, ErrIssuerPublicKeyMismatch
+		}
+		attrs, err := requests[n].carriedAttributes(proofs, proofs.indexOf(proofU))
+		if err != nil {
+			return nil, err
+		}
+		if !proofU.hasUserShares(requests[n].userShares()) {
+			return nil, ErrUnexpectedUserShares
+		}
+		sig, err := i.IssueSignature(proofU.U, attrs, requests[n].Witness, msg.Nonce2, requests[n].Blind)
+		if err != nil {
+			return nil, err
+		}
+		sigs = append(sigs, sig)
+	}
+	if len(sigs) != len(requests) {
+		return nil, ErrIssueRequestCount
+	}
+
+	return sigs, nil
+}
+
+// userShares returns the indices of the attributes for which the ProofU
+// must contain user shares: the random blind and carried over attributes.
+func (r *IssueRequest) userShares() []int {
+	indices := append([]int{}, r.Blind...)
+	for j := range r.Carried {
+		indices = append(indices, j)
+	}
+	return indices
+}
+
+// carriedAttributes checks that the ProofU at the specified index in the
+// ProofList proves that the carried over attributes equal their source
+// attributes, and returns the attributes to be signed, in which the carried
+// over attributes are 0. Their values are contributed by the user through U.
+func (r *IssueRequest) carriedAttributes(proofs ProofList, index int) ([]*big.Int, error) {
+	if len(r.Carried) == 0 {
+		return r.Attributes, nil
+	}
+	attrs := append([]*big.Int{}, r.Attributes...)
+	for j, source := range r.Carried {
+		if j < 0 || j >= len(attrs) || attrs[j] != nil {
+			return nil, errors.New("attribute at carried over index should be nil before issuance")
+		}
+		if source.Proof < 0 || source.Proof >= len(proofs) {
+			return nil, ErrInvalidCarriedAttribute
+		}
+		if _, ok := proofs[source.Proof].(*ProofD); !ok {
+			return nil, ErrInvalidCarriedAttribute
+		}
+		if !proofs.VerifyAttributeEqualities([]AttributeEquality{{source, {Proof: index, Attribute: j + 1}}}) {
+			return nil, ErrInvalidCarriedAttribute
+		}
+		attrs[j] = big.NewInt(0)
+	}
+	return attrs, nil
+}
+
 // signCommitmentAndAttributes produces a (partial) signature on the commitment
 // and the attributes (some of which might be unknown to the issuer).
 // Arg "blind" is a list of indices representing the random blind attributes.
@@ -1,6 +1,7 @@
 package gabi
 
 import (
+	"github.com/go-errors/errors"
 	"github.com/privacybydesign/gabi/big"
 	"github.com/privacybydesign/gabi/gabikeys"
 	"github.com/privacybydesign/gabi/internal/common"
@@ -13,19 +14,47 @@ func NewKeyshareSecret() (*big.Int, error) {
 	return common.RandomBigInt(gabikeys.DefaultSystemParameters[1024].Lm - 1)
 }
 
-// Generate commitments for the keyshare server for given set of keys
-func NewKeyshareCommitments(secret *big.Int, keys []*gabikeys.PublicKey) (*big.Int, []*ProofPCommitment, error) {
-	// Generate randomizer value.
+// keyshareRandomizerLength returns the length of the randomizer of a keyshare server.
+func keyshareRandomizerLength() uint {
 	// Given that with this zero knowledge proof we are hiding a secret of length params[1024].Lm,
 	// normally we would use params[1024].LmCommit here. Generally LmCommit = Lm + Lh + Lstatzk,
 	// where Lstatzk is the level of security with which the proof hides the secret.
 	// However, params[1024].Lstatzk = 80 while everywhere else we use Lstatzk = 128.
 	// So instead of using params[1024].LmCommit we recompute it with the Lstatzk of keylength 2048.
-	randLength := gabikeys.DefaultSystemParameters[1024].Lm +
+	return gabikeys.DefaultSystemParameters[1024].Lm +
 		gabikeys.DefaultSystemParameters[1024].Lh +
 		gabikeys.DefaultSystemParameters[2048].Lstatzk
+}
 
-	randomizer, err := common.RandomBigInt(randLength)
+// refreshedKeyshareRandomizerLength returns the length of the randomizers of refreshed keyshare
+// secrets (see KeyshareRefresh), computed as in keyshareRandomizerLength but for their length.
+func refreshedKeyshareRandomizerLength() uint {
+	return keyshareShareLength() +
+		gabikeys.DefaultSystemParameters[1024].Lh +
+		gabikeys.DefaultSystemParameters[2048].Lstatzk
+}
+
+// secretKeyResponseLength returns the maximum bit length of secret key responses involving a
+// refreshed keyshare secret, i.e. of the response of the user (see ProofBuilderList.Challenge)
+// plus that of a keyshare server (see ProofP.Verify).
+func secretKeyResponseLength() uint {
+	return refreshedKeyshareRandomizerLength() + 2
+}
+
+// isRefreshedKeyshareSecret returns whether the specified share of a secret key exceeds the range
+// of unrefreshed secrets, and hence requires a larger randomizer.
+func isRefreshedKeyshareSecret(secret *big.Int) bool {
+	return secret.Sign() < 0 || uint(secret.BitLen()) > gabikeys.DefaultSystemParameters[1024].Lm
+}
+
+// Generate commitments for the keyshare server for given set of keys
+func NewKeyshareCommitments(secret *big.Int, keys []*gabikeys.PublicKey) (*big.Int, []*ProofPCommitment, error) {
+	// Generate randomizer value.
+	length := keyshareRandomizerLength()
+	if isRefreshedKeyshareSecret(secret) {
+		length = refreshedKeyshareRandomizerLength()
+	}
+	randomizer, err := common.RandomBigInt(length)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -33,9 +62,13 @@ func NewKeyshareCommitments(secret *big.Int, keys []*gabikeys.PublicKey) (*big.I
 	// And exponentiate it with all keys
 	var exponentiatedCommitments []*ProofPCommitment
 	for _, key := range keys {
+		p, err := common.ModPow(key.R[0], secret, key.N)
+		if err != nil {
+			return nil, nil, err
+		}
 		exponentiatedCommitments = append(exponentiatedCommitments,
 			&ProofPCommitment{
-				P:       new(big.Int).Exp(key.R[0], secret, key.N),
+				P:       p,
 				Pcommit: new(big.Int).Exp(key.R[0], randomizer, key.N),
 			})
 	}
@@ -43,11 +76,243 @@ func NewKeyshareCommitments(secret *big.Int, keys []*gabikeys.PublicKey) (*big.I
 	return randomizer, exponentiatedCommitments, nil
 }
 
+// Generate commitment for the keyshare server for a pseudonym in the given scope,
+// using the randomizer returned by NewKeyshareCommitments
+func NewKeysharePseudonymCommitment(secret, randomizer *big.Int, scope string) *ProofPCommitment {
+	base := PseudonymBase(scope)
+	p, err := common.ModPow(base, secret, pseudonymModulus)
+	if err != nil {
+		// Unreachable, as the base is a unit modulo the prime pseudonymModulus
+		panic(err)
+	}
+	return &ProofPCommitment{
+		P:       p,
+		Pcommit: new(big.Int).Exp(base, randomizer, pseudonymModulus),
+	}
+}
+
 // Generate keyshare response for a given challenge and commit, given a secret
 func KeyshareResponse(secret, commit, challenge *big.Int, key *gabikeys.PublicKey) *ProofP {
+	p, err := common.ModPow(key.R[0], secret, key.N)
+	if err != nil {
+		// Unreachable, as R_0 is a quadratic residue and hence a unit modulo N
+		panic(err)
+	}
 	return &ProofP{
-		P:         new(big.Int).Exp(key.R[0], secret, key.N),
+		P:         p,
 		C:         new(big.Int).Set(challenge),
 		SResponse: new(big.Int).Add(commit, new(big.Int).Mul(challenge, secret)),
 	}
 }
+
+// Keyshare secrets are refreshed by the keyshare server subtracting a random delta from its
+// secret, and the client adding it to its own, so that the combined secret key, and hence all
+// existing credentials, remain valid. The delta is generated jointly: the keyshare server first
+// commits to its contribution (see NewKeyshareRefreshSession), after which the client sends its
+// own (see NewKeyshareRefreshContribution). The contribution of the keyshare server is its
+// current secret plus a fresh random value of keyshareRefreshLength() bits, so that its new secret
+// is minus the sum of that random value and the contribution of the client: independent of its
+// previous secret, and unknown to the client and to anyone who learned the previous shares unless
+// they also learned the randomness of both parties. The new secret of the client is then the
+// secret key plus that sum, which statistically hides the secret key.
+//
+// The keyshare server opens its commitment and sends its new P's to the client, which checks them
+// against its credentials (see KeyshareRefresh.Verify and Credential.RefreshKeyshare). The client
+// in turn proves to the keyshare server that it applied the delta, by proving correctness of the
+// pseudonym of its new secret in a reserved scope (see KeyshareUserPublicShare), which must equal
+// the pseudonym of its previous secret times PseudonymBase(scope)^delta.
+//
+// As a result, after a refresh the secret of the keyshare server is nonpositive, and at most
+// Lm + Lstatzk + 1 bits in absolute value, so that it must be stored in a way that retains its
+// sign; the secret of the client is nonnegative and at most Lm + Lstatzk + 2 bits.
+// These bounds are independent of the number of refreshes. All values that are exchanged during
+// the refresh are nonnegative.
+
+// keyshareRefreshScope is the scope of the pseudonyms with which clients prove that they
+// correctly refreshed their secret. It must not be used for other purposes.
+const keyshareRefreshScope = "gabi-keyshare-refresh"
+
+// keyshareRefreshLength returns the bit length of the random values with which keyshare secrets
+// are refreshed, i.e. that of the secret key plus Lstatzk.
+func keyshareRefreshLength() uint {
+	return gabikeys.DefaultSystemParameters[1024].Lm + gabikeys.DefaultSystemParameters[2048].Lstatzk
+}
+
+// keyshareShareLength returns the maximum bit length of refreshed keyshare secrets.
+func keyshareShareLength() uint {
+	return keyshareRefreshLength() + 2
+}
+
+// keyshareRefreshOffset returns the offset that the keyshare server adds to its contribution to the
+// delta, so that it is nonnegative even if its secret is negative.
+func keyshareRefreshOffset() *big.Int {
+	return new(big.Int).Lsh(big.NewInt(1), keyshareRefreshLength()+1)
+}
+
+// KeyshareRefreshCommitment is the first message of the keyshare server to the client when
+// refreshing their secrets, committing to its contribution to the delta.
+type KeyshareRefreshCommitment struct {
+	Commitment *big.Int `json:"commitment"`
+}
+
+// KeyshareRefresh is the last message of the keyshare server to the client when refreshing their
+// secrets, containing the opening of its KeyshareRefreshCommitment and the new value of P for
+// each key.
+type KeyshareRefresh struct {
+	Delta   *big.Int   `json:"delta"`
+	Opening *big.Int   `json:"opening"`
+	P       []*big.Int `json:"P"`
+}
+
+// KeyshareRefreshSession holds the state of the keyshare server during a keyshare refresh.
+type KeyshareRefreshSession struct {
+	secret  *big.Int
+	delta   *big.Int
+	opening *big.Int
+	refresh *big.Int
+}
+
+// ErrInvalidKeyshareRefresh is returned when a party in a keyshare refresh did not correctly
+// refresh its secret.
+var ErrInvalidKeyshareRefresh = errors.New("keyshare secret not correctly refreshed")
+
+// NewKeyshareRefreshSession starts a refresh of the specified keyshare secret, returning the
+// session and the commitment to the contribution of the keyshare server, to be sent to the client.
+func NewKeyshareRefreshSession(secret *big.Int) (*KeyshareRefreshSession, *KeyshareRefreshCommitment, error) {
+	offset := keyshareRefreshOffset()
+	if new(big.Int).Neg(secret).Cmp(offset) >= 0 ||
+		(secret.Sign() > 0 && uint(secret.BitLen()) > gabikeys.DefaultSystemParameters[1024].Lm) {
+		return nil, nil, errors.New("keyshare secret out of range")
+	}
+	r, err := common.RandomBigInt(keyshareRefreshLength())
+	if err != nil {
+		return nil, nil, err
+	}
+	opening, err := common.RandomBigInt(gabikeys.DefaultSystemParameters[2048].Lh)
+	if err != nil {
+		return nil, nil, err
+	}
+	delta := new(big.Int).Add(secret, r)
+	delta.Add(delta, offset)
+	session := &KeyshareRefreshSession{secret: secret, delta: delta, opening: opening}
+	commitment := &KeyshareRefreshCommitment{Commitment: common.HashCommit([]*big.Int{delta, opening}, false)}
+	return session, commitment, nil
+}
+
+// NewKeyshareRefreshContribution returns a random contribution of the client to the delta of a
+// keyshare refresh, to be sent to the keyshare server after receiving its commitment.
+func NewKeyshareRefreshContribution() (*big.Int, error) {
+	return common.RandomBigInt(keyshareRefreshLength())
+}
+
+// Refresh completes the refresh given the contribution of the client, returning the new secret of
+// the keyshare server and the message to the client for the given set of keys. It may be called
+// only once per session.
+func (s *KeyshareRefreshSession) Refresh(contribution *big.Int, keys []*gabikeys.PublicKey) (*big.Int, *KeyshareRefresh, error) {
+	if s.refresh != nil {
+		return nil, nil, errors.New("keyshare refresh session already completed")
+	}
+	if err := checkKeyshareContribution(contribution, keyshareRefreshLength()); err != nil {
+		return nil, nil, err
+	}
+	s.refresh = keyshareRefreshDelta(s.delta, contribution)
+	newSecret := new(big.Int).Sub(s.secret, s.refresh)
+	refresh := &KeyshareRefresh{Delta: s.delta, Opening: s.opening}
+	for _, key := range keys {
+		p, err := common.ModPow(key.R[0], newSecret, key.N)
+		if err != nil {
+			return nil, nil, err
+		}
+		refresh.P = append(refresh.P, p)
+	}
+	return newSecret, refresh, nil
+}
+
+// Delta returns the delta of the refresh, which the keyshare server needs to verify the proof of
+// the client (see VerifyKeyshareRefreshProof), or nil if Refresh has not been called.
+func (s *KeyshareRefreshSession) Delta() *big.Int {
+	return s.refresh
+}
+
+// Verify checks that the keyshare server opened its commitment correctly and that its new P's are
+// consistent with the specified previous P's for the given set of keys, i.e., that
+// P_previous = P R_0^delta, given the contribution of the client to the delta. It returns the
+// delta, to be applied to the credentials of the client (see Credential.RefreshKeyshare).
+func (r *KeyshareRefresh) Verify(
+	keys []*gabikeys.PublicKey, previous []*big.Int, commitment *KeyshareRefreshCommitment, contribution *big.Int,
+) (*big.Int, error) {
+	if r.Opening == nil || commitment == nil || commitment.Commitment == nil {
+		return nil, ErrInvalidKeyshareRefresh
+	}
+	if err := checkKeyshareContribution(r.Delta, keyshareShareLength()); err != nil {
+		return nil, err
+	}
+	if err := checkKeyshareContribution(contribution, keyshareRefreshLength()); err != nil {
+		return nil, err
+	}
+	if common.HashCommit([]*big.Int{r.Delta, r.Opening}, false).Cmp(commitment.Commitment) != 0 {
+		return nil, ErrInvalidKeyshareRefresh
+	}
+	if len(r.P) != len(keys) || len(previous) != len(keys) {
+		return nil, ErrInvalidKeyshareRefresh
+	}
+	delta := keyshareRefreshDelta(r.Delta, contribution)
+	for i, key := range keys {
+		p, err := common.ModPow(key.R[0], delta, key.N)
+		if err != nil {
+			return nil, err
+		}
+		p.Mul(p, r.P[i]).Mod(p, key.N)
+		if p.Cmp(previous[i]) != 0 {
+			return nil, ErrInvalidKeyshareRefresh
+		}
+	}
+	return delta, nil
+}
+
+// keyshareRefreshDelta combines the contributions of the keyshare server and of the client into
+// the delta of a keyshare refresh.
+func keyshareRefreshDelta(delta, contribution *big.Int) *big.Int {
+	d := new(big.Int).Add(delta, contribution)
+	return d.Sub(d, keyshareRefreshOffset())
+}
+
+// checkKeyshareContribution checks that a contribution to the delta of a keyshare refresh is
+// nonnegative and at most the specified number of bits.
+func checkKeyshareContribution(contribution *big.Int, bits uint) error {
+	if contribution == nil || contribution.Sign() < 0 || uint(contribution.BitLen()) > bits {
+		return ErrInvalidKeyshareRefresh
+	}
+	return nil
+}
+
+// KeyshareUserPublicShare returns the public counterpart of the specified client secret, which
+// the keyshare server stores in order to check subsequent refreshes of it (see
+// VerifyKeyshareRefreshProof).
+func KeyshareUserPublicShare(secret *big.Int) *big.Int {
+	return Pseudonym(keyshareRefreshScope, secret)
+}
+
+// NewKeyshareRefreshProofBuilder returns a builder for the proof of the client to the keyshare
+// server that it correctly refreshed its secret to the specified new secret.
+func NewKeyshareRefreshProofBuilder(secret *big.Int) *PseudonymProofBuilder {
+	return NewPseudonymProofBuilder(keyshareRefreshScope, secret)
+}
+
+// VerifyKeyshareRefreshProof verifies that the client refreshed its secret using the specified
+// delta, given the public counterpart of its previous secret. If so, the keyshare server should
+// replace it by proof.Nym.
+func VerifyKeyshareRefreshProof(proof *ProofNym, previous, delta, context, nonce *big.Int) error {
+	if !proof.Verify(keyshareRefreshScope, context, nonce, false) {
+		return ErrInvalidKeyshareRefresh
+	}
+	expected, err := common.ModPow(PseudonymBase(keyshareRefreshScope), delta, pseudonymModulus)
+	if err != nil {
+		return err
+	}
+	expected.Mul(expected, previous).Mod(expected, pseudonymModulus)
+	if expected.Cmp(proof.Nym) != 0 {
+		return ErrInvalidKeyshareRefresh
+	}
+	return nil
+}
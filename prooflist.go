@@ -5,10 +5,15 @@
 package gabi
 
 import (
+	"fmt"
+	"sync"
+
 	"github.com/go-errors/errors"
 	"github.com/privacybydesign/gabi/big"
 	"github.com/privacybydesign/gabi/gabikeys"
 	"github.com/privacybydesign/gabi/internal/common"
+	"github.com/privacybydesign/gabi/rangeproof"
+	"github.com/privacybydesign/gabi/revocation"
 )
 
 // ProofBuilder is an interface for a proof builder. That is, an object to hold
@@ -20,18 +25,114 @@ type ProofBuilder interface {
 	MergeProofPCommitment(commitment *ProofPCommitment)
 }
 
-// ProofList represents a list of (typically bound) proofs.
+// ProofList represents a list of (typically bound) proofs. It does not contain the
+// attribute equalities proven among its proofs, which verifiers must know out of
+// band (see AttributeEquality).
 type ProofList []Proof
 
 // ProofBuilderList is a list of proof builders, for calculating a list of bound proofs.
 type ProofBuilderList []ProofBuilder
 
+// AttributeIndex identifies an attribute within a ProofList (or ProofBuilderList),
+// by the index of the proof and the index of the attribute in its credential.
+type AttributeIndex struct {
+	Proof     int `json:"proof"`
+	Attribute int `json:"attribute"`
+}
+
+// AttributeEquality is a set of undisclosed attributes within a ProofList that
+// are proven to be equal, by using the same randomizer for them during Commit
+// so that their responses are equal.
+//
+// The equalities are not included in the proofs: the verifier must know which
+// attributes are supposed to be equal, e.g. because it requested them (and
+// the order of the credentials) in its session request, and pass them to
+// ProofList.VerifyAttributeEqualities. Verification fails if prover and
+// verifier do not agree on them.
+type AttributeEquality []AttributeIndex
+
 var (
 	// ErrMissingProofU is returned when a ProofU proof is missing in a prooflist
 	// when this is expected.
 	ErrMissingProofU = errors.New("Missing ProofU in ProofList, has a CredentialBuilder been added?")
+
+	// The following are the reasons that a VerificationError may contain.
+
+	// ErrProofListLength is returned when a ProofList is empty, or when its length
+	// does not match the number of public keys or keyshare servers.
+	ErrProofListLength = errors.New("ProofList is empty or has wrong length")
+	// ErrChallengeMismatch is returned when the challenge of a proof does not
+	// match the reconstructed challenge. As the challenge is shared by all proofs
+	// in a ProofList, this is normally reported for its first proof, whichever
+	// proof was modified.
+	ErrChallengeMismatch = errors.New("challenge does not match reconstructed challenge")
+	// ErrResponseSize is returned when a response in a proof is out of range.
+	ErrResponseSize = errors.New("response out of range")
+	// ErrInvalidRangeProof is returned when a range proof, or a set-membership,
+	// not-equal, interval or comparison proof, in a ProofD is invalid.
+	ErrInvalidRangeProof = errors.New("invalid range proof")
+	// ErrInvalidEncryptionProof is returned when a verifiable encryption in a
+	// ProofD is invalid.
+	ErrInvalidEncryptionProof = errors.New("invalid verifiable encryption")
+	// ErrInvalidNonRevocationProof is returned when the nonrevocation proof in a
+	// ProofD is invalid.
+	ErrInvalidNonRevocationProof = errors.New("invalid nonrevocation proof")
+	// ErrStaleAccumulator is returned when the nonrevocation proof in a ProofD is made
+	// against an accumulator that is not accepted by the verification policy; the Err field
+	// of the VerificationError contains the *revocation.StaleAccumulatorError.
+	ErrStaleAccumulator = errors.New("nonrevocation proof against stale accumulator")
+	// ErrSecretKeyMismatch is returned when the secret key response of a proof
+	// differs from that of a preceding proof in the same keyshare group.
+	ErrSecretKeyMismatch = errors.New("secret key response does not match")
+	// ErrMalformedProof is returned when a proof could otherwise not be verified;
+	// the Err field of the VerificationError contains the cause.
+	ErrMalformedProof = errors.New("malformed proof")
+
+	verificationReasons = []error{ErrProofListLength, ErrChallengeMismatch, ErrResponseSize,
+		ErrInvalidRangeProof, ErrInvalidEncryptionProof, ErrInvalidNonRevocationProof, ErrStaleAccumulator,
+		ErrSecretKeyMismatch, ErrMalformedProof}
 )
 
+// VerificationError describes why a proof failed to verify. Index is the index of the
+// failing proof within its ProofList (or 0 for single proofs), or -1 if the failure does
+// not concern a single proof. Reason is one of the ErrXXX reasons listed above, and Err
+// is the underlying error, if any.
+type VerificationError struct {
+	Index  int
+	Reason error
+	Err    error
+}
+
+func (e *VerificationError) Error() string {
+	msg := e.Reason.Error()
+	if e.Index >= 0 {
+		msg = fmt.Sprintf("proof %d: %s", e.Index, msg)
+	}
+	if e.Err != nil {
+		msg = fmt.Sprintf("%s: %s", msg, e.Err.Error())
+	}
+	return msg
+}
+
+// Unwrap returns the reason, so that errors.Is(err, ErrXXX) can be used on verification errors.
+func (e *VerificationError) Unwrap() error {
+	return e.Reason
+}
+
+// newVerificationError returns a VerificationError for the proof at the specified index,
+// classifying err if it is not a VerificationError already.
+func newVerificationError(index int, err error) *VerificationError {
+	if e, ok := err.(*VerificationError); ok {
+		return &VerificationError{Index: index, Reason: e.Reason, Err: e.Err}
+	}
+	for _, reason := range verificationReasons {
+		if err == reason {
+			return &VerificationError{Index: index, Reason: reason}
+		}
+	}
+	return &VerificationError{Index: index, Reason: ErrMalformedProof, Err: err}
+}
+
 // GetProofU returns the n'th ProofU in this proof list.
 func (pl ProofList) GetProofU(n int) (*ProofU, error) {
 	count := 0
@@ -47,25 +148,115 @@ func (pl ProofList) GetProofU(n int) (*ProofU, error) {
 	return nil, ErrMissingProofU
 }
 
+// indexOf returns the index of the specified proof in this proof list, or -1.
+func (pl ProofList) indexOf(proof Proof) int {
+	for i, p := range pl {
+		if p == proof {
+			return i
+		}
+	}
+	return -1
+}
+
 // GetFirstProofU returns the first ProofU in this proof list
 func (pl ProofList) GetFirstProofU() (*ProofU, error) {
 	return pl.GetProofU(0)
 }
 
 // challengeContributions collects and returns all the challenge contributions
-// of the proofs contained in the proof list.
-func (pl ProofList) challengeContributions(publicKeys []*gabikeys.PublicKey, context, nonce *big.Int) ([]*big.Int, error) {
+// of the proofs contained in the proof list. If workers > 1, the contributions
+// of at most that many proofs are computed concurrently.
+func (pl ProofList) challengeContributions(publicKeys []*gabikeys.PublicKey, context, nonce *big.Int, workers int) ([]*big.Int, error) {
+	contribs := make([][]*big.Int, len(pl))
+	errs := make([]error, len(pl))
+	if workers <= 1 {
+		for i, proof := range pl {
+			if contribs[i], errs[i] = proof.ChallengeContribution(publicKeys[i]); errs[i] != nil {
+				break
+			}
+		}
+	} else {
+		var wg sync.WaitGroup
+		sem := make(chan struct{}, workers)
+		for i, proof := range pl {
+			wg.Add(1)
+			sem <- struct{}{}
+			go func(i int, proof Proof) {
+				defer func() { <-sem; wg.Done() }()
+				contribs[i], errs[i] = proof.ChallengeContribution(publicKeys[i])
+			}(i, proof)
+		}
+		wg.Wait()
+	}
+
+	// Collect the contributions in order, so that the result does not depend on scheduling
 	contributions := make([]*big.Int, 0, len(pl)*2)
-	for i, proof := range pl {
-		contrib, err := proof.ChallengeContribution(publicKeys[i])
-		if err != nil {
-			return nil, err
+	for i := range pl {
+		if errs[i] != nil {
+			return nil, newVerificationError(i, errs[i])
 		}
-		contributions = append(contributions, contrib...)
+		contributions = append(contributions, contribs[i]...)
 	}
 	return contributions, nil
 }
 
+// VerifyAttributeEqualities returns true when each of the specified sets of
+// attributes are proven to be equal, by checking that their responses are
+// equal. Supported are undisclosed attributes of ProofD's and carried over
+// attributes of ProofU's. This should be called only after the proof list has
+// been verified (see Verify). As the proofs do not contain the equalities that
+// were proven, the verifier must specify the exact (proof, attribute) pairs that
+// the prover was asked to prove equal (see AttributeEquality).
+func (pl ProofList) VerifyAttributeEqualities(equalities []AttributeEquality) bool {
+	for _, equality := range equalities {
+		var response *big.Int
+		for _, attr := range equality {
+			if attr.Proof < 0 || attr.Proof >= len(pl) || attr.Attribute == 0 {
+				return false
+			}
+			var r *big.Int
+			switch proof := pl[attr.Proof].(type) {
+			case *ProofD:
+				r = proof.AResponses[attr.Attribute]
+			case *ProofU:
+				r = proof.MUserResponses[attr.Attribute]
+			}
+			if r == nil {
+				return false
+			}
+			if response == nil {
+				response = r
+			} else if response.Cmp(r) != 0 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// VerifyRangeStatements returns true when the range proofs of each ProofD in the proof list prove
+// exactly the statements at its index in the specified slice (see ProofD.VerifyRangeStatements),
+// and proofs of other types contain no statements there. This should be called only after the
+// proof list has been verified (see Verify).
+func (pl ProofList) VerifyRangeStatements(statements []map[int][]*rangeproof.Statement) bool {
+	if len(statements) != len(pl) {
+		return false
+	}
+	for i, proof := range pl {
+		proofd, ok := proof.(*ProofD)
+		if !ok {
+			if len(statements[i]) != 0 {
+				return false
+			}
+			continue
+		}
+		if !proofd.VerifyRangeStatements(statements[i]) {
+			return false
+		}
+	}
+	return true
+}
+
 // Verify returns true when all the proofs inside verify.
 // The keyshareServers parameter is used to indicate which proofs should be
 // verified to share the same secret key: when two proofs share the same keyshare
@@ -75,10 +266,23 @@ func (pl ProofList) challengeContributions(publicKeys []*gabikeys.PublicKey, con
 // or one and the same keyshare server).
 // An empty ProofList is not considered valid.
 func (pl ProofList) Verify(publicKeys []*gabikeys.PublicKey, context, nonce *big.Int, issig bool, keyshareServers []string) bool {
+	return pl.VerifyWithError(publicKeys, context, nonce, issig, keyshareServers) == nil
+}
+
+// VerifyWithError verifies the proofs like Verify, returning a *VerificationError
+// describing the first failing proof and the reason it failed, or nil if all proofs verify.
+func (pl ProofList) VerifyWithError(publicKeys []*gabikeys.PublicKey, context, nonce *big.Int, issig bool, keyshareServers []string) error {
+	return pl.VerifyConcurrently(publicKeys, context, nonce, issig, keyshareServers, 1)
+}
+
+// VerifyConcurrently verifies the proofs like VerifyWithError, reconstructing the
+// challenge contributions of at most workers proofs concurrently (e.g. runtime.NumCPU()).
+// If workers <= 1 the proofs are processed serially. The result does not depend on workers.
+func (pl ProofList) VerifyConcurrently(publicKeys []*gabikeys.PublicKey, context, nonce *big.Int, issig bool, keyshareServers []string, workers int) error {
 	if len(pl) == 0 ||
 		len(pl) != len(publicKeys) ||
 		len(keyshareServers) > 0 && len(pl) != len(keyshareServers) {
-		return false
+		return &VerificationError{Index: -1, Reason: ErrProofListLength}
 	}
 
 	// If the secret key comes from a credential whose scheme manager has a keyshare server,
@@ -88,9 +292,9 @@ func (pl ProofList) Verify(publicKeys []*gabikeys.PublicKey, context, nonce *big
 	// During verification of the proofs we keep track of their secret key responses in this map.
 	secretkeyResponses := make(map[string]*big.Int)
 
-	contributions, err := pl.challengeContributions(publicKeys, context, nonce)
+	contributions, err := pl.challengeContributions(publicKeys, context, nonce, workers)
 	if err != nil {
-		return false
+		return err
 	}
 	expectedChallenge := createChallenge(context, nonce, contributions, issig)
 
@@ -99,8 +303,14 @@ func (pl ProofList) Verify(publicKeys []*gabikeys.PublicKey, context, nonce *big
 	kss := ""
 
 	for i, proof := range pl {
-		if !proof.VerifyWithChallenge(publicKeys[i], expectedChallenge) {
-			return false
+		if v, ok := proof.(interface {
+			verifyWithChallenge(*gabikeys.PublicKey, *big.Int) error
+		}); ok {
+			if err := v.verifyWithChallenge(publicKeys[i], expectedChallenge); err != nil {
+				return newVerificationError(i, err)
+			}
+		} else if !proof.VerifyWithChallenge(publicKeys[i], expectedChallenge) {
+			return &VerificationError{Index: i, Reason: ErrChallengeMismatch}
 		}
 		if len(keyshareServers) > 0 {
 			kss = keyshareServers[i]
@@ -111,27 +321,65 @@ func (pl ProofList) Verify(publicKeys []*gabikeys.PublicKey, context, nonce *big
 		} else {
 			// We've already seen this keyshare server, secret key response should match earlier one
 			if response.Cmp(proof.SecretKeyResponse()) != 0 {
-				return false
+				return &VerificationError{Index: i, Reason: ErrSecretKeyMismatch}
 			}
 		}
 	}
 
-	return true
+	return nil
+}
+
+// VerifyWithNonRevocationPolicy verifies the proofs like VerifyWithError, and additionally
+// checks that the nonrevocation proof of each ProofD containing one is made against an
+// accumulator accepted by the policy (see ProofD.VerifyNonRevocationPolicy). A nil policy
+// accepts any accumulator.
+func (pl ProofList) VerifyWithNonRevocationPolicy(
+	publicKeys []*gabikeys.PublicKey, context, nonce *big.Int, issig bool, keyshareServers []string,
+	policy *revocation.VerificationPolicy,
+) error {
+	if err := pl.VerifyWithError(publicKeys, context, nonce, issig, keyshareServers); err != nil {
+		return err
+	}
+	for i, proof := range pl {
+		if proofd, ok := proof.(*ProofD); ok && proofd.HasNonRevocationProof() {
+			if err := proofd.verifyNonRevocationPolicy(publicKeys[i], policy); err != nil {
+				return newVerificationError(i, err)
+			}
+		}
+	}
+	return nil
+}
+
+// secretKeyProofBuilder is implemented by the ProofBuilders that prove knowledge of the secret
+// key, so that Challenge can choose the size of its randomizer.
+type secretKeyProofBuilder interface {
+	secretKey() *big.Int
 }
 
 func (builders ProofBuilderList) Challenge(context, nonce *big.Int, issig bool) (*big.Int, error) {
 	// The secret key may be used across credentials supporting different attribute sizes.
 	// So we should take it, and hence also its commitment, to fit within the smallest size -
 	// otherwise it will be too big so that we cannot perform the range proof showing
-	// that it is not too big.
-	skCommitment, err := common.RandomBigInt(gabikeys.DefaultSystemParameters[1024].LmCommit)
+	// that it is not too big. Refreshed keyshare secrets are larger (see KeyshareRefresh), so
+	// that they require a larger randomizer.
+	skLength := gabikeys.DefaultSystemParameters[1024].LmCommit
+	for _, pb := range builders {
+		if b, ok := pb.(secretKeyProofBuilder); ok && isRefreshedKeyshareSecret(b.secretKey()) {
+			skLength = refreshedKeyshareRandomizerLength()
+		}
+	}
+	skCommitment, err := common.RandomBigInt(skLength)
 	if err != nil {
 		return nil, err
 	}
 
+	// The randomizers are shared among all builders, so that builders that use the same
+	// (named) randomizer prove that the corresponding secrets are equal
+	randomizers := map[string]*big.Int{"secretkey": skCommitment}
+
 	commitmentValues := make([]*big.Int, 0, len(builders)*2)
 	for _, pb := range builders {
-		contributions, err := pb.Commit(map[string]*big.Int{"secretkey": skCommitment})
+		contributions, err := pb.Commit(randomizers)
 		if err != nil {
 			return nil, err
 		}
@@ -142,6 +390,48 @@ func (builders ProofBuilderList) Challenge(context, nonce *big.Int, issig bool)
 	return createChallenge(context, nonce, commitmentValues, issig), nil
 }
 
+// sharedRandomizer returns the randomizer with the specified name from the randomizers
+// shared among builders, creating it if necessary (see SetAttributeEqualities).
+func sharedRandomizer(randomizers map[string]*big.Int, name string) (*big.Int, error) {
+	if randomizers[name] == nil {
+		// Like the secret key randomizer, the randomizer is shared with credentials
+		// of other key sizes, so it must fit within the smallest size
+		r, err := common.RandomBigInt(gabikeys.DefaultSystemParameters[1024].LmCommit)
+		if err != nil {
+			return nil, err
+		}
+		randomizers[name] = r
+	}
+	return randomizers[name], nil
+}
+
+// SetAttributeEqualities configures the builders to prove that each of the specified
+// sets of attributes are equal (see AttributeEquality). This must be called before
+// Challenge. Supported are undisclosed attributes of DisclosureProofBuilders, excluding
+// the secret key and the revocation attribute, and carried over attributes of
+// CredentialBuilders (see CredentialBuilder.CarryOver). The equalities are not
+// included in the resulting proofs, so the verifier needs them as well.
+func (builders ProofBuilderList) SetAttributeEqualities(equalities []AttributeEquality) error {
+	for i, equality := range equalities {
+		name := fmt.Sprintf("attribute-equality-%d", i)
+		for _, attr := range equality {
+			if attr.Proof < 0 || attr.Proof >= len(builders) {
+				return errors.New("attribute equality refers to nonexisting proof")
+			}
+			builder, ok := builders[attr.Proof].(interface {
+				shareRandomizer(index int, name string) error
+			})
+			if !ok {
+				return errors.New("attribute equality refers to unsupported proof builder")
+			}
+			if err := builder.shareRandomizer(attr.Attribute, name); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
 func (builders ProofBuilderList) BuildDistributedProofList(
 	challenge *big.Int, proofPs []*ProofP,
 ) (ProofList, error) {
@@ -10,6 +10,7 @@ import (
 	"github.com/privacybydesign/gabi/internal/common"
 	"github.com/privacybydesign/gabi/rangeproof"
 	"github.com/privacybydesign/gabi/revocation"
+	"github.com/privacybydesign/gabi/verenc"
 
 	"github.com/go-errors/errors"
 )
@@ -71,7 +72,31 @@ func (p *ProofU) correctResponseSizes(pk *gabikeys.PublicKey) bool {
 
 // VerifyWithChallenge verifies whether the proof is correct.
 func (p *ProofU) VerifyWithChallenge(pk *gabikeys.PublicKey, reconstructedChallenge *big.Int) bool {
-	return p.correctResponseSizes(pk) && p.C.Cmp(reconstructedChallenge) == 0
+	return p.verifyWithChallenge(pk, reconstructedChallenge) == nil
+}
+
+func (p *ProofU) verifyWithChallenge(pk *gabikeys.PublicKey, reconstructedChallenge *big.Int) error {
+	if !p.correctResponseSizes(pk) {
+		return ErrResponseSize
+	}
+	if p.C.Cmp(reconstructedChallenge) != 0 {
+		return ErrChallengeMismatch
+	}
+	return nil
+}
+
+// hasUserShares checks that the proof contains user shares for exactly the
+// specified random blind attributes.
+func (p *ProofU) hasUserShares(blind []int) bool {
+	if len(p.MUserResponses) != len(blind) {
+		return false
+	}
+	for _, i := range blind {
+		if _, ok := p.MUserResponses[i+1]; !ok {
+			return false
+		}
+	}
+	return true
 }
 
 // reconstructUcommit reconstructs U from the information in the proof and the
@@ -161,7 +186,17 @@ type ProofD struct {
 	NonRevocationProof *revocation.Proof           `json:"nonrev_proof,omitempty"`
 	RangeProofs        map[int][]*rangeproof.Proof `json:"rangeproofs,omitempty"`
 
-	cachedRangeStructures map[int][]*rangeproof.ProofStructure
+	SetMembershipProofs   map[int][]*rangeproof.SetMembershipProof `json:"setmembershipproofs,omitempty"`
+	NotEqualProofs        map[int][]*rangeproof.NotEqualProof      `json:"notequalproofs,omitempty"`
+	IntervalProofs        map[int][]*rangeproof.IntervalProof      `json:"intervalproofs,omitempty"`
+	ComparisonProofs      []*ComparisonProof                       `json:"comparisonproofs,omitempty"`
+	VerifiableEncryptions map[int][]*verenc.Proof                  `json:"verifiableencryptions,omitempty"`
+
+	cachedRangeStructures         map[int][]*rangeproof.ProofStructure
+	cachedSetMembershipStructures map[int][]*rangeproof.SetMembershipProofStructure
+	cachedNotEqualStructures      map[int][]*rangeproof.NotEqualProofStructure
+	cachedIntervalStructures      map[int][]*rangeproof.IntervalProofStructure
+	cachedEncryptionStructures    map[int][]*verenc.ProofStructure
 }
 
 func (p *ProofD) MergeProofP(proofP *ProofP, pk *gabikeys.PublicKey) {
@@ -169,27 +204,110 @@ func (p *ProofD) MergeProofP(proofP *ProofP, pk *gabikeys.PublicKey) {
 }
 
 func (p *ProofD) reconstructRangeProofStructures(pk *gabikeys.PublicKey) error {
-	p.cachedRangeStructures = make(map[int][]*rangeproof.ProofStructure)
+	structures := make(map[int][]*rangeproof.ProofStructure)
 	for index, proofs := range p.RangeProofs {
-		p.cachedRangeStructures[index] = []*rangeproof.ProofStructure{}
+		structures[index] = []*rangeproof.ProofStructure{}
+		for _, proof := range proofs {
+			s, err := proof.ExtractStructure(index, pk)
+			if err != nil {
+				return err
+			}
+			structures[index] = append(structures[index], s)
+		}
+	}
+	p.cachedRangeStructures = structures
+	return nil
+}
+
+func (p *ProofD) reconstructSetMembershipProofStructures(pk *gabikeys.PublicKey) error {
+	structures := make(map[int][]*rangeproof.SetMembershipProofStructure)
+	for index, proofs := range p.SetMembershipProofs {
+		if index <= 0 || index >= len(pk.R) || p.AResponses[index] == nil {
+			return errors.New("set membership proof on nonexisting or disclosed attribute")
+		}
+		for _, proof := range proofs {
+			s, err := proof.ExtractStructure(index, pk)
+			if err != nil {
+				return err
+			}
+			structures[index] = append(structures[index], s)
+		}
+	}
+	p.cachedSetMembershipStructures = structures
+	return nil
+}
+
+func (p *ProofD) reconstructNotEqualProofStructures(pk *gabikeys.PublicKey) error {
+	structures := make(map[int][]*rangeproof.NotEqualProofStructure)
+	for index, proofs := range p.NotEqualProofs {
+		if index <= 0 || index >= len(pk.R) || p.AResponses[index] == nil {
+			return errors.New("not-equal proof on nonexisting or disclosed attribute")
+		}
 		for _, proof := range proofs {
 			s, err := proof.ExtractStructure(index, pk)
 			if err != nil {
 				return err
 			}
-			p.cachedRangeStructures[index] = append(p.cachedRangeStructures[index], s)
+			structures[index] = append(structures[index], s)
 		}
 	}
+	p.cachedNotEqualStructures = structures
+	return nil
+}
+
+func (p *ProofD) reconstructIntervalProofStructures(pk *gabikeys.PublicKey) error {
+	structures := make(map[int][]*rangeproof.IntervalProofStructure)
+	for index, proofs := range p.IntervalProofs {
+		if index <= 0 || index >= len(pk.R) || p.AResponses[index] == nil {
+			return errors.New("interval proof on nonexisting or disclosed attribute")
+		}
+		for _, proof := range proofs {
+			s, err := proof.ExtractStructure(index, pk)
+			if err != nil {
+				return err
+			}
+			structures[index] = append(structures[index], s)
+		}
+	}
+	p.cachedIntervalStructures = structures
+	return nil
+}
+
+func (p *ProofD) reconstructEncryptionProofStructures(pk *gabikeys.PublicKey) error {
+	structures := make(map[int][]*verenc.ProofStructure)
+	for index, proofs := range p.VerifiableEncryptions {
+		if index <= 0 || index >= len(pk.R) || p.AResponses[index] == nil {
+			return errors.New("verifiable encryption of nonexisting or disclosed attribute")
+		}
+		for _, proof := range proofs {
+			s, err := proof.ExtractStructure(index, pk)
+			if err != nil {
+				return err
+			}
+			structures[index] = append(structures[index], s)
+		}
+	}
+	p.cachedEncryptionStructures = structures
 	return nil
 }
 
 // correctResponseSizes checks the sizes of the elements in the ProofD proof.
 func (p *ProofD) correctResponseSizes(pk *gabikeys.PublicKey) bool {
-	// Check range on the AResponses
+	// Check range on the AResponses. The response for the secret key may be larger if it is a
+	// refreshed keyshare secret (see KeyshareRefresh).
 	maximum := new(big.Int).Lsh(big.NewInt(1), pk.Params.LmCommit+1)
 	maximum.Sub(maximum, big.NewInt(1))
 	minimum := new(big.Int).Neg(maximum)
-	for _, aResponse := range p.AResponses {
+	skMaximum := new(big.Int).Lsh(big.NewInt(1), secretKeyResponseLength())
+	skMaximum.Sub(skMaximum, big.NewInt(1))
+	if skMaximum.Cmp(maximum) < 0 {
+		skMaximum.Set(maximum)
+	}
+	skMinimum := new(big.Int).Neg(skMaximum)
+	for i, aResponse := range p.AResponses {
+		if i == 0 && aResponse.Cmp(skMinimum) >= 0 && aResponse.Cmp(skMaximum) <= 0 {
+			continue
+		}
 		if aResponse.Cmp(minimum) < 0 || aResponse.Cmp(maximum) > 0 {
 			return false
 		}
@@ -256,36 +374,105 @@ func (p *ProofD) reconstructZ(pk *gabikeys.PublicKey) (*big.Int, error) {
 
 // Verify verifies the proof against the given public key, context, and nonce.
 func (p *ProofD) Verify(pk *gabikeys.PublicKey, context, nonce1 *big.Int, issig bool) bool {
+	return p.VerifyWithError(pk, context, nonce1, issig) == nil
+}
+
+// VerifyWithError verifies the proof against the given public key, context, and nonce,
+// returning a *VerificationError describing the reason if the proof is invalid.
+func (p *ProofD) VerifyWithError(pk *gabikeys.PublicKey, context, nonce1 *big.Int, issig bool) error {
 	contrib, err := p.ChallengeContribution(pk)
 	if err != nil {
-		return false
+		return newVerificationError(0, err)
+	}
+	if err = p.verifyWithChallenge(pk, createChallenge(context, nonce1, contrib, issig)); err != nil {
+		return newVerificationError(0, err)
 	}
-	return p.VerifyWithChallenge(pk, createChallenge(context, nonce1, contrib, issig))
+	return nil
 }
 
 func (p *ProofD) HasNonRevocationProof() bool {
 	return p.NonRevocationProof != nil
 }
 
+// VerifyNonRevocationPolicy checks that the nonrevocation proof of the ProofD is made against an
+// accumulator that is validly signed and accepted by the policy, returning a *VerificationError
+// (with Index 0, as in VerifyWithError) if it is not. Its reason is ErrStaleAccumulator if the
+// accumulator is not accepted by the policy, in which case its Err is the
+// *revocation.StaleAccumulatorError. This does not verify the proof itself; verifiers of a
+// ProofList should use ProofList.VerifyWithNonRevocationPolicy, which does both.
+func (p *ProofD) VerifyNonRevocationPolicy(pk *gabikeys.PublicKey, policy *revocation.VerificationPolicy) error {
+	if err := p.verifyNonRevocationPolicy(pk, policy); err != nil {
+		return newVerificationError(0, err)
+	}
+	return nil
+}
+
+// VerifyRangeStatements returns true when the range proofs of the ProofD prove exactly the
+// specified statements, per attribute index and in the order in which they were passed to
+// Credential.CreateDisclosureProofBuilder, and no others (see rangeproof.Proof.ProvesExactly).
+// This should be called only after the proof has been verified (see Verify).
+func (p *ProofD) VerifyRangeStatements(statements map[int][]*rangeproof.Statement) bool {
+	for index, proofs := range p.RangeProofs {
+		if len(proofs) != 0 && len(statements[index]) == 0 {
+			return false
+		}
+	}
+	for index, s := range statements {
+		if len(p.RangeProofs[index]) != len(s) {
+			return false
+		}
+		for i, statement := range s {
+			if p.RangeProofs[index][i] == nil || !p.RangeProofs[index][i].ProvesExactly(statement) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (p *ProofD) verifyNonRevocationPolicy(pk *gabikeys.PublicKey, policy *revocation.VerificationPolicy) error {
+	if !p.HasNonRevocationProof() || p.NonRevocationProof.SignedAccumulator == nil {
+		return &VerificationError{Reason: ErrInvalidNonRevocationProof, Err: errors.New("no nonrevocation proof")}
+	}
+	acc, err := p.NonRevocationProof.SignedAccumulator.UnmarshalVerify(pk)
+	if err != nil {
+		return &VerificationError{Reason: ErrInvalidNonRevocationProof, Err: err}
+	}
+	if policy == nil {
+		return nil
+	}
+	if err = policy.Check(acc); err != nil {
+		return &VerificationError{Reason: ErrStaleAccumulator, Err: err}
+	}
+	return nil
+}
+
 // Verify verifies the proof against the given public key and the provided
 // reconstruted challenge.
 func (p *ProofD) VerifyWithChallenge(pk *gabikeys.PublicKey, reconstructedChallenge *big.Int) bool {
-	var notrevoked bool
+	return p.verifyWithChallenge(pk, reconstructedChallenge) == nil
+}
+
+func (p *ProofD) verifyWithChallenge(pk *gabikeys.PublicKey, reconstructedChallenge *big.Int) error {
 	// Validate non-revocation
 	if p.HasNonRevocationProof() {
 		revIdx := p.revocationAttrIndex()
 		if revIdx < 0 || p.AResponses[revIdx] == nil {
-			return false
+			return ErrInvalidNonRevocationProof
+		}
+		if !p.NonRevocationProof.VerifyWithChallenge(pk, reconstructedChallenge) ||
+			p.NonRevocationProof.Responses["alpha"].Cmp(p.AResponses[revIdx]) != 0 {
+			return ErrInvalidNonRevocationProof
 		}
-		notrevoked = p.NonRevocationProof.VerifyWithChallenge(pk, reconstructedChallenge) &&
-			p.NonRevocationProof.Responses["alpha"].Cmp(p.AResponses[revIdx]) == 0
-	} else {
-		notrevoked = true
 	}
 	// Range proofs were already validated during challenge reconstruction
-	return notrevoked &&
-		p.correctResponseSizes(pk) &&
-		p.C.Cmp(reconstructedChallenge) == 0
+	if !p.correctResponseSizes(pk) {
+		return ErrResponseSize
+	}
+	if p.C.Cmp(reconstructedChallenge) != 0 {
+		return ErrChallengeMismatch
+	}
+	return nil
 }
 
 // ChallengeContribution returns the contribution of this proof to the
@@ -300,10 +487,10 @@ func (p *ProofD) ChallengeContribution(pk *gabikeys.PublicKey) ([]*big.Int, erro
 	if p.NonRevocationProof != nil {
 		revIdx := p.revocationAttrIndex()
 		if revIdx < 0 || p.AResponses[revIdx] == nil {
-			return nil, errors.New("no revocation response found")
+			return nil, ErrInvalidNonRevocationProof
 		}
 		if err := p.NonRevocationProof.SetExpected(pk, p.C, p.AResponses[revIdx]); err != nil {
-			return nil, err
+			return nil, &VerificationError{Reason: ErrInvalidNonRevocationProof, Err: err}
 		}
 		contrib := p.NonRevocationProof.ChallengeContributions(pk)
 		l = append(l, contrib...)
@@ -312,7 +499,7 @@ func (p *ProofD) ChallengeContribution(pk *gabikeys.PublicKey) ([]*big.Int, erro
 	if p.RangeProofs != nil {
 		if p.cachedRangeStructures == nil {
 			if err := p.reconstructRangeProofStructures(pk); err != nil {
-				return nil, err
+				return nil, &VerificationError{Reason: ErrInvalidRangeProof, Err: err}
 			}
 		}
 		// need stable attribute order for rangeproof contributions, so determine max undisclosed attribute
@@ -330,13 +517,119 @@ func (p *ProofD) ChallengeContribution(pk *gabikeys.PublicKey) ([]*big.Int, erro
 			for i, s := range structures {
 				p.RangeProofs[index][i].MResponse = new(big.Int).Set(p.AResponses[index])
 				if !s.VerifyProofStructure(pk, p.RangeProofs[index][i]) {
-					return nil, errors.New("Invalid range proof")
+					return nil, ErrInvalidRangeProof
 				}
 				l = append(l, s.CommitmentsFromProof(pk, p.RangeProofs[index][i], p.C)...)
 			}
 		}
 	}
 
+	if p.SetMembershipProofs != nil {
+		if p.cachedSetMembershipStructures == nil {
+			if err := p.reconstructSetMembershipProofStructures(pk); err != nil {
+				return nil, &VerificationError{Reason: ErrInvalidRangeProof, Err: err}
+			}
+		}
+		for index := 0; index < len(pk.R); index++ {
+			structures, ok := p.cachedSetMembershipStructures[index]
+			if !ok {
+				continue
+			}
+			if len(structures) != len(p.SetMembershipProofs[index]) {
+				return nil, ErrInvalidRangeProof
+			}
+			for i, s := range structures {
+				p.SetMembershipProofs[index][i].MResponse = new(big.Int).Set(p.AResponses[index])
+				if !s.VerifyProofStructure(pk, p.SetMembershipProofs[index][i]) {
+					return nil, ErrInvalidRangeProof
+				}
+				l = append(l, s.CommitmentsFromProof(pk, p.SetMembershipProofs[index][i], p.C)...)
+			}
+		}
+	}
+
+	if p.NotEqualProofs != nil {
+		if p.cachedNotEqualStructures == nil {
+			if err := p.reconstructNotEqualProofStructures(pk); err != nil {
+				return nil, &VerificationError{Reason: ErrInvalidRangeProof, Err: err}
+			}
+		}
+		for index := 0; index < len(pk.R); index++ {
+			structures, ok := p.cachedNotEqualStructures[index]
+			if !ok {
+				continue
+			}
+			if len(structures) != len(p.NotEqualProofs[index]) {
+				return nil, ErrInvalidRangeProof
+			}
+			for i, s := range structures {
+				p.NotEqualProofs[index][i].MResponse = new(big.Int).Set(p.AResponses[index])
+				if !s.VerifyProofStructure(pk, p.NotEqualProofs[index][i]) {
+					return nil, ErrInvalidRangeProof
+				}
+				l = append(l, s.CommitmentsFromProof(pk, p.NotEqualProofs[index][i], p.C)...)
+			}
+		}
+	}
+
+	if p.IntervalProofs != nil {
+		if p.cachedIntervalStructures == nil {
+			if err := p.reconstructIntervalProofStructures(pk); err != nil {
+				return nil, &VerificationError{Reason: ErrInvalidRangeProof, Err: err}
+			}
+		}
+		for index := 0; index < len(pk.R); index++ {
+			structures, ok := p.cachedIntervalStructures[index]
+			if !ok {
+				continue
+			}
+			if len(structures) != len(p.IntervalProofs[index]) {
+				return nil, ErrInvalidRangeProof
+			}
+			for i, s := range structures {
+				p.IntervalProofs[index][i].MResponse = new(big.Int).Set(p.AResponses[index])
+				if !s.VerifyProofStructure(pk, p.IntervalProofs[index][i]) {
+					return nil, ErrInvalidRangeProof
+				}
+				l = append(l, s.CommitmentsFromProof(pk, p.IntervalProofs[index][i], p.C)...)
+			}
+		}
+	}
+
+	if p.ComparisonProofs != nil {
+		contributions, err := p.comparisonContributions(pk)
+		if err != nil {
+			return nil, err
+		}
+		l = append(l, contributions...)
+	}
+
+	if p.VerifiableEncryptions != nil {
+		if p.cachedEncryptionStructures == nil {
+			if err := p.reconstructEncryptionProofStructures(pk); err != nil {
+				return nil, &VerificationError{Reason: ErrInvalidEncryptionProof, Err: err}
+			}
+		}
+		for index := 0; index < len(pk.R); index++ {
+			structures, ok := p.cachedEncryptionStructures[index]
+			if !ok {
+				continue
+			}
+			if len(structures) != len(p.VerifiableEncryptions[index]) {
+				return nil, &VerificationError{Reason: ErrInvalidEncryptionProof,
+					Err: errors.New("verifiable encryptions do not match their structures")}
+			}
+			for i, s := range structures {
+				p.VerifiableEncryptions[index][i].MResponse = new(big.Int).Set(p.AResponses[index])
+				if !s.VerifyProofStructure(pk, p.VerifiableEncryptions[index][i]) {
+					return nil, &VerificationError{Reason: ErrInvalidEncryptionProof,
+						Err: errors.New("malformed verifiable encryption")}
+				}
+				l = append(l, s.CommitmentsFromProof(pk, p.VerifiableEncryptions[index][i], p.C)...)
+			}
+		}
+	}
+
 	return l, nil
 }
 
@@ -369,6 +662,32 @@ type ProofP struct {
 	SResponse *big.Int `json:"s_response"`
 }
 
+// Verify checks that the ProofP is a correct response of the keyshare server to the specified
+// challenge for the specified public key, given its earlier commitment, so that clients can
+// detect a faulty keyshare server before merging the ProofP into their proofs. For threshold
+// keyshare servers, this can be applied to the ProofPCommitment and ProofP of each participant
+// as well as to the combined ones.
+func (p *ProofP) Verify(pk *gabikeys.PublicKey, commitment *ProofPCommitment, challenge *big.Int) error {
+	if p.P == nil || p.C == nil || p.SResponse == nil || commitment == nil || commitment.P == nil || commitment.Pcommit == nil {
+		return ErrInvalidProofP
+	}
+	if p.C.Cmp(challenge) != 0 || p.P.Cmp(commitment.P) != 0 {
+		return ErrInvalidProofP
+	}
+	if p.SResponse.Sign() < 0 || uint(p.SResponse.BitLen()) > refreshedKeyshareRandomizerLength()+1 {
+		return ErrInvalidProofP
+	}
+
+	// R_0^{SResponse} = Pcommit * P^C
+	lhs := new(big.Int).Exp(pk.R[0], p.SResponse, pk.N)
+	rhs := new(big.Int).Exp(p.P, p.C, pk.N)
+	rhs.Mul(rhs, commitment.Pcommit).Mod(rhs, pk.N)
+	if lhs.Cmp(rhs) != 0 {
+		return ErrInvalidProofP
+	}
+	return nil
+}
+
 // ProofPCommitment is a keyshare server's first message in its proof of knowledge
 // of its part of the secret key.
 type ProofPCommitment struct {
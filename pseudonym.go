@@ -0,0 +1,180 @@
+package gabi
+
+import (
+	"github.com/go-errors/errors"
+	"github.com/privacybydesign/gabi/big"
+	"github.com/privacybydesign/gabi/gabikeys"
+	"github.com/privacybydesign/gabi/internal/common"
+)
+
+// Scope-exclusive pseudonyms are computed as nym = H(scope)^secretkey, where H(scope) is a
+// quadratic residue modulo the 2048-bit MODP safe prime p from RFC 3526. The quadratic residues
+// form a group of prime order (p-1)/2 in which the discrete logarithm problem is hard, so
+// pseudonyms in different scopes cannot be linked to each other without knowledge of the
+// secret key. Proofs of correctness of the pseudonym are Schnorr proofs using the same
+// (integer) secret key response as the other proofs in a ProofList, which binds the pseudonym
+// to the secret key of the credentials in it.
+
+var (
+	pseudonymModulus, _ = new(big.Int).SetString("FFFFFFFFFFFFFFFFC90FDAA22168C234C4C6628B80DC1CD129024E088A67CC74020BBEA63B139B22514A08798E3404DDEF9519B3CD3A431B302B0A6DF25F14374FE1356D6D51C245E485B576625E7EC6F44C42E9A637ED6B0BFF5CB6F406B7EDEE386BFB5A899FA5AE9F24117C4B1FE649286651ECE45B3DC2007CB8A163BF0598DA48361C55D39A69163FA8FD24CF5F83655D23DCA3AD961C62F356208552BB9ED529077096966D670C354E4ABC9804F1746C08CA18217C32905E462E36CE3BE39E772C180E86039B2783A2EC07A28FB5C55DF06F4C52C9DE2BCBF6955817183995497CEA956AE515D2261898FA051015728E5A8AACAA68FFFFFFFFFFFFFFFF", 16)
+	pseudonymOrder      = new(big.Int).Rsh(pseudonymModulus, 1)
+
+	// ErrInvalidPseudonym is returned when a pseudonym is not an element of the pseudonym group.
+	ErrInvalidPseudonym = errors.New("pseudonym is not an element of the pseudonym group")
+)
+
+// PseudonymBase returns the base of the pseudonyms in the specified scope, i.e., H(scope).
+func PseudonymBase(scope string) *big.Int {
+	// Hash to a number that is large enough to be statistically close to uniform modulo p,
+	// and square it to get an element of the group of quadratic residues.
+	h := common.GetHashNumber(common.IntHashSha256([]byte(scope)), nil, 0,
+		uint(pseudonymModulus.BitLen())+gabikeys.DefaultSystemParameters[2048].Lstatzk)
+	h.Mod(h, pseudonymModulus)
+	return h.Exp(h, big.NewInt(2), pseudonymModulus)
+}
+
+// Pseudonym computes the pseudonym of the specified secret key in the specified scope.
+func Pseudonym(scope string, secret *big.Int) *big.Int {
+	return new(big.Int).Exp(PseudonymBase(scope), secret, pseudonymModulus)
+}
+
+// PseudonymProofBuilder is an object that holds the state for the protocol to
+// produce a proof of correctness of a scope-exclusive pseudonym. It implements
+// the ProofBuilder interface.
+type PseudonymProofBuilder struct {
+	scope      string
+	base       *big.Int
+	nym        *big.Int
+	secret     *big.Int
+	randomizer *big.Int
+	commit     *big.Int
+}
+
+// ProofNym is a proof of correctness of a scope-exclusive pseudonym, i.e., that
+// Nym = H(Scope)^secretkey. Verifiers must check that Scope is the scope they expect.
+type ProofNym struct {
+	Scope     string   `json:"scope"`
+	Nym       *big.Int `json:"nym"`
+	C         *big.Int `json:"c"`
+	SResponse *big.Int `json:"s_response"`
+}
+
+// NewPseudonymProofBuilder creates a new builder for the pseudonym of the
+// specified secret key in the specified scope.
+func NewPseudonymProofBuilder(scope string, secret *big.Int) *PseudonymProofBuilder {
+	base := PseudonymBase(scope)
+	return &PseudonymProofBuilder{
+		scope:  scope,
+		base:   base,
+		nym:    new(big.Int).Exp(base, secret, pseudonymModulus),
+		secret: secret,
+		commit: big.NewInt(1),
+	}
+}
+
+// Pseudonym returns the pseudonym that the builder proves correctness of.
+func (b *PseudonymProofBuilder) Pseudonym() *big.Int {
+	return b.nym
+}
+
+// MergeProofPCommitment merges the commitment of a keyshare server into the
+// builder. Its P and Pcommit must have been computed over the pseudonym base,
+// see NewKeysharePseudonymCommitment.
+func (b *PseudonymProofBuilder) MergeProofPCommitment(commitment *ProofPCommitment) {
+	b.nym.Mod(b.nym.Mul(b.nym, commitment.P), pseudonymModulus)
+	b.commit.Mod(b.commit.Mul(b.commit, commitment.Pcommit), pseudonymModulus)
+}
+
+// PublicKey returns nil, as pseudonyms do not involve an issuer public key.
+func (b *PseudonymProofBuilder) PublicKey() *gabikeys.PublicKey {
+	return nil
+}
+
+func (b *PseudonymProofBuilder) secretKey() *big.Int {
+	return b.secret
+}
+
+// Commit commits to the secret key using the provided randomizer.
+func (b *PseudonymProofBuilder) Commit(randomizers map[string]*big.Int) ([]*big.Int, error) {
+	b.randomizer = randomizers["secretkey"]
+	if b.randomizer == nil {
+		return nil, errors.New("missing secret key randomizer")
+	}
+	b.commit.Mul(b.commit, new(big.Int).Exp(b.base, b.randomizer, pseudonymModulus))
+	b.commit.Mod(b.commit, pseudonymModulus)
+	return []*big.Int{b.base, b.nym, b.commit}, nil
+}
+
+// CreateProof creates a (ProofNym) Proof using the provided challenge.
+func (b *PseudonymProofBuilder) CreateProof(challenge *big.Int) Proof {
+	return &ProofNym{
+		Scope:     b.scope,
+		Nym:       new(big.Int).Set(b.nym),
+		C:         challenge,
+		SResponse: new(big.Int).Add(b.randomizer, new(big.Int).Mul(challenge, b.secret)),
+	}
+}
+
+// MergeProofP merges the response of a keyshare server into the proof.
+func (p *ProofNym) MergeProofP(proofP *ProofP, _ *gabikeys.PublicKey) {
+	p.SResponse.Add(p.SResponse, proofP.SResponse)
+}
+
+// Verify verifies the proof against the given scope, context, and nonce.
+func (p *ProofNym) Verify(scope string, context, nonce *big.Int, issig bool) bool {
+	if p.Scope != scope {
+		return false
+	}
+	contrib, err := p.ChallengeContribution(nil)
+	if err != nil {
+		return false
+	}
+	return p.VerifyWithChallenge(nil, createChallenge(context, nonce, contrib, issig))
+}
+
+// VerifyWithChallenge verifies whether the proof is correct. The public key
+// is ignored and may be nil.
+func (p *ProofNym) VerifyWithChallenge(_ *gabikeys.PublicKey, reconstructedChallenge *big.Int) bool {
+	return p.C != nil && p.C.Cmp(reconstructedChallenge) == 0
+}
+
+// SecretKeyResponse returns the secret key response (as part of Proof
+// interface).
+func (p *ProofNym) SecretKeyResponse() *big.Int {
+	return p.SResponse
+}
+
+// Challenge returns the challenge in the proof.
+func (p *ProofNym) Challenge() *big.Int {
+	return p.C
+}
+
+// ChallengeContribution returns the contribution of this proof to the
+// challenge. The public key is ignored and may be nil.
+func (p *ProofNym) ChallengeContribution(_ *gabikeys.PublicKey) ([]*big.Int, error) {
+	if p.Nym == nil || p.C == nil || p.SResponse == nil {
+		return nil, errors.New("incomplete pseudonym proof")
+	}
+	if uint(p.SResponse.BitLen()) > secretKeyResponseLength() {
+		return nil, errors.New("pseudonym proof response too large")
+	}
+	// Check that Nym is a quadratic residue, i.e. an element of the group of prime order
+	if p.Nym.Sign() <= 0 || p.Nym.Cmp(pseudonymModulus) >= 0 ||
+		new(big.Int).Exp(p.Nym, pseudonymOrder, pseudonymModulus).Cmp(big.NewInt(1)) != 0 {
+		return nil, ErrInvalidPseudonym
+	}
+
+	// commit = Nym^{-C} * base^{SResponse}
+	base := PseudonymBase(p.Scope)
+	commit, err := common.ModPow(p.Nym, new(big.Int).Neg(p.C), pseudonymModulus)
+	if err != nil {
+		return nil, err
+	}
+	bs, err := common.ModPow(base, p.SResponse, pseudonymModulus)
+	if err != nil {
+		return nil, err
+	}
+	commit.Mul(commit, bs).Mod(commit, pseudonymModulus)
+
+	return []*big.Int{base, p.Nym, commit}, nil
+}
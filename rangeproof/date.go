@@ -0,0 +1,172 @@
+package rangeproof
+
+import (
+	"time"
+
+	"github.com/privacybydesign/gabi/big"
+
+	"github.com/go-errors/errors"
+)
+
+// The helpers below build range proof statements on attributes containing dates, such as "older
+// than 18 years" on a birth date or "not expired" on an expiry date, and check them on the
+// verifier side. As range proofs only support >= and <=, strict comparisons are adjusted to the
+// adjacent encoded value, e.g. m > k becomes m >= k+1. Comparisons happen at the granularity of
+// the encoding: e.g. with an encoding in days, all times within a day are equal.
+
+type (
+	// DateEncoding specifies how dates are encoded in attributes. Encodings must be
+	// nondecreasing in time.
+	DateEncoding interface {
+		// EncodeDate returns the attribute value of the specified time.
+		EncodeDate(t time.Time) (*big.Int, error)
+	}
+
+	// ElapsedDateEncoding encodes dates as the number of Units elapsed since the Epoch, rounded
+	// down. Unit defaults to a second when zero; e.g. a Unit of 24*time.Hour encodes dates as
+	// days since the Epoch. Epoch defaults to the zero time.Time, i.e. January 1 of year 1 UTC,
+	// so that all historical dates, such as birth dates, can be encoded; use time.Unix(0, 0) for
+	// Unix time. Dates before the Epoch cannot be encoded.
+	//
+	// The elapsed time does not depend on the location of the encoded time: with the default
+	// Epoch and a Unit of a day, 01:00 at UTC+2 falls on the previous day. Callers should
+	// therefore encode dates as midnight UTC, and pass reference times, such as now, in UTC.
+	ElapsedDateEncoding struct {
+		Unit  time.Duration
+		Epoch time.Time
+	}
+
+	// DecimalDateEncoding encodes dates as the decimal number YYYYMMDD, using the date of the
+	// time in its own location.
+	DecimalDateEncoding struct{}
+
+	// DateComparison is the comparison of a date attribute to a reference time.
+	DateComparison int
+)
+
+const (
+	// Before states that the attribute is before the reference time.
+	Before DateComparison = iota
+	// BeforeOrAt states that the attribute is before or at the reference time.
+	BeforeOrAt
+	// After states that the attribute is after the reference time.
+	After
+	// AfterOrAt states that the attribute is after or at the reference time.
+	AfterOrAt
+)
+
+// ErrDateOutOfRange is returned when a date cannot be represented in a date encoding.
+var ErrDateOutOfRange = errors.New("date cannot be encoded")
+
+func (e ElapsedDateEncoding) EncodeDate(t time.Time) (*big.Int, error) {
+	unit := int64(e.Unit / time.Second)
+	if e.Unit == 0 {
+		unit = 1
+	}
+	if unit <= 0 || e.Unit%time.Second != 0 {
+		return nil, errors.New("unit must be a positive multiple of a second")
+	}
+	// Compute in seconds, as time.Duration cannot hold more than about 292 years
+	elapsed := t.Unix() - e.Epoch.Unix()
+	if elapsed < 0 {
+		return nil, ErrDateOutOfRange
+	}
+	return big.NewInt(elapsed / unit), nil
+}
+
+func (DecimalDateEncoding) EncodeDate(t time.Time) (*big.Int, error) {
+	if t.Year() < 0 || t.Year() > 9999 {
+		return nil, ErrDateOutOfRange
+	}
+	return big.NewInt(int64(t.Year())*10000 + int64(t.Month())*100 + int64(t.Day())), nil
+}
+
+// NewDateStatement returns a statement that a date attribute, encoded using the specified
+// encoding, compares to the reference time as specified.
+func NewDateStatement(encoding DateEncoding, comparison DateComparison, reference time.Time) (*Statement, error) {
+	bound, err := encoding.EncodeDate(reference)
+	if err != nil {
+		return nil, err
+	}
+	switch comparison {
+	case Before:
+		// m < k is equivalent to m <= k-1
+		if bound.Sign() == 0 {
+			return nil, ErrDateOutOfRange
+		}
+		return NewStatement(LesserOrEqual, bound.Sub(bound, big.NewInt(1)))
+	case BeforeOrAt:
+		return NewStatement(LesserOrEqual, bound)
+	case After:
+		// m > k is equivalent to m >= k+1
+		return NewStatement(GreaterOrEqual, bound.Add(bound, big.NewInt(1)))
+	case AfterOrAt:
+		return NewStatement(GreaterOrEqual, bound)
+	default:
+		return nil, errors.New("unsupported date comparison")
+	}
+}
+
+// NewMinimumAgeStatement returns a statement that a birth date attribute, encoded using the
+// specified encoding, is at least the specified amount of years before now.
+func NewMinimumAgeStatement(encoding DateEncoding, years int, now time.Time) (*Statement, error) {
+	return NewDateStatement(encoding, BeforeOrAt, minimumAgeReference(years, now))
+}
+
+// minimumAgeReference returns the last birth date of those having at least the specified age now.
+// Unlike now.AddDate(-years, 0, 0), this maps February 29 to February 28 (instead of March 1) if
+// the resulting year is not a leap year.
+func minimumAgeReference(years int, now time.Time) time.Time {
+	year, month, day := now.Date()
+	year -= years
+	if month == time.February && day == 29 && time.Date(year, time.March, 0, 0, 0, 0, 0, time.UTC).Day() != 29 {
+		day = 28
+	}
+	return time.Date(year, month, day, now.Hour(), now.Minute(), now.Second(), now.Nanosecond(), now.Location())
+}
+
+// NewNotExpiredStatement returns a statement that an expiry date attribute, encoded using the
+// specified encoding, is after now.
+func NewNotExpiredStatement(encoding DateEncoding, now time.Time) (*Statement, error) {
+	return NewDateStatement(encoding, After, now)
+}
+
+// ProvesDate returns whether the Proof proves that the date attribute, encoded using the specified
+// encoding, compares to the reference time as specified. Proofs of stronger statements are
+// accepted, e.g. of a minimum age computed by a prover whose clock is slightly behind.
+//
+// NB: this method does not verify the proof.
+func (p *Proof) ProvesDate(encoding DateEncoding, comparison DateComparison, reference time.Time) bool {
+	statement, err := NewDateStatement(encoding, comparison, reference)
+	if err != nil || p.K == nil {
+		return false
+	}
+	typ, factor, bound := p.ProvenStatement()
+	if p.Sign != statement.Sign || factor != statement.Factor {
+		return false
+	}
+	switch typ {
+	case GreaterOrEqual:
+		return bound.Cmp(statement.Bound) >= 0
+	case LesserOrEqual:
+		return bound.Cmp(statement.Bound) <= 0
+	default:
+		return false
+	}
+}
+
+// ProvesMinimumAge returns whether the Proof proves that the birth date attribute, encoded using
+// the specified encoding, is at least the specified amount of years before now.
+//
+// NB: this method does not verify the proof.
+func (p *Proof) ProvesMinimumAge(encoding DateEncoding, years int, now time.Time) bool {
+	return p.ProvesDate(encoding, BeforeOrAt, minimumAgeReference(years, now))
+}
+
+// ProvesNotExpired returns whether the Proof proves that the expiry date attribute, encoded using
+// the specified encoding, is after now.
+//
+// NB: this method does not verify the proof.
+func (p *Proof) ProvesNotExpired(encoding DateEncoding, now time.Time) bool {
+	return p.ProvesDate(encoding, After, now)
+}
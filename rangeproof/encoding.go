@@ -0,0 +1,148 @@
+package rangeproof
+
+import (
+	"encoding/json"
+
+	"github.com/fxamacker/cbor"
+	"github.com/privacybydesign/gabi/big"
+
+	"github.com/go-errors/errors"
+)
+
+// Statements are encoded to JSON and CBOR including their splitter, so that verifiers can send
+// them to provers as data. Only the kind of the splitter and its parameters are encoded, i.e. the
+// amount of squares and the bitsize Ld of the squares, which together with the other fields of the
+// statement determine the structure of the resulting proof. Supported are the FourSquaresSplitter
+// and the ThreeSquaresSplitter, whose Ld is omitted if it is derived from the attribute size.
+// Other splitters, such as a SquaresTable, cannot be encoded, as they would not decode to the
+// same splitter.
+
+type (
+	encodedStatement struct {
+		Sign     int              `json:"sign"`
+		Factor   uint             `json:"factor"`
+		Bound    *big.Int         `json:"bound"`
+		Splitter *encodedSplitter `json:"splitter,omitempty"`
+	}
+
+	encodedSplitter struct {
+		Kind string `json:"kind"`
+		Ld   uint   `json:"ld,omitempty"`
+	}
+)
+
+const (
+	splitterKindThreeSquares = "threesquares"
+	splitterKindFourSquares  = "foursquares"
+)
+
+// ErrUnsupportedSplitter is returned when encoding or decoding a statement whose splitter cannot
+// be represented.
+var ErrUnsupportedSplitter = errors.New("unsupported square splitter")
+
+// check checks the fields of a statement that is to be encoded or that was decoded.
+func (e *encodedStatement) check() error {
+	if e.Sign != 1 && e.Sign != -1 {
+		return ErrUnsupportedSign
+	}
+	if e.Factor == 0 {
+		return errors.New("factor must be positive")
+	}
+	if e.Bound == nil || e.Bound.Sign() < 0 {
+		return errors.New("bound must be nonnegative")
+	}
+	if e.Splitter != nil && e.Splitter.Kind != splitterKindFourSquares && e.Splitter.Kind != splitterKindThreeSquares {
+		return ErrUnsupportedSplitter
+	}
+	return nil
+}
+
+func (statement *Statement) encode() (*encodedStatement, error) {
+	e := &encodedStatement{
+		Sign:   statement.Sign,
+		Factor: statement.Factor,
+		Bound:  statement.Bound,
+	}
+	switch s := statement.Splitter.(type) {
+	case nil:
+	case *FourSquaresSplitter:
+		e.Splitter = &encodedSplitter{Kind: splitterKindFourSquares}
+	case *ThreeSquaresSplitter:
+		e.Splitter = &encodedSplitter{Kind: splitterKindThreeSquares, Ld: s.Ld()}
+	default:
+		return nil, ErrUnsupportedSplitter
+	}
+	if err := e.check(); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+func (statement *Statement) decode(e *encodedStatement) error {
+	if err := e.check(); err != nil {
+		return err
+	}
+	*statement = Statement{Sign: e.Sign, Factor: e.Factor, Bound: e.Bound}
+	if e.Splitter == nil {
+		return nil
+	}
+	switch e.Splitter.Kind {
+	case splitterKindFourSquares:
+		statement.Splitter = &FourSquaresSplitter{}
+	case splitterKindThreeSquares:
+		statement.Splitter = &ThreeSquaresSplitter{Bits: e.Splitter.Ld}
+	default:
+		return ErrUnsupportedSplitter
+	}
+	return nil
+}
+
+func (statement *Statement) MarshalJSON() ([]byte, error) {
+	e, err := statement.encode()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(e)
+}
+
+func (statement *Statement) UnmarshalJSON(bts []byte) error {
+	var e encodedStatement
+	if err := json.Unmarshal(bts, &e); err != nil {
+		return err
+	}
+	return statement.decode(&e)
+}
+
+func (statement *Statement) MarshalCBOR() ([]byte, error) {
+	e, err := statement.encode()
+	if err != nil {
+		return nil, err
+	}
+	return cbor.Marshal(e, cbor.EncOptions{})
+}
+
+func (statement *Statement) UnmarshalCBOR(bts []byte) error {
+	var e encodedStatement
+	if err := cbor.Unmarshal(bts, &e); err != nil {
+		return err
+	}
+	return statement.decode(&e)
+}
+
+// ProvesExactly returns whether the Proof has exactly the structure that a proof of the specified
+// statement has, i.e. whether its Ld, Sign, A, K and amount of squares are those that
+// NewProofStructure derives from the statement. Ld is only compared if the splitter of the
+// statement specifies it. Unlike Proves, this does not accept proofs of
+// statements that merely imply the specified statement.
+//
+// NB: this method does not verify the proof.
+func (p *Proof) ProvesExactly(statement *Statement) bool {
+	if p.K == nil || statement.Bound == nil {
+		return false
+	}
+	s, err := statement.ProofStructure(0)
+	if err != nil {
+		return false
+	}
+	return (s.ld == 0 || p.Ld == s.ld) && p.Sign == s.sign && p.A == s.a && p.K.Cmp(s.k) == 0 && len(p.Cs) == len(s.cRep)
+}
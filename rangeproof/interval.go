@@ -0,0 +1,119 @@
+package rangeproof
+
+import (
+	"github.com/privacybydesign/gabi/big"
+	"github.com/privacybydesign/gabi/gabikeys"
+
+	"github.com/go-errors/errors"
+)
+
+// Interval proofs prove that an attribute m lies in a closed interval [lo, hi]. For lo <= hi, this
+// is equivalent to (m - lo) * (hi - m) >= 0, as the product is negative if m lies outside the
+// interval. This is proven using the quadratic proof structure (see quadratic.go) with k1 = lo,
+// sign -1, k2 = hi and t = 0, so that both bounds share the same commitments and square
+// decomposition, instead of requiring two separate range proofs.
+
+type (
+	// IntervalStatement states that an attribute m satisfies Lower <= m <= Upper.
+	IntervalStatement struct {
+		Lower *big.Int
+		Upper *big.Int
+	}
+
+	IntervalProofStructure struct {
+		quadratic    *quadraticStructure
+		lower, upper *big.Int
+	}
+
+	IntervalProof struct {
+		quadraticProof
+
+		// Proof structure description
+		Lower *big.Int `json:"lo"`
+		Upper *big.Int `json:"hi"`
+	}
+
+	IntervalProofCommit quadraticCommit
+)
+
+// NewIntervalStatement returns a statement that an attribute lies between lower and upper,
+// inclusive.
+func NewIntervalStatement(lower, upper *big.Int) (*IntervalStatement, error) {
+	if err := checkInterval(lower, upper); err != nil {
+		return nil, err
+	}
+	return &IntervalStatement{Lower: new(big.Int).Set(lower), Upper: new(big.Int).Set(upper)}, nil
+}
+
+func (statement *IntervalStatement) ProofStructure(index int) (*IntervalProofStructure, error) {
+	return NewIntervalProofStructure(index, statement.Lower, statement.Upper)
+}
+
+// Create a new proof structure for proving that the attribute at the given index lies between
+// lower and upper, inclusive.
+func NewIntervalProofStructure(index int, lower, upper *big.Int) (*IntervalProofStructure, error) {
+	if err := checkInterval(lower, upper); err != nil {
+		return nil, err
+	}
+	quadratic, err := newQuadraticStructure(index, lower, -1, upper, big.NewInt(0))
+	if err != nil {
+		return nil, err
+	}
+	return &IntervalProofStructure{
+		quadratic: quadratic,
+		lower:     new(big.Int).Set(lower),
+		upper:     new(big.Int).Set(upper),
+	}, nil
+}
+
+func checkInterval(lower, upper *big.Int) error {
+	if lower == nil || upper == nil || lower.Sign() < 0 {
+		return errors.New("bounds must be nonnegative")
+	}
+	if lower.Cmp(upper) > 0 {
+		return errors.New("lower bound exceeds upper bound")
+	}
+	return nil
+}
+
+func (s *IntervalProofStructure) CommitmentsFromSecrets(g *gabikeys.PublicKey, m, mRandomizer *big.Int) ([]*big.Int, *IntervalProofCommit, error) {
+	contributions, commit, err := s.quadratic.commitmentsFromSecrets(g, m, mRandomizer)
+	if err != nil {
+		return nil, nil, err
+	}
+	return contributions, (*IntervalProofCommit)(commit), nil
+}
+
+func (s *IntervalProofStructure) BuildProof(commit *IntervalProofCommit, challenge *big.Int) *IntervalProof {
+	return &IntervalProof{
+		quadraticProof: s.quadratic.buildProof((*quadraticCommit)(commit), challenge),
+		Lower:          new(big.Int).Set(s.lower),
+		Upper:          new(big.Int).Set(s.upper),
+	}
+}
+
+func (s *IntervalProofStructure) VerifyProofStructure(g *gabikeys.PublicKey, p *IntervalProof) bool {
+	return s.quadratic.verifyProofStructure(g, &p.quadraticProof)
+}
+
+func (s *IntervalProofStructure) CommitmentsFromProof(g *gabikeys.PublicKey, p *IntervalProof, challenge *big.Int) []*big.Int {
+	return s.quadratic.commitmentsFromProof(g, &p.quadraticProof, challenge)
+}
+
+// Proves returns whether the IntervalProof proves the specified statement.
+//
+// NB: this method does not verify the proof.
+func (p *IntervalProof) Proves(statement *IntervalStatement) bool {
+	return p.Lower != nil && p.Upper != nil && statement.Lower != nil && statement.Upper != nil &&
+		p.Lower.Cmp(statement.Lower) == 0 && p.Upper.Cmp(statement.Upper) == 0
+}
+
+// Extract proof structure from proof
+func (p *IntervalProof) ExtractStructure(index int, g *gabikeys.PublicKey) (*IntervalProofStructure, error) {
+	// Bounds larger than lm are never reasonable since attributes (or their hashes) are at most lm
+	// bits
+	if p.Lower == nil || p.Upper == nil || uint(p.Upper.BitLen()) > g.Params.Lm {
+		return nil, errors.New("invalid proof")
+	}
+	return NewIntervalProofStructure(index, p.Lower, p.Upper)
+}
@@ -0,0 +1,268 @@
+package rangeproof
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/privacybydesign/gabi/big"
+	"github.com/privacybydesign/gabi/gabikeys"
+	"github.com/privacybydesign/gabi/zkproof"
+
+	"github.com/go-errors/errors"
+)
+
+// Linear proofs generalize range proofs to linear combinations of several attributes m_i: given
+// nonzero factors a_i and a bound k, they prove that \sum_i a_i*m_i - k >= 0. This allows relating
+// attributes to each other, e.g. m_1 - m_2 - k >= 0 proves m_1 >= m_2 + k. Writing the left hand
+// side as a sum of four squares, it proves the following substatements:
+//
+//    C_i = R^(d_i) S^(v_i)
+//    R^(-k) = S^(-v5) \product_i R^(-a_i*m_i) \product_i C_i^(d_i)
+//
+// where R is the base of one of the attributes, and d_i, v_i and v5 are as in the range proofs (see
+// proof.go), whose soundness proof applies with m replaced by \sum_i a_i*m_i. The attributes m_i
+// are proven to be those of a credential by the caller, by using the same randomizers for them in
+// the linear proof and the disclosure proof(s) of the credential(s), so that their responses are
+// equal.
+
+type (
+	// LinearStatement states that attributes m_i satisfy \sum_i Factors[i]*m_i - Bound >= 0.
+	LinearStatement struct {
+		Factors []int64
+		Bound   *big.Int
+	}
+
+	LinearProofStructure struct {
+		squaresStructure
+
+		index   int
+		factors []int64
+		k       *big.Int
+	}
+
+	LinearProof struct {
+		// Actual proof responses
+		Cs         []*big.Int `json:"Cs"`
+		DResponses []*big.Int `json:"ds"`
+		VResponses []*big.Int `json:"vs"`
+		V5Response *big.Int   `json:"v5"`
+		MResponses []*big.Int `json:"-"`
+
+		// Proof structure description
+		Factors []int64  `json:"factors"`
+		K       *big.Int `json:"k"`
+	}
+
+	LinearProofCommit struct {
+		squaresCommit
+
+		// Secrets
+		m            []*big.Int
+		mRandomizers []*big.Int
+	}
+
+	linearProof       LinearProof
+	linearProofCommit LinearProofCommit
+)
+
+const linearSquareCount = 4
+
+// MaxLinearTerms is the maximum amount of terms of a linear statement. The work of the verifier and
+// the size of the proof grow linearly with the amount of terms, and the statement of a proof is
+// chosen by the prover, so it must be bounded before the proof is verified.
+const MaxLinearTerms = 32
+
+var ErrTooManyTerms = errors.New("linear statement contains too many terms")
+
+// NewLinearStatement returns a statement that \sum_i factors[i]*m_i - bound >= 0. The factors must be
+// nonzero and the bound must be nonnegative.
+func NewLinearStatement(factors []int64, bound *big.Int) (*LinearStatement, error) {
+	if err := checkLinear(factors, bound); err != nil {
+		return nil, err
+	}
+	return &LinearStatement{Factors: append([]int64{}, factors...), Bound: new(big.Int).Set(bound)}, nil
+}
+
+func (statement *LinearStatement) ProofStructure(index int) (*LinearProofStructure, error) {
+	return NewLinearProofStructure(index, statement.Factors, statement.Bound)
+}
+
+func checkLinear(factors []int64, bound *big.Int) error {
+	if len(factors) == 0 {
+		return errors.New("no factors specified")
+	}
+	if len(factors) > MaxLinearTerms {
+		return ErrTooManyTerms
+	}
+	for _, a := range factors {
+		if a == 0 || a == math.MinInt64 {
+			return errors.New("invalid factor")
+		}
+	}
+	if bound == nil || bound.Sign() < 0 {
+		return errors.New("bound must be nonnegative")
+	}
+	return nil
+}
+
+// Create a new proof structure for proving a statement of the form \sum_i factors[i]*m_i - bound >= 0.
+//
+// index specifies the index of the attribute whose base is used for the commitments.
+func NewLinearProofStructure(index int, factors []int64, bound *big.Int) (*LinearProofStructure, error) {
+	if err := checkLinear(factors, bound); err != nil {
+		return nil, err
+	}
+
+	r := fmt.Sprintf("R%d", index)
+	terms := make([]zkproof.RhsContribution, len(factors))
+	for i, a := range factors {
+		terms[i] = zkproof.RhsContribution{Base: r, Secret: fmt.Sprintf("m%d", i), Power: -a}
+	}
+
+	return &LinearProofStructure{
+		squaresStructure: newSquaresStructure(index, new(big.Int).Neg(bound), terms, linearSquareCount),
+
+		index:   index,
+		factors: append([]int64{}, factors...),
+		k:       new(big.Int).Set(bound),
+	}, nil
+}
+
+// Bitsize of the d_i. As long as the factors are much smaller than 2^lm, the linear combination
+// is smaller than 2^(2*lm), so that the d_i are smaller than 2^lm.
+func (s *LinearProofStructure) ld(g *gabikeys.PublicKey) uint {
+	return g.Params.Lm
+}
+
+// CommitmentsFromSecrets computes the commitments of the proof, given the attributes m_i and their
+// randomizers, in the same order as the factors of the statement.
+func (s *LinearProofStructure) CommitmentsFromSecrets(g *gabikeys.PublicKey, m, mRandomizers []*big.Int) ([]*big.Int, *LinearProofCommit, error) {
+	var err error
+
+	if len(m) != len(s.factors) || len(mRandomizers) != len(s.factors) {
+		return nil, nil, errors.New("wrong number of attributes")
+	}
+
+	delta := new(big.Int).Neg(s.k)
+	for i, a := range s.factors {
+		delta.Add(delta, new(big.Int).Mul(big.NewInt(a), m[i]))
+	}
+	if delta.Sign() < 0 {
+		return nil, nil, ErrFalseStatement
+	}
+
+	commit := &linearProofCommit{
+		m:            m,
+		mRandomizers: mRandomizers,
+	}
+
+	squares, err := (&FourSquaresSplitter{}).Split(delta)
+	if err != nil {
+		return nil, nil, err
+	}
+	commit.squaresCommit, err = newSquaresCommit(g, s.index, squares, s.ld(g))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return s.commitmentsFromSecrets(g, commit), (*LinearProofCommit)(commit), nil
+}
+
+func (s *LinearProofStructure) BuildProof(commit *LinearProofCommit, challenge *big.Int) *LinearProof {
+	result := &LinearProof{
+		MResponses: make([]*big.Int, len(commit.m)),
+
+		Factors: append([]int64{}, s.factors...),
+		K:       new(big.Int).Set(s.k),
+	}
+	result.Cs, result.DResponses, result.VResponses, result.V5Response = commit.responses(challenge)
+	for i := range commit.m {
+		result.MResponses[i] = new(big.Int).Add(new(big.Int).Mul(challenge, commit.m[i]), commit.mRandomizers[i])
+	}
+
+	return result
+}
+
+func (s *LinearProofStructure) VerifyProofStructure(g *gabikeys.PublicKey, p *LinearProof) bool {
+	if !s.verifyResponses(g, s.ld(g), p.Cs, p.DResponses, p.VResponses, p.V5Response) ||
+		len(s.factors) != len(p.MResponses) {
+		return false
+	}
+
+	for _, m := range p.MResponses {
+		if m == nil || uint(m.BitLen()) > g.Params.Lm+g.Params.Lh+g.Params.Lstatzk+1 {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (s *LinearProofStructure) CommitmentsFromProof(g *gabikeys.PublicKey, p *LinearProof, challenge *big.Int) []*big.Int {
+	return s.commitmentsFromProof(g, (*linearProof)(p), challenge)
+}
+
+// Proves returns whether the LinearProof proves the specified statement.
+//
+// NB: this method does not verify the proof.
+func (p *LinearProof) Proves(statement *LinearStatement) bool {
+	if p.K == nil || statement.Bound == nil || p.K.Cmp(statement.Bound) != 0 ||
+		len(p.Factors) != len(statement.Factors) {
+		return false
+	}
+	for i := range p.Factors {
+		if p.Factors[i] != statement.Factors[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Extract proof structure from proof
+func (p *LinearProof) ExtractStructure(index int, g *gabikeys.PublicKey) (*LinearProofStructure, error) {
+	// As for range proofs, p.K >= 2^lm+sizeof(a) is never reasonable
+	if p.K == nil || p.K.BitLen() > int(g.Params.Lm+strconv.IntSize) {
+		return nil, errors.New("invalid proof")
+	}
+	return NewLinearProofStructure(index, p.Factors, p.K)
+}
+
+// ---
+// Commit structure keyproof interfaces
+// ---
+func (c *linearProofCommit) Secret(name string) *big.Int {
+	if i := indexedName(name, 'm'); i >= 0 && i < len(c.m) {
+		return c.m[i]
+	}
+	return c.secret(name)
+}
+
+func (c *linearProofCommit) Randomizer(name string) *big.Int {
+	if i := indexedName(name, 'm'); i >= 0 && i < len(c.mRandomizers) {
+		return c.mRandomizers[i]
+	}
+	return c.randomizer(name)
+}
+
+// ---
+// Proof structure keyproof interfaces
+// ---
+func (p *linearProof) ProofResult(name string) *big.Int {
+	if i := indexedName(name, 'm'); i >= 0 && i < len(p.MResponses) {
+		return p.MResponses[i]
+	}
+	return squaresValue(name, p.DResponses, p.VResponses, p.V5Response)
+}
+
+func (p *linearProof) Base(name string) *big.Int {
+	return squaresBase(name, p.Cs)
+}
+
+func (p *linearProof) Exp(ret *big.Int, name string, exp, n *big.Int) bool {
+	return squaresExp(ret, p.Base(name), exp, n)
+}
+
+func (p *linearProof) Names() []string {
+	return squaresNames(len(p.Cs))
+}
@@ -0,0 +1,98 @@
+package rangeproof
+
+import (
+	"github.com/privacybydesign/gabi/big"
+	"github.com/privacybydesign/gabi/gabikeys"
+
+	"github.com/go-errors/errors"
+)
+
+// Not-equal proofs prove that an attribute m differs from a public value k. As m and k are
+// integers, m != k is equivalent to (m - k)^2 - 1 >= 0, which is proven using the quadratic proof
+// structure (see quadratic.go) with k1 = k2 = k, sign 1 and t = 1.
+
+type (
+	// NotEqualStatement states that an attribute m is not equal to Value.
+	NotEqualStatement struct {
+		Value *big.Int
+	}
+
+	NotEqualProofStructure struct {
+		quadratic *quadraticStructure
+		value     *big.Int
+	}
+
+	NotEqualProof struct {
+		quadraticProof
+
+		// Proof structure description
+		Value *big.Int `json:"value"`
+	}
+
+	NotEqualProofCommit quadraticCommit
+)
+
+// NewNotEqualStatement returns a statement that an attribute is not equal to the given value.
+func NewNotEqualStatement(value *big.Int) (*NotEqualStatement, error) {
+	if value == nil || value.Sign() < 0 {
+		return nil, errors.New("value must be nonnegative")
+	}
+	return &NotEqualStatement{Value: new(big.Int).Set(value)}, nil
+}
+
+func (statement *NotEqualStatement) ProofStructure(index int) (*NotEqualProofStructure, error) {
+	return NewNotEqualProofStructure(index, statement.Value)
+}
+
+// Create a new proof structure for proving that the attribute at the given index is not equal to
+// the given value.
+func NewNotEqualProofStructure(index int, value *big.Int) (*NotEqualProofStructure, error) {
+	if value == nil {
+		return nil, errors.New("missing value")
+	}
+	quadratic, err := newQuadraticStructure(index, value, 1, value, big.NewInt(1))
+	if err != nil {
+		return nil, err
+	}
+	return &NotEqualProofStructure{quadratic: quadratic, value: new(big.Int).Set(value)}, nil
+}
+
+func (s *NotEqualProofStructure) CommitmentsFromSecrets(g *gabikeys.PublicKey, m, mRandomizer *big.Int) ([]*big.Int, *NotEqualProofCommit, error) {
+	contributions, commit, err := s.quadratic.commitmentsFromSecrets(g, m, mRandomizer)
+	if err != nil {
+		return nil, nil, err
+	}
+	return contributions, (*NotEqualProofCommit)(commit), nil
+}
+
+func (s *NotEqualProofStructure) BuildProof(commit *NotEqualProofCommit, challenge *big.Int) *NotEqualProof {
+	return &NotEqualProof{
+		quadraticProof: s.quadratic.buildProof((*quadraticCommit)(commit), challenge),
+		Value:          new(big.Int).Set(s.value),
+	}
+}
+
+func (s *NotEqualProofStructure) VerifyProofStructure(g *gabikeys.PublicKey, p *NotEqualProof) bool {
+	return s.quadratic.verifyProofStructure(g, &p.quadraticProof)
+}
+
+func (s *NotEqualProofStructure) CommitmentsFromProof(g *gabikeys.PublicKey, p *NotEqualProof, challenge *big.Int) []*big.Int {
+	return s.quadratic.commitmentsFromProof(g, &p.quadraticProof, challenge)
+}
+
+// Proves returns whether the NotEqualProof proves the specified statement.
+//
+// NB: this method does not verify the proof.
+func (p *NotEqualProof) Proves(statement *NotEqualStatement) bool {
+	return p.Value != nil && statement.Value != nil && p.Value.Cmp(statement.Value) == 0
+}
+
+// Extract proof structure from proof
+func (p *NotEqualProof) ExtractStructure(index int, g *gabikeys.PublicKey) (*NotEqualProofStructure, error) {
+	// Values larger than lm are never reasonable since attributes (or their hashes) are at most lm
+	// bits
+	if p.Value == nil || uint(p.Value.BitLen()) > g.Params.Lm {
+		return nil, errors.New("invalid proof")
+	}
+	return NewNotEqualProofStructure(index, p.Value)
+}
@@ -2,11 +2,12 @@ package rangeproof
 
 import (
 	"fmt"
+	"math"
+	"math/bits"
 	"strconv"
 
 	"github.com/privacybydesign/gabi/big"
 	"github.com/privacybydesign/gabi/gabikeys"
-	"github.com/privacybydesign/gabi/internal/common"
 	"github.com/privacybydesign/gabi/zkproof"
 
 	"github.com/go-errors/errors"
@@ -26,6 +27,8 @@ The following changes were made with respect to the Identity Mixer specification
 - There is no direct support for the > and < operators: the end user should do boundary adjustment
   for this themselves.
 - There is support for sum of 3 squares as well as sum of 4 squares, for space optimization.
+  When using 3 squares, a and k are replaced by 4*a and 4*k - 2, so that sign*(a*m - k) == 2
+  (mod 4), which can always be written as a sum of 3 squares.
 - There is no separate commitment to the difference between bound and attribute value.
 
 This results in that our code proves the following substatements:
@@ -120,8 +123,7 @@ type (
 	StatementType int
 
 	ProofStructure struct {
-		cRep     []zkproof.QrRepresentationProofStructure
-		mCorrect zkproof.QrRepresentationProofStructure
+		squaresStructure
 
 		index int
 		sign  int
@@ -148,18 +150,13 @@ type (
 	}
 
 	ProofCommit struct {
-		// Bases
-		c []*big.Int
+		squaresCommit
 
 		// Secrets
-		d            []*big.Int
-		dRandomizers []*big.Int
-		v            []*big.Int
-		vRandomizers []*big.Int
-		v5           *big.Int
-		v5Randomizer *big.Int
-		m            *big.Int
-		mRandomizer  *big.Int
+		m           *big.Int
+		mRandomizer *big.Int
+
+		ld uint
 	}
 
 	proof       Proof
@@ -187,15 +184,17 @@ func NewStatement(typ StatementType, bound *big.Int) (*Statement, error) {
 // Create a new proof structure for proving a statement of the form sign(factor*m - bound) >= 0.
 //
 // index specifies the index of the attribute.
-// splitter describes the method used for splitting numbers into sum of squares.
+// splitter describes the method used for splitting numbers into sum of squares. If its Ld() is 0,
+// the bitsize of the squares is not fixed by the splitter but derived from the attribute size of
+// the public key, the factor and the bound, once the structure is used with a public key.
 func NewProofStructure(index, sign int, factor uint, bound *big.Int, splitter SquareSplitter) (*ProofStructure, error) {
 	if splitter == nil {
 		splitter = &FourSquaresSplitter{}
 	}
 
 	if splitter.SquareCount() == 3 {
-		if factor != 1 {
-			return nil, errors.New("factor must be 1")
+		if factor == 0 || factor > math.MaxInt64/4 {
+			return nil, errors.New("invalid factor")
 		}
 		// Not all numbers can be written as sum of 3 squares, but n for which n == 2 (mod 4) can
 		// so ensure that factor*m-bound falls into that category
@@ -221,16 +220,10 @@ func newWithParams(index, sign int, a uint, k *big.Int, split SquareSplitter, nS
 	} else {
 		exp = new(big.Int).Set(k)
 	}
-	result := &ProofStructure{
-		mCorrect: zkproof.QrRepresentationProofStructure{
-			Lhs: []zkproof.LhsContribution{
-				{Base: fmt.Sprintf("R%d", index), Power: exp},
-			},
-			Rhs: []zkproof.RhsContribution{
-				{Base: "S", Secret: "v5", Power: -1},
-				{Base: fmt.Sprintf("R%d", index), Secret: "m", Power: -int64(a) * int64(sign)},
-			},
-		},
+	return &ProofStructure{
+		squaresStructure: newSquaresStructure(index, exp, []zkproof.RhsContribution{
+			{Base: fmt.Sprintf("R%d", index), Secret: "m", Power: -int64(a) * int64(sign)},
+		}, nSplit),
 
 		index: index,
 		sign:  sign,
@@ -239,27 +232,7 @@ func newWithParams(index, sign int, a uint, k *big.Int, split SquareSplitter, nS
 
 		splitter: split,
 		ld:       ld,
-	}
-
-	for i := 0; i < nSplit; i++ {
-		result.cRep = append(result.cRep, zkproof.QrRepresentationProofStructure{
-			Lhs: []zkproof.LhsContribution{
-				{Base: fmt.Sprintf("C%d", i), Power: big.NewInt(1)},
-			},
-			Rhs: []zkproof.RhsContribution{
-				{Base: fmt.Sprintf("R%d", index), Secret: fmt.Sprintf("d%d", i), Power: 1},
-				{Base: "S", Secret: fmt.Sprintf("v%d", i), Power: 1},
-			},
-		})
-
-		result.mCorrect.Rhs = append(result.mCorrect.Rhs, zkproof.RhsContribution{
-			Base:   fmt.Sprintf("C%d", i),
-			Secret: fmt.Sprintf("d%d", i),
-			Power:  1,
-		})
-	}
-
-	return result, nil
+	}, nil
 }
 
 func (statement *Statement) ProofStructure(index int) (*ProofStructure, error) {
@@ -293,138 +266,62 @@ func (s *ProofStructure) CommitmentsFromSecrets(g *gabikeys.PublicKey, m, mRando
 	commit := &proofCommit{
 		m:           m,
 		mRandomizer: mRandomizer,
+		ld:          s.squareBits(g),
 	}
 
-	commit.d, err = s.splitter.Split(d)
+	squares, err := s.splitter.Split(d)
 	if err != nil {
 		return nil, nil, err
 	}
-	if len(commit.d) != len(s.cRep) {
+	if len(squares) != len(s.cRep) {
 		return nil, nil, errors.New("split function returned wrong number of results")
 	}
-
-	// Check d values and generate randomizers for them
-	commit.dRandomizers = make([]*big.Int, len(commit.d))
-	for i, v := range commit.d {
-		if v.BitLen() > int(s.ld) {
-			return nil, nil, errors.New("split function returned oversized d")
-		}
-		commit.dRandomizers[i], err = common.RandomBigInt(s.ld + g.Params.Lh + g.Params.Lstatzk)
-		if err != nil {
-			return nil, nil, err
-		}
-	}
-
-	// Generate v and vRandomizers
-	commit.v = make([]*big.Int, len(commit.d))
-	commit.vRandomizers = make([]*big.Int, len(commit.d))
-	for i := range commit.d {
-		commit.v[i], err = common.RandomBigInt(g.Params.Lm)
-		if err != nil {
-			return nil, nil, err
-		}
-		commit.vRandomizers[i], err = common.RandomBigInt(g.Params.Lm + g.Params.Lh + g.Params.Lstatzk)
-		if err != nil {
-			return nil, nil, err
-		}
-	}
-
-	// Generate v5 and its randomizer
-	commit.v5 = big.NewInt(0)
-	for i := range commit.d {
-		contrib := new(big.Int).Mul(commit.d[i], commit.v[i])
-		commit.v5.Add(commit.v5, contrib)
-	}
-	commit.v5Randomizer, err = common.RandomBigInt(g.Params.Lm + s.ld + 2 + g.Params.Lh + g.Params.Lstatzk)
+	commit.squaresCommit, err = newSquaresCommit(g, s.index, squares, commit.ld)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	// Calculate the bases
-	commit.c = make([]*big.Int, len(commit.d))
-	for i := range commit.d {
-		commit.c[i] = new(big.Int).Exp(g.R[s.index], commit.d[i], g.N)
-		commit.c[i].Mul(commit.c[i], new(big.Int).Exp(g.S, commit.v[i], g.N))
-		commit.c[i].Mod(commit.c[i], g.N)
-	}
-
-	bases := zkproof.NewBaseMerge(g, commit)
+	return s.commitmentsFromSecrets(g, commit), (*ProofCommit)(commit), nil
+}
 
-	var contributions []*big.Int
-	contributions = s.mCorrect.CommitmentsFromSecrets(g, contributions, &bases, commit)
-	for i := range commit.d {
-		contributions = s.cRep[i].CommitmentsFromSecrets(g, contributions, &bases, commit)
+// squareBits returns the maximum number of bits of the squares. If the splitter does not specify
+// it, it is derived from the maximum delta a*m - k (or k - a*m) for attributes of Lm bits.
+func (s *ProofStructure) squareBits(g *gabikeys.PublicKey) uint {
+	if s.ld != 0 {
+		return s.ld
 	}
-
-	return contributions, (*ProofCommit)(commit), nil
+	l := g.Params.Lm + uint(bits.Len(s.a))
+	if uint(s.k.BitLen()) > l {
+		l = uint(s.k.BitLen())
+	}
+	// delta < 2^(l+1), so its squares are less than 2^ceil((l+1)/2)
+	return (l + 2) / 2
 }
 
 func (s *ProofStructure) BuildProof(commit *ProofCommit, challenge *big.Int) *Proof {
 	result := &Proof{
-		Cs:         make([]*big.Int, len(commit.c)),
-		DResponses: make([]*big.Int, len(commit.d)),
-		VResponses: make([]*big.Int, len(commit.v)),
-		V5Response: new(big.Int).Add(new(big.Int).Mul(challenge, commit.v5), commit.v5Randomizer),
-		MResponse:  new(big.Int).Add(new(big.Int).Mul(challenge, commit.m), commit.mRandomizer),
+		MResponse: new(big.Int).Add(new(big.Int).Mul(challenge, commit.m), commit.mRandomizer),
 
-		Ld:   s.ld,
+		Ld:   commit.ld,
 		Sign: s.sign,
 		A:    s.a,
 		K:    new(big.Int).Set(s.k),
 	}
-
-	for i := range commit.c {
-		result.Cs[i] = new(big.Int).Set(commit.c[i])
-	}
-	for i := range commit.d {
-		result.DResponses[i] = new(big.Int).Add(new(big.Int).Mul(challenge, commit.d[i]), commit.dRandomizers[i])
-	}
-	for i := range commit.v {
-		result.VResponses[i] = new(big.Int).Add(new(big.Int).Mul(challenge, commit.v[i]), commit.vRandomizers[i])
-	}
+	result.Cs, result.DResponses, result.VResponses, result.V5Response = commit.responses(challenge)
 
 	return result
 }
 
 func (s *ProofStructure) VerifyProofStructure(g *gabikeys.PublicKey, p *Proof) bool {
-	if len(s.cRep) != len(p.Cs) || len(s.cRep) != len(p.DResponses) || len(s.cRep) != len(p.VResponses) {
-		return false
-	}
-
-	if p.V5Response == nil || p.MResponse == nil {
-		return false
-	}
-
-	if uint(p.V5Response.BitLen()) > g.Params.Lm+s.ld+2+g.Params.Lh+g.Params.Lstatzk+1 ||
-		uint(p.MResponse.BitLen()) > g.Params.Lm+g.Params.Lh+g.Params.Lstatzk+1 {
+	if !s.verifyResponses(g, s.squareBits(g), p.Cs, p.DResponses, p.VResponses, p.V5Response) {
 		return false
 	}
 
-	for i := range s.cRep {
-		if p.Cs[i] == nil || p.DResponses[i] == nil || p.VResponses[i] == nil {
-			return false
-		}
-
-		if p.Cs[i].BitLen() > g.N.BitLen() ||
-			uint(p.DResponses[i].BitLen()) > s.ld+g.Params.Lh+g.Params.Lstatzk+1 ||
-			uint(p.VResponses[i].BitLen()) > g.Params.Lm+g.Params.Lh+g.Params.Lstatzk+1 {
-			return false
-		}
-	}
-
-	return true
+	return p.MResponse != nil && uint(p.MResponse.BitLen()) <= g.Params.Lm+g.Params.Lh+g.Params.Lstatzk+1
 }
 
 func (s *ProofStructure) CommitmentsFromProof(g *gabikeys.PublicKey, p *Proof, challenge *big.Int) []*big.Int {
-	bases := zkproof.NewBaseMerge(g, (*proof)(p))
-
-	var contributions []*big.Int
-	contributions = s.mCorrect.CommitmentsFromProof(g, contributions, challenge, &bases, (*proof)(p))
-	for i := range s.cRep {
-		contributions = s.cRep[i].CommitmentsFromProof(g, contributions, challenge, &bases, (*proof)(p))
-	}
-
-	return contributions
+	return s.commitmentsFromProof(g, (*proof)(p), challenge)
 }
 
 // ProvesStatement returns whether the Proof proves or implies the specified statement.
@@ -478,9 +375,9 @@ func (p *Proof) ExtractStructure(index int, g *gabikeys.PublicKey) (*ProofStruct
 	//  which is bigger than m*a
 	// p.K >= 2^lm+sizeof(a) is never reasonable since that makes |m*a| < |k|, making
 	//  the proof statement trivial (it either always or never holds)
-	if p.K == nil || p.Ld > g.Params.Lm || len(p.Cs) < 3 || len(p.Cs) > 4 ||
+	if p.K == nil || p.Ld == 0 || p.Ld > g.Params.Lm || len(p.Cs) < 3 || len(p.Cs) > 4 ||
 		p.K.BitLen() > int(g.Params.Lm+strconv.IntSize) ||
-		(len(p.Cs) == 3 && p.A != 4) {
+		(len(p.Cs) == 3 && (p.A == 0 || p.A%4 != 0)) {
 		return nil, errors.New("invalid proof")
 	}
 	return newWithParams(index, p.Sign, p.A, p.K, nil, len(p.Cs), p.Ld)
@@ -493,77 +390,14 @@ func (c *proofCommit) Secret(name string) *big.Int {
 	if name == "m" {
 		return c.m
 	}
-	if name == "v5" {
-		return c.v5
-	}
-	if name[0] == 'v' {
-		i, err := strconv.Atoi(name[1:])
-		if err != nil || i < 0 || i >= len(c.v) {
-			return nil
-		}
-		return c.v[i]
-	}
-	if name[0] == 'd' {
-		i, err := strconv.Atoi(name[1:])
-		if err != nil || i < 0 || i >= len(c.d) {
-			return nil
-		}
-		return c.d[i]
-	}
-	return nil
+	return c.secret(name)
 }
 
 func (c *proofCommit) Randomizer(name string) *big.Int {
 	if name == "m" {
 		return c.mRandomizer
 	}
-	if name == "v5" {
-		return c.v5Randomizer
-	}
-	if name[0] == 'v' {
-		i, err := strconv.Atoi(name[1:])
-		if err != nil || i < 0 || i >= len(c.vRandomizers) {
-			return nil
-		}
-		return c.vRandomizers[i]
-	}
-	if name[0] == 'd' {
-		i, err := strconv.Atoi(name[1:])
-		if err != nil || i < 0 || i >= len(c.dRandomizers) {
-			return nil
-		}
-		return c.dRandomizers[i]
-	}
-	return nil
-}
-
-func (c *proofCommit) Base(name string) *big.Int {
-	if name[0] == 'C' {
-		i, err := strconv.Atoi(name[1:])
-		if err != nil || i < 0 || i >= len(c.c) {
-			return nil
-		}
-		return c.c[i]
-	}
-	return nil
-}
-
-func (c *proofCommit) Exp(ret *big.Int, name string, exp, n *big.Int) bool {
-	base := c.Base(name)
-	if base == nil {
-		return false
-	}
-	ret.Exp(base, exp, n)
-	return true
-}
-
-func (c *proofCommit) Names() []string {
-	result := make([]string, 0, len(c.c))
-	for i := range c.c {
-		result = append(result, fmt.Sprintf("C%d", i))
-	}
-
-	return result
+	return c.randomizer(name)
 }
 
 // ---
@@ -573,51 +407,17 @@ func (p *proof) ProofResult(name string) *big.Int {
 	if name == "m" {
 		return p.MResponse
 	}
-	if name == "v5" {
-		return p.V5Response
-	}
-	if name[0] == 'v' {
-		i, err := strconv.Atoi(name[1:])
-		if err != nil || i < 0 || i >= len(p.VResponses) {
-			return nil
-		}
-		return p.VResponses[i]
-	}
-	if name[0] == 'd' {
-		i, err := strconv.Atoi(name[1:])
-		if err != nil || i < 0 || i >= len(p.DResponses) {
-			return nil
-		}
-		return p.DResponses[i]
-	}
-	return nil
+	return squaresValue(name, p.DResponses, p.VResponses, p.V5Response)
 }
 
 func (p *proof) Base(name string) *big.Int {
-	if name[0] == 'C' {
-		i, err := strconv.Atoi(name[1:])
-		if err != nil || i < 0 || i >= len(p.Cs) {
-			return nil
-		}
-		return p.Cs[i]
-	}
-	return nil
+	return squaresBase(name, p.Cs)
 }
 
 func (p *proof) Exp(ret *big.Int, name string, exp, n *big.Int) bool {
-	base := p.Base(name)
-	if base == nil {
-		return false
-	}
-	ret.Exp(base, exp, n)
-	return true
+	return squaresExp(ret, p.Base(name), exp, n)
 }
 
 func (p *proof) Names() []string {
-	result := make([]string, 0, len(p.Cs))
-	for i := range p.Cs {
-		result = append(result, fmt.Sprintf("C%d", i))
-	}
-
-	return result
+	return squaresNames(len(p.Cs))
 }
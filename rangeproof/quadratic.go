@@ -0,0 +1,478 @@
+package rangeproof
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/privacybydesign/gabi/big"
+	"github.com/privacybydesign/gabi/gabikeys"
+	"github.com/privacybydesign/gabi/internal/common"
+	"github.com/privacybydesign/gabi/zkproof"
+
+	"github.com/go-errors/errors"
+)
+
+/*
+The quadratic proof structure below is a building block for statements that are quadratic in the
+attribute m. Given constants k1, k2, t and a sign (1 or -1), it proves that
+(m - k1) * sign*(m - k2) - t >= 0, by committing to both factors and writing the product minus t as
+a sum of four squares. Specifically, it proves the following substatements:
+
+    C R^(k1) = R^m S^(w1)
+    D C^(sign*k2) = C^(sign*m) S^(w2)
+    C_i = R^(d_i) S^(v_i)
+    D R^(-t) = \product_i C_i^(d_i) S^x
+
+where
+- m is the attribute value,
+- d_i are values such that (m - k1) * sign*(m - k2) - t = \sum_i (d_i)^2,
+- w1, w2, v_i are computational hiders,
+- x = sign*(m - k2)*w1 + w2 - \sum_i d_i * v_i.
+
+From the first two substatements we get D = R^((m - k1) * sign*(m - k2)) S^b for some b, after which
+soundness follows from the last two substatements in the same way as for the range proofs.
+
+In order to not leak information about m through the sign of x, the prover chooses w2 large enough
+that x is always positive.
+*/
+
+type (
+	quadraticStructure struct {
+		index int
+		k1    *big.Int
+		sign  int
+		k2    *big.Int
+		t     *big.Int
+
+		cRep     zkproof.QrRepresentationProofStructure
+		dRep     zkproof.QrRepresentationProofStructure
+		squares  []zkproof.QrRepresentationProofStructure
+		mCorrect zkproof.QrRepresentationProofStructure
+	}
+
+	// quadraticProof contains the responses of a quadratic proof structure, and is embedded in the
+	// proofs of the statements built on it.
+	quadraticProof struct {
+		C          *big.Int   `json:"C"`
+		D          *big.Int   `json:"D"`
+		Cs         []*big.Int `json:"Cs"`
+		W1Response *big.Int   `json:"w1"`
+		W2Response *big.Int   `json:"w2"`
+		XResponse  *big.Int   `json:"x"`
+		DResponses []*big.Int `json:"ds"`
+		VResponses []*big.Int `json:"vs"`
+		MResponse  *big.Int   `json:"-"`
+	}
+
+	quadraticCommit struct {
+		// Bases
+		c, d *big.Int
+		cs   []*big.Int
+
+		// Secrets
+		w1, w1Randomizer *big.Int
+		w2, w2Randomizer *big.Int
+		x, xRandomizer   *big.Int
+		ds, dRandomizers []*big.Int
+		vs, vRandomizers []*big.Int
+		m, mRandomizer   *big.Int
+	}
+)
+
+const quadraticSquareCount = 4
+
+func newQuadraticStructure(index int, k1 *big.Int, sign int, k2, t *big.Int) (*quadraticStructure, error) {
+	if sign != 1 && sign != -1 {
+		return nil, ErrUnsupportedSign
+	}
+	if k1 == nil || k2 == nil || t == nil || k1.Sign() < 0 || k2.Sign() < 0 || t.Sign() < 0 {
+		return nil, errors.New("constants must be nonnegative")
+	}
+
+	r := fmt.Sprintf("R%d", index)
+	result := &quadraticStructure{
+		index: index,
+		k1:    new(big.Int).Set(k1),
+		sign:  sign,
+		k2:    new(big.Int).Set(k2),
+		t:     new(big.Int).Set(t),
+
+		cRep: zkproof.QrRepresentationProofStructure{
+			Lhs: []zkproof.LhsContribution{
+				{Base: "C", Power: big.NewInt(1)},
+				{Base: r, Power: new(big.Int).Set(k1)},
+			},
+			Rhs: []zkproof.RhsContribution{
+				{Base: r, Secret: "m", Power: 1},
+				{Base: "S", Secret: "w1", Power: 1},
+			},
+		},
+		dRep: zkproof.QrRepresentationProofStructure{
+			Lhs: []zkproof.LhsContribution{
+				{Base: "D", Power: big.NewInt(1)},
+				{Base: "C", Power: new(big.Int).Mul(big.NewInt(int64(sign)), k2)},
+			},
+			Rhs: []zkproof.RhsContribution{
+				{Base: "C", Secret: "m", Power: int64(sign)},
+				{Base: "S", Secret: "w2", Power: 1},
+			},
+		},
+		mCorrect: zkproof.QrRepresentationProofStructure{
+			Lhs: []zkproof.LhsContribution{
+				{Base: "D", Power: big.NewInt(1)},
+				{Base: r, Power: new(big.Int).Neg(t)},
+			},
+			Rhs: []zkproof.RhsContribution{
+				{Base: "S", Secret: "x", Power: 1},
+			},
+		},
+	}
+
+	for i := 0; i < quadraticSquareCount; i++ {
+		result.squares = append(result.squares, zkproof.QrRepresentationProofStructure{
+			Lhs: []zkproof.LhsContribution{
+				{Base: fmt.Sprintf("C%d", i), Power: big.NewInt(1)},
+			},
+			Rhs: []zkproof.RhsContribution{
+				{Base: r, Secret: fmt.Sprintf("d%d", i), Power: 1},
+				{Base: "S", Secret: fmt.Sprintf("v%d", i), Power: 1},
+			},
+		})
+		result.mCorrect.Rhs = append(result.mCorrect.Rhs, zkproof.RhsContribution{
+			Base:   fmt.Sprintf("C%d", i),
+			Secret: fmt.Sprintf("d%d", i),
+			Power:  1,
+		})
+	}
+
+	return result, nil
+}
+
+// Bitsizes of the secrets. The factors are smaller than 2^lm in absolute value, so their product
+// is smaller than 2^(2*lm) and the d_i are smaller than 2^lm. Then the contributions to x of w1
+// and the v_i are smaller than 2^(2*lm+3) in absolute value, which w2 must exceed.
+func (s *quadraticStructure) ld(g *gabikeys.PublicKey) uint {
+	return g.Params.Lm
+}
+
+func (s *quadraticStructure) lw2(g *gabikeys.PublicKey) uint {
+	return 2*g.Params.Lm + 4
+}
+
+func (s *quadraticStructure) lx(g *gabikeys.PublicKey) uint {
+	return 2*g.Params.Lm + 5
+}
+
+func (s *quadraticStructure) commitmentsFromSecrets(g *gabikeys.PublicKey, m, mRandomizer *big.Int) ([]*big.Int, *quadraticCommit, error) {
+	var err error
+
+	if uint(m.BitLen()) > g.Params.Lm || uint(s.k1.BitLen()) > g.Params.Lm || uint(s.k2.BitLen()) > g.Params.Lm {
+		return nil, nil, errors.New("value too large")
+	}
+
+	delta1 := new(big.Int).Sub(m, s.k1)
+	delta2 := new(big.Int).Sub(m, s.k2)
+	if s.sign == -1 {
+		delta2.Neg(delta2)
+	}
+	delta := new(big.Int).Mul(delta1, delta2)
+	delta.Sub(delta, s.t)
+	if delta.Sign() < 0 {
+		return nil, nil, ErrFalseStatement
+	}
+
+	commit := &quadraticCommit{
+		m:           m,
+		mRandomizer: mRandomizer,
+	}
+
+	commit.ds, err = (&FourSquaresSplitter{}).Split(delta)
+	if err != nil {
+		return nil, nil, err
+	}
+	ld := s.ld(g)
+	commit.dRandomizers = make([]*big.Int, quadraticSquareCount)
+	commit.vs = make([]*big.Int, quadraticSquareCount)
+	commit.vRandomizers = make([]*big.Int, quadraticSquareCount)
+	commit.cs = make([]*big.Int, quadraticSquareCount)
+	for i, d := range commit.ds {
+		if uint(d.BitLen()) > ld {
+			return nil, nil, errors.New("split function returned oversized d")
+		}
+		if commit.dRandomizers[i], err = common.RandomBigInt(ld + g.Params.Lh + g.Params.Lstatzk); err != nil {
+			return nil, nil, err
+		}
+		if commit.vs[i], err = common.RandomBigInt(g.Params.Lm); err != nil {
+			return nil, nil, err
+		}
+		if commit.vRandomizers[i], err = common.RandomBigInt(g.Params.Lm + g.Params.Lh + g.Params.Lstatzk); err != nil {
+			return nil, nil, err
+		}
+		commit.cs[i] = new(big.Int).Exp(g.R[s.index], d, g.N)
+		commit.cs[i].Mul(commit.cs[i], new(big.Int).Exp(g.S, commit.vs[i], g.N))
+		commit.cs[i].Mod(commit.cs[i], g.N)
+	}
+
+	// Generate w1, and w2 from [2^(lw2-1), 2^lw2) so that x is positive
+	if commit.w1, err = common.RandomBigInt(g.Params.Lm); err != nil {
+		return nil, nil, err
+	}
+	if commit.w1Randomizer, err = common.RandomBigInt(g.Params.Lm + g.Params.Lh + g.Params.Lstatzk); err != nil {
+		return nil, nil, err
+	}
+	lw2 := s.lw2(g)
+	if commit.w2, err = common.RandomBigInt(lw2 - 1); err != nil {
+		return nil, nil, err
+	}
+	commit.w2.Add(commit.w2, new(big.Int).Lsh(big.NewInt(1), lw2-1))
+	if commit.w2Randomizer, err = common.RandomBigInt(lw2 + g.Params.Lh + g.Params.Lstatzk); err != nil {
+		return nil, nil, err
+	}
+
+	// Calculate x
+	commit.x = new(big.Int).Mul(delta2, commit.w1)
+	commit.x.Add(commit.x, commit.w2)
+	for i := range commit.ds {
+		commit.x.Sub(commit.x, new(big.Int).Mul(commit.ds[i], commit.vs[i]))
+	}
+	if commit.xRandomizer, err = common.RandomBigInt(s.lx(g) + g.Params.Lh + g.Params.Lstatzk); err != nil {
+		return nil, nil, err
+	}
+
+	// Calculate C and D
+	if commit.c, err = common.ModPow(g.R[s.index], delta1, g.N); err != nil {
+		return nil, nil, err
+	}
+	commit.c.Mul(commit.c, new(big.Int).Exp(g.S, commit.w1, g.N)).Mod(commit.c, g.N)
+	if commit.d, err = common.ModPow(commit.c, delta2, g.N); err != nil {
+		return nil, nil, err
+	}
+	commit.d.Mul(commit.d, new(big.Int).Exp(g.S, commit.w2, g.N)).Mod(commit.d, g.N)
+
+	bases := zkproof.NewBaseMerge(g, commit)
+
+	contributions := []*big.Int{new(big.Int).Set(commit.c), new(big.Int).Set(commit.d)}
+	for _, c := range commit.cs {
+		contributions = append(contributions, new(big.Int).Set(c))
+	}
+	contributions = s.cRep.CommitmentsFromSecrets(g, contributions, &bases, commit)
+	contributions = s.dRep.CommitmentsFromSecrets(g, contributions, &bases, commit)
+	contributions = s.mCorrect.CommitmentsFromSecrets(g, contributions, &bases, commit)
+	for i := range s.squares {
+		contributions = s.squares[i].CommitmentsFromSecrets(g, contributions, &bases, commit)
+	}
+
+	return contributions, commit, nil
+}
+
+func (s *quadraticStructure) buildProof(commit *quadraticCommit, challenge *big.Int) quadraticProof {
+	response := func(secret, randomizer *big.Int) *big.Int {
+		return new(big.Int).Add(new(big.Int).Mul(challenge, secret), randomizer)
+	}
+
+	result := quadraticProof{
+		C:          new(big.Int).Set(commit.c),
+		D:          new(big.Int).Set(commit.d),
+		Cs:         make([]*big.Int, len(commit.cs)),
+		W1Response: response(commit.w1, commit.w1Randomizer),
+		W2Response: response(commit.w2, commit.w2Randomizer),
+		XResponse:  response(commit.x, commit.xRandomizer),
+		DResponses: make([]*big.Int, len(commit.ds)),
+		VResponses: make([]*big.Int, len(commit.vs)),
+		MResponse:  response(commit.m, commit.mRandomizer),
+	}
+	for i := range commit.cs {
+		result.Cs[i] = new(big.Int).Set(commit.cs[i])
+		result.DResponses[i] = response(commit.ds[i], commit.dRandomizers[i])
+		result.VResponses[i] = response(commit.vs[i], commit.vRandomizers[i])
+	}
+
+	return result
+}
+
+func (s *quadraticStructure) verifyProofStructure(g *gabikeys.PublicKey, p *quadraticProof) bool {
+	if len(p.Cs) != quadraticSquareCount || len(p.DResponses) != quadraticSquareCount || len(p.VResponses) != quadraticSquareCount {
+		return false
+	}
+
+	if p.C == nil || p.D == nil || p.W1Response == nil || p.W2Response == nil || p.XResponse == nil || p.MResponse == nil {
+		return false
+	}
+
+	l := g.Params.Lh + g.Params.Lstatzk + 1
+	if p.C.BitLen() > g.N.BitLen() || p.D.BitLen() > g.N.BitLen() ||
+		uint(p.W1Response.BitLen()) > g.Params.Lm+l ||
+		uint(p.W2Response.BitLen()) > s.lw2(g)+l ||
+		uint(p.XResponse.BitLen()) > s.lx(g)+l ||
+		uint(p.MResponse.BitLen()) > g.Params.Lm+l {
+		return false
+	}
+
+	for i := range p.Cs {
+		if p.Cs[i] == nil || p.DResponses[i] == nil || p.VResponses[i] == nil {
+			return false
+		}
+		if p.Cs[i].BitLen() > g.N.BitLen() ||
+			uint(p.DResponses[i].BitLen()) > s.ld(g)+l ||
+			uint(p.VResponses[i].BitLen()) > g.Params.Lm+l {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (s *quadraticStructure) commitmentsFromProof(g *gabikeys.PublicKey, p *quadraticProof, challenge *big.Int) []*big.Int {
+	bases := zkproof.NewBaseMerge(g, p)
+
+	contributions := []*big.Int{new(big.Int).Set(p.C), new(big.Int).Set(p.D)}
+	for _, c := range p.Cs {
+		contributions = append(contributions, new(big.Int).Set(c))
+	}
+	contributions = s.cRep.CommitmentsFromProof(g, contributions, challenge, &bases, p)
+	contributions = s.dRep.CommitmentsFromProof(g, contributions, challenge, &bases, p)
+	contributions = s.mCorrect.CommitmentsFromProof(g, contributions, challenge, &bases, p)
+	for i := range s.squares {
+		contributions = s.squares[i].CommitmentsFromProof(g, contributions, challenge, &bases, p)
+	}
+
+	return contributions
+}
+
+// indexedName parses names of the form prefix%d, returning -1 if name is not of this form.
+func indexedName(name string, prefix byte) int {
+	if len(name) < 2 || name[0] != prefix {
+		return -1
+	}
+	i, err := strconv.Atoi(name[1:])
+	if err != nil || i < 0 {
+		return -1
+	}
+	return i
+}
+
+// ---
+// Commit structure keyproof interfaces
+// ---
+func (c *quadraticCommit) Secret(name string) *big.Int {
+	switch name {
+	case "m":
+		return c.m
+	case "w1":
+		return c.w1
+	case "w2":
+		return c.w2
+	case "x":
+		return c.x
+	}
+	if i := indexedName(name, 'd'); i >= 0 && i < len(c.ds) {
+		return c.ds[i]
+	}
+	if i := indexedName(name, 'v'); i >= 0 && i < len(c.vs) {
+		return c.vs[i]
+	}
+	return nil
+}
+
+func (c *quadraticCommit) Randomizer(name string) *big.Int {
+	switch name {
+	case "m":
+		return c.mRandomizer
+	case "w1":
+		return c.w1Randomizer
+	case "w2":
+		return c.w2Randomizer
+	case "x":
+		return c.xRandomizer
+	}
+	if i := indexedName(name, 'd'); i >= 0 && i < len(c.dRandomizers) {
+		return c.dRandomizers[i]
+	}
+	if i := indexedName(name, 'v'); i >= 0 && i < len(c.vRandomizers) {
+		return c.vRandomizers[i]
+	}
+	return nil
+}
+
+func (c *quadraticCommit) Base(name string) *big.Int {
+	switch name {
+	case "C":
+		return c.c
+	case "D":
+		return c.d
+	}
+	if i := indexedName(name, 'C'); i >= 0 && i < len(c.cs) {
+		return c.cs[i]
+	}
+	return nil
+}
+
+func (c *quadraticCommit) Exp(ret *big.Int, name string, exp, n *big.Int) bool {
+	base := c.Base(name)
+	if base == nil {
+		return false
+	}
+	ret.Exp(base, exp, n)
+	return true
+}
+
+func (c *quadraticCommit) Names() []string {
+	result := []string{"C", "D"}
+	for i := range c.cs {
+		result = append(result, fmt.Sprintf("C%d", i))
+	}
+	return result
+}
+
+// ---
+// Proof structure keyproof interfaces
+// ---
+func (p *quadraticProof) ProofResult(name string) *big.Int {
+	switch name {
+	case "m":
+		return p.MResponse
+	case "w1":
+		return p.W1Response
+	case "w2":
+		return p.W2Response
+	case "x":
+		return p.XResponse
+	}
+	if i := indexedName(name, 'd'); i >= 0 && i < len(p.DResponses) {
+		return p.DResponses[i]
+	}
+	if i := indexedName(name, 'v'); i >= 0 && i < len(p.VResponses) {
+		return p.VResponses[i]
+	}
+	return nil
+}
+
+func (p *quadraticProof) Base(name string) *big.Int {
+	switch name {
+	case "C":
+		return p.C
+	case "D":
+		return p.D
+	}
+	if i := indexedName(name, 'C'); i >= 0 && i < len(p.Cs) {
+		return p.Cs[i]
+	}
+	return nil
+}
+
+func (p *quadraticProof) Exp(ret *big.Int, name string, exp, n *big.Int) bool {
+	base := p.Base(name)
+	if base == nil {
+		return false
+	}
+	ret.Exp(base, exp, n)
+	return true
+}
+
+func (p *quadraticProof) Names() []string {
+	result := []string{"C", "D"}
+	for i := range p.Cs {
+		result = append(result, fmt.Sprintf("C%d", i))
+	}
+	return result
+}
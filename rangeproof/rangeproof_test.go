@@ -1,8 +1,11 @@
 package rangeproof_test
 
 import (
+	"encoding/json"
 	"testing"
+	"time"
 
+	"github.com/fxamacker/cbor"
 	"github.com/privacybydesign/gabi/big"
 	"github.com/privacybydesign/gabi/gabikeys"
 	"github.com/privacybydesign/gabi/internal/common"
@@ -150,6 +153,68 @@ func TestRangeProofUsingSumFourSquareAlg(t *testing.T) {
 	testRangeProofWithSplitter(t, &rangeproof.FourSquaresSplitter{})
 }
 
+func TestRangeProofUsingThreeSquaresAlg(t *testing.T) {
+	testRangeProofWithSplitter(t, &rangeproof.ThreeSquaresSplitter{})
+
+	// Large deltas and factors other than 1: 4*(3*m - m/2) + 2 < 2^(lm+4), so the squares have
+	// at most lm/2 + 2 bits
+	g := setupPubkey(t)
+	m, err := common.RandomBigInt(g.Params.Lm)
+	require.NoError(t, err)
+	mRandomizer, err := common.RandomBigInt(g.Params.Lm + g.Params.Lh + g.Params.Lstatzk)
+	require.NoError(t, err)
+	splitter := &rangeproof.ThreeSquaresSplitter{Bits: g.Params.Lm/2 + 2}
+	s, err := rangeproof.NewProofStructure(1, 1, 3, new(big.Int).Rsh(m, 1), splitter)
+	require.NoError(t, err)
+
+	secretList, commit, err := s.CommitmentsFromSecrets(g, m, mRandomizer)
+	require.NoError(t, err)
+	proof := s.BuildProof(commit, big.NewInt(1234567))
+	assert.Len(t, proof.Cs, 3)
+	s, err = proof.ExtractStructure(1, g)
+	require.NoError(t, err)
+	assert.True(t, s.VerifyProofStructure(g, proof))
+	assert.True(t, proof.ProvesStatement(1, 3, new(big.Int).Rsh(m, 1)))
+	assert.Equal(t, secretList, s.CommitmentsFromProof(g, proof, big.NewInt(1234567)))
+
+	typ, factor, bound := proof.ProvenStatement()
+	assert.Equal(t, rangeproof.GreaterOrEqual, typ)
+	assert.Equal(t, uint(3), factor)
+	assert.Equal(t, new(big.Int).Rsh(m, 1), bound)
+
+	// Without Bits, the size of the squares is derived from the attribute size and factor, also
+	// for the 512-bit attributes of 4096-bit keys (of which we only need the parameters here)
+	g4096 := *g
+	g4096.Params = gabikeys.DefaultSystemParameters[4096]
+	for _, pk := range []*gabikeys.PublicKey{g, &g4096} {
+		m, err := common.RandomBigInt(pk.Params.Lm)
+		require.NoError(t, err)
+		mRandomizer, err := common.RandomBigInt(pk.Params.Lm + pk.Params.Lh + pk.Params.Lstatzk)
+		require.NoError(t, err)
+		for _, factor := range []uint{1, 3, 1 << 20} {
+			for _, sign := range []int{1, -1} {
+				bound := new(big.Int).Rsh(m, 1)
+				if sign == -1 {
+					bound.Lsh(m, 1)
+				}
+				s, err := rangeproof.NewProofStructure(1, sign, factor, new(big.Int).Mul(bound, big.NewInt(int64(factor))), &rangeproof.ThreeSquaresSplitter{})
+				require.NoError(t, err)
+				secretList, commit, err := s.CommitmentsFromSecrets(pk, m, mRandomizer)
+				require.NoError(t, err)
+				proof := s.BuildProof(commit, big.NewInt(1234567))
+				extracted, err := proof.ExtractStructure(1, pk)
+				require.NoError(t, err)
+				assert.True(t, extracted.VerifyProofStructure(pk, proof))
+				assert.Equal(t, secretList, extracted.CommitmentsFromProof(pk, proof, big.NewInt(1234567)))
+			}
+		}
+	}
+
+	// Numbers of the form 4^a(8b+7) are not split
+	_, err = (&rangeproof.ThreeSquaresSplitter{}).Split(big.NewInt(4 * 15))
+	assert.Error(t, err)
+}
+
 func TestRangeProofExtractStructure(t *testing.T) {
 	g := setupPubkey(t)
 
@@ -336,3 +401,452 @@ func TestVerifyProofStructure(t *testing.T) {
 	proof.VResponses = append(proof.VResponses, backup)
 	assert.True(t, s.VerifyProofStructure(g, proof))
 }
+
+func TestSetMembershipProof(t *testing.T) {
+	g := setupPubkey(t)
+
+	m := big.NewInt(112)
+	mRandomizer, err := common.RandomBigInt(g.Params.Lm + g.Params.Lh + g.Params.Lstatzk)
+	require.NoError(t, err)
+
+	for _, set := range [][]*big.Int{
+		{big.NewInt(112)},
+		{big.NewInt(112), big.NewInt(45), big.NewInt(1 << 40)},
+		{big.NewInt(45), big.NewInt(1 << 40), big.NewInt(112)},
+	} {
+		statement, err := rangeproof.NewSetMembershipStatement(set)
+		require.NoError(t, err)
+		s, err := statement.ProofStructure(1)
+		require.NoError(t, err)
+
+		secretList, commit, err := s.CommitmentsFromSecrets(g, m, mRandomizer)
+		require.NoError(t, err)
+		proof := s.BuildProof(commit, big.NewInt(1234567))
+
+		s, err = proof.ExtractStructure(1, g)
+		require.NoError(t, err)
+		assert.True(t, s.VerifyProofStructure(g, proof))
+		assert.True(t, proof.Proves(statement))
+		proofList := s.CommitmentsFromProof(g, proof, big.NewInt(1234567))
+		assert.Equal(t, secretList, proofList)
+
+		// The proof does not prove membership of a different set
+		proof.Set[0] = big.NewInt(46)
+		s, err = proof.ExtractStructure(1, g)
+		require.NoError(t, err)
+		assert.False(t, proof.Proves(statement))
+		assert.NotEqual(t, secretList, s.CommitmentsFromProof(g, proof, big.NewInt(1234567)))
+	}
+}
+
+func TestSetMembershipProofInvalidStatement(t *testing.T) {
+	g := setupPubkey(t)
+
+	_, err := rangeproof.NewSetMembershipStatement(nil)
+	assert.Error(t, err)
+	_, err = rangeproof.NewSetMembershipStatement([]*big.Int{big.NewInt(-1)})
+	assert.Error(t, err)
+
+	// Sets are bounded in size, also when extracted from a proof
+	set := make([]*big.Int, rangeproof.MaxSetSize+1)
+	for i := range set {
+		set[i] = big.NewInt(int64(i))
+	}
+	_, err = rangeproof.NewSetMembershipStatement(set)
+	assert.Equal(t, rangeproof.ErrSetTooLarge, err)
+	_, err = rangeproof.NewSetMembershipProofStructure(1, set)
+	assert.Equal(t, rangeproof.ErrSetTooLarge, err)
+	_, err = (&rangeproof.SetMembershipProof{Set: set}).ExtractStructure(1, g)
+	assert.Equal(t, rangeproof.ErrSetTooLarge, err)
+	_, err = rangeproof.NewSetMembershipStatement(set[:rangeproof.MaxSetSize])
+	assert.NoError(t, err)
+
+	statement, err := rangeproof.NewSetMembershipStatement([]*big.Int{big.NewInt(45), big.NewInt(113)})
+	require.NoError(t, err)
+	s, err := statement.ProofStructure(1)
+	require.NoError(t, err)
+
+	mRandomizer, err := common.RandomBigInt(g.Params.Lm + g.Params.Lh + g.Params.Lstatzk)
+	require.NoError(t, err)
+	_, _, err = s.CommitmentsFromSecrets(g, big.NewInt(112), mRandomizer)
+	assert.Equal(t, rangeproof.ErrFalseStatement, err)
+}
+
+func TestNotEqualProof(t *testing.T) {
+	g := setupPubkey(t)
+
+	m := big.NewInt(112)
+	mRandomizer, err := common.RandomBigInt(g.Params.Lm + g.Params.Lh + g.Params.Lstatzk)
+	require.NoError(t, err)
+
+	for _, value := range []*big.Int{big.NewInt(0), big.NewInt(111), big.NewInt(113), new(big.Int).Lsh(big.NewInt(1), 255)} {
+		statement, err := rangeproof.NewNotEqualStatement(value)
+		require.NoError(t, err)
+		s, err := statement.ProofStructure(1)
+		require.NoError(t, err)
+
+		secretList, commit, err := s.CommitmentsFromSecrets(g, m, mRandomizer)
+		require.NoError(t, err)
+		proof := s.BuildProof(commit, big.NewInt(1234567))
+
+		s, err = proof.ExtractStructure(1, g)
+		require.NoError(t, err)
+		assert.True(t, s.VerifyProofStructure(g, proof))
+		assert.True(t, proof.Proves(statement))
+		proofList := s.CommitmentsFromProof(g, proof, big.NewInt(1234567))
+		assert.Equal(t, secretList, proofList)
+
+		// The proof does not prove inequality to a different value
+		proof.Value = big.NewInt(112)
+		s, err = proof.ExtractStructure(1, g)
+		require.NoError(t, err)
+		assert.False(t, proof.Proves(statement))
+		assert.NotEqual(t, secretList, s.CommitmentsFromProof(g, proof, big.NewInt(1234567)))
+	}
+
+	statement, err := rangeproof.NewNotEqualStatement(m)
+	require.NoError(t, err)
+	s, err := statement.ProofStructure(1)
+	require.NoError(t, err)
+	_, _, err = s.CommitmentsFromSecrets(g, m, mRandomizer)
+	assert.Equal(t, rangeproof.ErrFalseStatement, err)
+}
+
+func TestIntervalProof(t *testing.T) {
+	g := setupPubkey(t)
+
+	mRandomizer, err := common.RandomBigInt(g.Params.Lm + g.Params.Lh + g.Params.Lstatzk)
+	require.NoError(t, err)
+
+	statement, err := rangeproof.NewIntervalStatement(big.NewInt(18), big.NewInt(65))
+	require.NoError(t, err)
+	for _, m := range []*big.Int{big.NewInt(18), big.NewInt(40), big.NewInt(65)} {
+		s, err := statement.ProofStructure(1)
+		require.NoError(t, err)
+
+		secretList, commit, err := s.CommitmentsFromSecrets(g, m, mRandomizer)
+		require.NoError(t, err)
+		proof := s.BuildProof(commit, big.NewInt(1234567))
+
+		s, err = proof.ExtractStructure(1, g)
+		require.NoError(t, err)
+		assert.True(t, s.VerifyProofStructure(g, proof))
+		assert.True(t, proof.Proves(statement))
+		proofList := s.CommitmentsFromProof(g, proof, big.NewInt(1234567))
+		assert.Equal(t, secretList, proofList)
+
+		// The proof does not prove a different interval
+		proof.Upper = big.NewInt(64)
+		s, err = proof.ExtractStructure(1, g)
+		require.NoError(t, err)
+		assert.False(t, proof.Proves(statement))
+		assert.NotEqual(t, secretList, s.CommitmentsFromProof(g, proof, big.NewInt(1234567)))
+	}
+
+	for _, m := range []*big.Int{big.NewInt(0), big.NewInt(17), big.NewInt(66), new(big.Int).Lsh(big.NewInt(1), 255)} {
+		s, err := statement.ProofStructure(1)
+		require.NoError(t, err)
+		_, _, err = s.CommitmentsFromSecrets(g, m, mRandomizer)
+		assert.Equal(t, rangeproof.ErrFalseStatement, err)
+	}
+
+	_, err = rangeproof.NewIntervalStatement(big.NewInt(65), big.NewInt(18))
+	assert.Error(t, err)
+}
+
+func TestLinearProof(t *testing.T) {
+	g := setupPubkey(t)
+
+	m := []*big.Int{big.NewInt(100), big.NewInt(30)}
+	mRandomizers := make([]*big.Int, len(m))
+	for i := range m {
+		var err error
+		mRandomizers[i], err = common.RandomBigInt(g.Params.Lm + g.Params.Lh + g.Params.Lstatzk)
+		require.NoError(t, err)
+	}
+
+	// 100 >= 3*30 + 10
+	statement, err := rangeproof.NewLinearStatement([]int64{1, -3}, big.NewInt(10))
+	require.NoError(t, err)
+	s, err := statement.ProofStructure(1)
+	require.NoError(t, err)
+
+	secretList, commit, err := s.CommitmentsFromSecrets(g, m, mRandomizers)
+	require.NoError(t, err)
+	proof := s.BuildProof(commit, big.NewInt(1234567))
+
+	s, err = proof.ExtractStructure(1, g)
+	require.NoError(t, err)
+	assert.True(t, s.VerifyProofStructure(g, proof))
+	assert.True(t, proof.Proves(statement))
+	proofList := s.CommitmentsFromProof(g, proof, big.NewInt(1234567))
+	assert.Equal(t, secretList, proofList)
+
+	// The proof does not prove a different statement
+	proof.K = big.NewInt(11)
+	s, err = proof.ExtractStructure(1, g)
+	require.NoError(t, err)
+	assert.False(t, proof.Proves(statement))
+	assert.NotEqual(t, secretList, s.CommitmentsFromProof(g, proof, big.NewInt(1234567)))
+
+	// 100 >= 3*30 + 11 does not hold
+	statement, err = rangeproof.NewLinearStatement([]int64{1, -3}, big.NewInt(11))
+	require.NoError(t, err)
+	s, err = statement.ProofStructure(1)
+	require.NoError(t, err)
+	_, _, err = s.CommitmentsFromSecrets(g, m, mRandomizers)
+	assert.Equal(t, rangeproof.ErrFalseStatement, err)
+
+	_, err = rangeproof.NewLinearStatement([]int64{1, 0}, big.NewInt(0))
+	assert.Error(t, err)
+	_, err = rangeproof.NewLinearStatement([]int64{1}, big.NewInt(-1))
+	assert.Error(t, err)
+
+	// Statements and proofs with too many terms are rejected
+	factors := make([]int64, rangeproof.MaxLinearTerms+1)
+	for i := range factors {
+		factors[i] = 1
+	}
+	_, err = rangeproof.NewLinearStatement(factors, big.NewInt(0))
+	assert.Equal(t, rangeproof.ErrTooManyTerms, err)
+	proof.Factors = factors
+	_, err = proof.ExtractStructure(1, g)
+	assert.Equal(t, rangeproof.ErrTooManyTerms, err)
+}
+
+func TestStatementEncoding(t *testing.T) {
+	g := setupPubkey(t)
+
+	m := big.NewInt(112)
+	mRandomizer, err := common.RandomBigInt(g.Params.Lm + g.Params.Lh + g.Params.Lstatzk)
+	require.NoError(t, err)
+
+	statement, err := rangeproof.NewStatement(rangeproof.GreaterOrEqual, big.NewInt(45))
+	require.NoError(t, err)
+	splitters := []rangeproof.SquareSplitter{
+		nil,
+		&rangeproof.FourSquaresSplitter{},
+		&rangeproof.ThreeSquaresSplitter{},
+	}
+	for _, splitter := range splitters {
+		statement.Splitter = splitter
+
+		bts, err := json.Marshal(statement)
+		require.NoError(t, err)
+		var fromJSON rangeproof.Statement
+		require.NoError(t, json.Unmarshal(bts, &fromJSON))
+		bts, err = cbor.Marshal(statement, cbor.EncOptions{})
+		require.NoError(t, err)
+		var fromCBOR rangeproof.Statement
+		require.NoError(t, cbor.Unmarshal(bts, &fromCBOR))
+		require.Equal(t, fromJSON, fromCBOR)
+
+		// A proof of the decoded statement has exactly the structure of the original statement
+		s, err := fromJSON.ProofStructure(1)
+		require.NoError(t, err)
+		_, commit, err := s.CommitmentsFromSecrets(g, m, mRandomizer)
+		require.NoError(t, err)
+		proof := s.BuildProof(commit, big.NewInt(1234567))
+		assert.True(t, proof.Proves(statement))
+		assert.True(t, proof.ProvesExactly(statement))
+
+		// A proof of a statement implying it is not accepted
+		proof.K.Add(proof.K, big.NewInt(1))
+		assert.True(t, proof.Proves(statement))
+		assert.False(t, proof.ProvesExactly(statement))
+		proof.K.Sub(proof.K, big.NewInt(1))
+		proof.Ld++
+		if splitter != nil && splitter.Ld() == 0 {
+			// Ld is derived from the attribute size, so it is not compared
+			assert.True(t, proof.ProvesExactly(statement))
+		} else {
+			assert.False(t, proof.ProvesExactly(statement))
+		}
+	}
+
+	// Statements with other signs, factors and splitter parameters round-trip
+	for _, original := range []*rangeproof.Statement{
+		{Sign: -1, Factor: 1, Bound: big.NewInt(0)},
+		{Sign: 1, Factor: 7, Bound: big.NewInt(1000), Splitter: &rangeproof.ThreeSquaresSplitter{Bits: 140}},
+		{Sign: -1, Factor: 3, Bound: new(big.Int).Lsh(big.NewInt(1), 300), Splitter: &rangeproof.FourSquaresSplitter{}},
+	} {
+		bts, err := json.Marshal(original)
+		require.NoError(t, err)
+		var fromJSON rangeproof.Statement
+		require.NoError(t, json.Unmarshal(bts, &fromJSON))
+		assert.Equal(t, *original, fromJSON)
+		bts, err = cbor.Marshal(original, cbor.EncOptions{})
+		require.NoError(t, err)
+		var fromCBOR rangeproof.Statement
+		require.NoError(t, cbor.Unmarshal(bts, &fromCBOR))
+		assert.Equal(t, *original, fromCBOR)
+	}
+
+	// Statements that cannot be encoded cannot be decoded either
+	bound := big.NewInt(45)
+	for _, invalid := range []map[string]interface{}{
+		{"sign": 1, "factor": 1, "bound": bound, "splitter": map[string]interface{}{"kind": "unknown"}},
+		{"sign": 1, "factor": 1, "bound": bound, "splitter": map[string]interface{}{}},
+		{"sign": 2, "factor": 1, "bound": bound},
+		{"sign": 1, "factor": 0, "bound": bound},
+		{"sign": 1, "factor": 1},
+		{"sign": 1, "factor": 1, "bound": -45},
+	} {
+		bts, err := json.Marshal(invalid)
+		require.NoError(t, err)
+		decoded := rangeproof.Statement{Sign: 1}
+		assert.Error(t, json.Unmarshal(bts, &decoded))
+		assert.Equal(t, rangeproof.Statement{Sign: 1}, decoded)
+		bts, err = cbor.Marshal(invalid, cbor.EncOptions{})
+		require.NoError(t, err)
+		assert.Error(t, cbor.Unmarshal(bts, &decoded))
+		assert.Equal(t, rangeproof.Statement{Sign: 1}, decoded)
+	}
+	for _, invalid := range []*rangeproof.Statement{
+		{Sign: 1, Factor: 1, Bound: big.NewInt(-45)},
+		{Sign: 1, Factor: 0, Bound: bound},
+		{Sign: 0, Factor: 1, Bound: bound},
+		{Sign: 1, Factor: 1},
+		{Sign: 1, Factor: 1, Bound: bound, Splitter: &bruteForce4{}},
+		{Sign: 1, Factor: 1, Bound: bound, Splitter: rangeproof.GenerateSquaresTable(100)},
+	} {
+		_, err = json.Marshal(invalid)
+		assert.Error(t, err)
+		_, err = cbor.Marshal(invalid, cbor.EncOptions{})
+		assert.Error(t, err)
+	}
+}
+
+func TestDateStatements(t *testing.T) {
+	g := setupPubkey(t)
+	mRandomizer, err := common.RandomBigInt(g.Params.Lm + g.Params.Lh + g.Params.Lstatzk)
+	require.NoError(t, err)
+
+	days := rangeproof.ElapsedDateEncoding{Unit: 24 * time.Hour}
+	now := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	prove := func(statement *rangeproof.Statement, date time.Time) (*rangeproof.Proof, error) {
+		m, err := days.EncodeDate(date)
+		require.NoError(t, err)
+		s, err := statement.ProofStructure(1)
+		require.NoError(t, err)
+		_, commit, err := s.CommitmentsFromSecrets(g, m, mRandomizer)
+		if err != nil {
+			return nil, err
+		}
+		return s.BuildProof(commit, big.NewInt(1234567)), nil
+	}
+
+	// Minimum age, which is inclusive
+	statement, err := rangeproof.NewMinimumAgeStatement(days, 18, now)
+	require.NoError(t, err)
+	proof, err := prove(statement, time.Date(2008, 10, 17, 0, 0, 0, 0, time.UTC))
+	require.NoError(t, err)
+	assert.True(t, proof.ProvesMinimumAge(days, 18, now))
+	assert.True(t, proof.ProvesMinimumAge(days, 18, now.AddDate(0, 0, 1)))
+	assert.False(t, proof.ProvesMinimumAge(days, 18, now.AddDate(0, 0, -1)))
+	assert.False(t, proof.ProvesMinimumAge(days, 21, now))
+	assert.False(t, proof.ProvesNotExpired(days, now))
+	// Provers whose clock is behind prove a stronger statement, those whose clock is ahead do not
+	behind, err := prove(mustMinimumAgeStatement(t, days, 18, now.AddDate(0, 0, -1)), time.Date(2008, 10, 16, 0, 0, 0, 0, time.UTC))
+	require.NoError(t, err)
+	assert.True(t, behind.ProvesMinimumAge(days, 18, now))
+	ahead, err := prove(mustMinimumAgeStatement(t, days, 18, now.AddDate(0, 0, 1)), time.Date(2008, 10, 17, 0, 0, 0, 0, time.UTC))
+	require.NoError(t, err)
+	assert.False(t, ahead.ProvesMinimumAge(days, 18, now))
+	_, err = prove(statement, time.Date(2008, 10, 18, 0, 0, 0, 0, time.UTC))
+	assert.Equal(t, rangeproof.ErrFalseStatement, err)
+
+	// Ages beyond the Unix epoch
+	statement, err = rangeproof.NewMinimumAgeStatement(days, 65, now)
+	require.NoError(t, err)
+	proof, err = prove(statement, time.Date(1961, 10, 17, 0, 0, 0, 0, time.UTC))
+	require.NoError(t, err)
+	assert.True(t, proof.ProvesMinimumAge(days, 65, now))
+	_, err = prove(statement, time.Date(1961, 10, 18, 0, 0, 0, 0, time.UTC))
+	assert.Equal(t, rangeproof.ErrFalseStatement, err)
+
+	// On February 29, those born on February 28 of a non-leap year come of age
+	leapDay := time.Date(2028, 2, 29, 12, 0, 0, 0, time.UTC)
+	statement, err = rangeproof.NewMinimumAgeStatement(days, 18, leapDay)
+	require.NoError(t, err)
+	proof, err = prove(statement, time.Date(2010, 2, 28, 0, 0, 0, 0, time.UTC))
+	require.NoError(t, err)
+	assert.True(t, proof.ProvesMinimumAge(days, 18, leapDay))
+	assert.False(t, proof.ProvesMinimumAge(days, 18, leapDay.AddDate(0, 0, -2)))
+	_, err = prove(statement, time.Date(2010, 3, 1, 0, 0, 0, 0, time.UTC))
+	assert.Equal(t, rangeproof.ErrFalseStatement, err)
+	statement, err = rangeproof.NewMinimumAgeStatement(days, 16, leapDay)
+	require.NoError(t, err)
+	proof, err = prove(statement, time.Date(2012, 2, 29, 0, 0, 0, 0, time.UTC))
+	require.NoError(t, err)
+	assert.True(t, proof.ProvesMinimumAge(days, 16, leapDay))
+	assert.False(t, proof.ProvesMinimumAge(days, 16, leapDay.AddDate(0, 0, -1)))
+
+	// Expiry, which is exclusive
+	statement, err = rangeproof.NewNotExpiredStatement(days, now)
+	require.NoError(t, err)
+	proof, err = prove(statement, now.AddDate(0, 0, 1))
+	require.NoError(t, err)
+	assert.True(t, proof.ProvesNotExpired(days, now))
+	assert.True(t, proof.ProvesNotExpired(days, now.AddDate(0, 0, -1)))
+	assert.False(t, proof.ProvesNotExpired(days, now.AddDate(0, 0, 1)))
+	assert.False(t, proof.ProvesMinimumAge(days, 18, now))
+	_, err = prove(statement, now)
+	assert.Equal(t, rangeproof.ErrFalseStatement, err)
+
+	// Before and after
+	statement, err = rangeproof.NewDateStatement(days, rangeproof.Before, now)
+	require.NoError(t, err)
+	_, err = prove(statement, now)
+	assert.Equal(t, rangeproof.ErrFalseStatement, err)
+	proof, err = prove(statement, now.AddDate(0, 0, -1))
+	require.NoError(t, err)
+	assert.True(t, proof.ProvesDate(days, rangeproof.Before, now))
+	assert.True(t, proof.ProvesDate(days, rangeproof.BeforeOrAt, now))
+	assert.False(t, proof.ProvesDate(days, rangeproof.Before, now.AddDate(0, 0, -1)))
+	statement, err = rangeproof.NewDateStatement(days, rangeproof.AfterOrAt, now)
+	require.NoError(t, err)
+	proof, err = prove(statement, now)
+	require.NoError(t, err)
+	assert.True(t, proof.ProvesDate(days, rangeproof.AfterOrAt, now))
+	assert.False(t, proof.ProvesDate(days, rangeproof.After, now))
+
+	// Encodings
+	m, err := rangeproof.DecimalDateEncoding{}.EncodeDate(now)
+	require.NoError(t, err)
+	assert.Equal(t, big.NewInt(20261017), m)
+	unix := rangeproof.ElapsedDateEncoding{Epoch: time.Unix(0, 0)}
+	m, err = unix.EncodeDate(now)
+	require.NoError(t, err)
+	assert.Equal(t, big.NewInt(now.Unix()), m)
+	_, err = unix.EncodeDate(time.Date(1960, 1, 1, 0, 0, 0, 0, time.UTC))
+	assert.Equal(t, rangeproof.ErrDateOutOfRange, err)
+	m, err = days.EncodeDate(time.Date(1, 1, 2, 0, 0, 0, 0, time.UTC))
+	require.NoError(t, err)
+	assert.Equal(t, big.NewInt(1), m)
+	m1960, err := days.EncodeDate(time.Date(1960, 1, 1, 0, 0, 0, 0, time.UTC))
+	require.NoError(t, err)
+	m1970, err := days.EncodeDate(time.Unix(0, 0))
+	require.NoError(t, err)
+	assert.Equal(t, big.NewInt(3653), new(big.Int).Sub(m1970, m1960))
+
+	// Encoding does not depend on the location: shortly after local midnight east of UTC is
+	// still the previous day in UTC
+	local := time.Date(2026, 10, 17, 1, 0, 0, 0, time.FixedZone("UTC+2", 2*60*60))
+	mLocal, err := days.EncodeDate(local)
+	require.NoError(t, err)
+	mUTC, err := days.EncodeDate(local.UTC())
+	require.NoError(t, err)
+	assert.Equal(t, mUTC, mLocal)
+	mToday, err := days.EncodeDate(time.Date(2026, 10, 17, 0, 0, 0, 0, time.UTC))
+	require.NoError(t, err)
+	assert.Equal(t, new(big.Int).Sub(mToday, big.NewInt(1)), mLocal)
+	_, err = rangeproof.ElapsedDateEncoding{Unit: time.Millisecond}.EncodeDate(now)
+	assert.Error(t, err)
+}
+
+func mustMinimumAgeStatement(t *testing.T, encoding rangeproof.DateEncoding, years int, now time.Time) *rangeproof.Statement {
+	statement, err := rangeproof.NewMinimumAgeStatement(encoding, years, now)
+	require.NoError(t, err)
+	return statement
+}
@@ -0,0 +1,485 @@
+package rangeproof
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/privacybydesign/gabi/big"
+	"github.com/privacybydesign/gabi/gabikeys"
+	"github.com/privacybydesign/gabi/internal/common"
+	"github.com/privacybydesign/gabi/zkproof"
+
+	"github.com/go-errors/errors"
+)
+
+/*
+Set-membership proofs allow clients to prove that an attribute m is an element of a public set
+{s_0, ..., s_(k-1)}, i.e., that the product of the differences d_j = m - s_j is zero. Using the same
+group and bases R and S as the range proofs above, this is done by building a chain of commitments
+
+    C_0 = R^(d_0) S^(w_0)
+    C_j = C_(j-1)^(d_j) S^(w_j)    for 0 < j < k
+
+so that C_(k-1) = R^(d_0 * ... * d_(k-1)) S^z for some z, and proving the following substatements:
+
+    C_0 R^(s_0) = R^m S^(w_0)
+    C_j C_(j-1)^(s_j) = C_(j-1)^m S^(w_j)    for 0 < j < k
+    C_(k-1) = S^z
+
+where m is the attribute value, the w_j are computational hiders and z is the exponent of S in
+C_(k-1). Note that m is the same secret in all of these, so that its response can be shared with the
+response of the attribute in the disclosure proof.
+
+Soundness follows along the same lines as for the range proofs: given the extracted values, we get
+R^(d_0 * ... * d_(k-1)) = S^b for some bounded b. If the product of the d_j is nonzero, then as
+above this allows us to factor n. Hence the product is zero, and m equals one of the s_j.
+
+In order to not leak information about m through the sign of z, the prover chooses the last hider
+w_(k-1) large enough that z is always positive.
+*/
+
+type (
+	// SetMembershipStatement states that an attribute m is an element of Set.
+	SetMembershipStatement struct {
+		Set []*big.Int
+	}
+
+	SetMembershipProofStructure struct {
+		index int
+		set   []*big.Int
+
+		chain []zkproof.QrRepresentationProofStructure
+		zRep  zkproof.QrRepresentationProofStructure
+	}
+
+	SetMembershipProof struct {
+		// Actual proof responses
+		Cs         []*big.Int `json:"Cs"`
+		WResponses []*big.Int `json:"ws"`
+		ZResponse  *big.Int   `json:"z"`
+		MResponse  *big.Int   `json:"-"`
+
+		// Proof structure description
+		Set []*big.Int `json:"set"`
+	}
+
+	SetMembershipProofCommit struct {
+		// Bases
+		c []*big.Int
+
+		// Secrets
+		w            []*big.Int
+		wRandomizers []*big.Int
+		z            *big.Int
+		zRandomizer  *big.Int
+		m            *big.Int
+		mRandomizer  *big.Int
+	}
+
+	setMembershipProof       SetMembershipProof
+	setMembershipProofCommit SetMembershipProofCommit
+)
+
+// MaxSetSize is the maximum amount of elements of the set of a set-membership proof. The work of
+// the verifier and the size of the proof grow linearly with the size of the set, and the set of a
+// proof is chosen by the prover, so it must be bounded before the proof is verified.
+const MaxSetSize = 256
+
+var (
+	ErrEmptySet         = errors.New("set must contain at least one element")
+	ErrSetTooLarge      = errors.New("set contains too many elements")
+	ErrInvalidSetMember = errors.New("set elements must be nonnegative")
+)
+
+// NewSetMembershipStatement returns a statement that an attribute is an element of the given set.
+func NewSetMembershipStatement(set []*big.Int) (*SetMembershipStatement, error) {
+	if len(set) == 0 {
+		return nil, ErrEmptySet
+	}
+	if len(set) > MaxSetSize {
+		return nil, ErrSetTooLarge
+	}
+	s := make([]*big.Int, len(set))
+	for i, v := range set {
+		if v == nil || v.Sign() < 0 {
+			return nil, ErrInvalidSetMember
+		}
+		s[i] = new(big.Int).Set(v)
+	}
+	return &SetMembershipStatement{Set: s}, nil
+}
+
+func (statement *SetMembershipStatement) ProofStructure(index int) (*SetMembershipProofStructure, error) {
+	return NewSetMembershipProofStructure(index, statement.Set)
+}
+
+// Create a new proof structure for proving that the attribute at the given index is an element of
+// the given set.
+func NewSetMembershipProofStructure(index int, set []*big.Int) (*SetMembershipProofStructure, error) {
+	if len(set) == 0 {
+		return nil, ErrEmptySet
+	}
+	if len(set) > MaxSetSize {
+		return nil, ErrSetTooLarge
+	}
+
+	result := &SetMembershipProofStructure{
+		index: index,
+		set:   make([]*big.Int, len(set)),
+	}
+
+	prev := fmt.Sprintf("R%d", index)
+	for j, s := range set {
+		if s == nil || s.Sign() < 0 {
+			return nil, ErrInvalidSetMember
+		}
+		result.set[j] = new(big.Int).Set(s)
+		result.chain = append(result.chain, zkproof.QrRepresentationProofStructure{
+			Lhs: []zkproof.LhsContribution{
+				{Base: fmt.Sprintf("C%d", j), Power: big.NewInt(1)},
+				{Base: prev, Power: result.set[j]},
+			},
+			Rhs: []zkproof.RhsContribution{
+				{Base: prev, Secret: "m", Power: 1},
+				{Base: "S", Secret: fmt.Sprintf("w%d", j), Power: 1},
+			},
+		})
+		prev = fmt.Sprintf("C%d", j)
+	}
+
+	result.zRep = zkproof.QrRepresentationProofStructure{
+		Lhs: []zkproof.LhsContribution{
+			{Base: prev, Power: big.NewInt(1)},
+		},
+		Rhs: []zkproof.RhsContribution{
+			{Base: "S", Secret: "z", Power: 1},
+		},
+	}
+
+	return result, nil
+}
+
+// lz returns the maximum bitsize of z. As all d_j are smaller than 2^lm in absolute value, by
+// induction the exponent of S in C_j is smaller than 2^(lm+j*(lm+1)) in absolute value.
+func (s *SetMembershipProofStructure) lz(g *gabikeys.PublicKey) uint {
+	return uint(len(s.set))*(g.Params.Lm+1) + 1
+}
+
+func (s *SetMembershipProofStructure) CommitmentsFromSecrets(g *gabikeys.PublicKey, m, mRandomizer *big.Int) ([]*big.Int, *SetMembershipProofCommit, error) {
+	var err error
+
+	if m.Sign() < 0 || uint(m.BitLen()) > g.Params.Lm {
+		return nil, nil, ErrFalseStatement
+	}
+	member := false
+	for _, v := range s.set {
+		if uint(v.BitLen()) > g.Params.Lm {
+			return nil, nil, ErrInvalidSetMember
+		}
+		if v.Cmp(m) == 0 {
+			member = true
+		}
+	}
+	if !member {
+		return nil, nil, ErrFalseStatement
+	}
+
+	k := len(s.set)
+	lz := s.lz(g)
+	commit := &setMembershipProofCommit{
+		c:            make([]*big.Int, k),
+		w:            make([]*big.Int, k),
+		wRandomizers: make([]*big.Int, k),
+		z:            big.NewInt(0),
+		m:            m,
+		mRandomizer:  mRandomizer,
+	}
+
+	// Generate the hiders w_j and their randomizers. The last one is taken from [2^(lz-2), 2^(lz-1))
+	// so that it exceeds the contribution of the previous hiders to z, making z positive.
+	for j := 0; j < k; j++ {
+		if j < k-1 || k == 1 {
+			commit.w[j], err = common.RandomBigInt(g.Params.Lm)
+		} else {
+			commit.w[j], err = common.RandomBigInt(lz - 2)
+			commit.w[j].Add(commit.w[j], new(big.Int).Lsh(big.NewInt(1), lz-2))
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		l := g.Params.Lm
+		if j == k-1 {
+			l = lz
+		}
+		commit.wRandomizers[j], err = common.RandomBigInt(l + g.Params.Lh + g.Params.Lstatzk)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// Calculate the bases and z
+	prev := g.R[s.index]
+	for j := 0; j < k; j++ {
+		d := new(big.Int).Sub(m, s.set[j])
+		commit.c[j], err = common.ModPow(prev, d, g.N)
+		if err != nil {
+			return nil, nil, err
+		}
+		commit.c[j].Mul(commit.c[j], new(big.Int).Exp(g.S, commit.w[j], g.N))
+		commit.c[j].Mod(commit.c[j], g.N)
+		prev = commit.c[j]
+
+		commit.z.Mul(commit.z, d).Add(commit.z, commit.w[j])
+	}
+	commit.zRandomizer, err = common.RandomBigInt(lz + g.Params.Lh + g.Params.Lstatzk)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	bases := zkproof.NewBaseMerge(g, commit)
+
+	contributions := make([]*big.Int, 0, 2*k+1)
+	for _, c := range commit.c {
+		contributions = append(contributions, new(big.Int).Set(c))
+	}
+	for j := range s.chain {
+		contributions = s.chain[j].CommitmentsFromSecrets(g, contributions, &bases, commit)
+	}
+	contributions = s.zRep.CommitmentsFromSecrets(g, contributions, &bases, commit)
+
+	return contributions, (*SetMembershipProofCommit)(commit), nil
+}
+
+func (s *SetMembershipProofStructure) BuildProof(commit *SetMembershipProofCommit, challenge *big.Int) *SetMembershipProof {
+	result := &SetMembershipProof{
+		Cs:         make([]*big.Int, len(commit.c)),
+		WResponses: make([]*big.Int, len(commit.w)),
+		ZResponse:  new(big.Int).Add(new(big.Int).Mul(challenge, commit.z), commit.zRandomizer),
+		MResponse:  new(big.Int).Add(new(big.Int).Mul(challenge, commit.m), commit.mRandomizer),
+
+		Set: make([]*big.Int, len(s.set)),
+	}
+
+	for i := range commit.c {
+		result.Cs[i] = new(big.Int).Set(commit.c[i])
+	}
+	for i := range commit.w {
+		result.WResponses[i] = new(big.Int).Add(new(big.Int).Mul(challenge, commit.w[i]), commit.wRandomizers[i])
+	}
+	for i := range s.set {
+		result.Set[i] = new(big.Int).Set(s.set[i])
+	}
+
+	return result
+}
+
+func (s *SetMembershipProofStructure) VerifyProofStructure(g *gabikeys.PublicKey, p *SetMembershipProof) bool {
+	if len(s.chain) != len(p.Cs) || len(s.chain) != len(p.WResponses) {
+		return false
+	}
+
+	if p.ZResponse == nil || p.MResponse == nil {
+		return false
+	}
+
+	lz := s.lz(g)
+	if uint(p.ZResponse.BitLen()) > lz+g.Params.Lh+g.Params.Lstatzk+1 ||
+		uint(p.MResponse.BitLen()) > g.Params.Lm+g.Params.Lh+g.Params.Lstatzk+1 {
+		return false
+	}
+
+	for i := range s.chain {
+		if p.Cs[i] == nil || p.WResponses[i] == nil {
+			return false
+		}
+
+		l := g.Params.Lm
+		if i == len(s.chain)-1 {
+			l = lz
+		}
+		if p.Cs[i].BitLen() > g.N.BitLen() ||
+			uint(p.WResponses[i].BitLen()) > l+g.Params.Lh+g.Params.Lstatzk+1 {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (s *SetMembershipProofStructure) CommitmentsFromProof(g *gabikeys.PublicKey, p *SetMembershipProof, challenge *big.Int) []*big.Int {
+	bases := zkproof.NewBaseMerge(g, (*setMembershipProof)(p))
+
+	contributions := make([]*big.Int, 0, 2*len(p.Cs)+1)
+	for _, c := range p.Cs {
+		contributions = append(contributions, new(big.Int).Set(c))
+	}
+	for j := range s.chain {
+		contributions = s.chain[j].CommitmentsFromProof(g, contributions, challenge, &bases, (*setMembershipProof)(p))
+	}
+	contributions = s.zRep.CommitmentsFromProof(g, contributions, challenge, &bases, (*setMembershipProof)(p))
+
+	return contributions
+}
+
+// Proves returns whether the SetMembershipProof proves the specified statement, that is, whether
+// the set of the proof and of the statement contain the same elements (in any order).
+//
+// NB: this method does not verify the proof.
+func (p *SetMembershipProof) Proves(statement *SetMembershipStatement) bool {
+	if len(p.Set) != len(statement.Set) {
+		return false
+	}
+	ours, theirs := sortedSet(p.Set), sortedSet(statement.Set)
+	if ours == nil || theirs == nil {
+		return false
+	}
+	for i := range ours {
+		if ours[i].Cmp(theirs[i]) != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Extract proof structure from proof
+func (p *SetMembershipProof) ExtractStructure(index int, g *gabikeys.PublicKey) (*SetMembershipProofStructure, error) {
+	if len(p.Set) > MaxSetSize {
+		return nil, ErrSetTooLarge
+	}
+	// Set elements larger than lm are never reasonable since attributes (or their hashes) are at
+	// most lm bits
+	for _, v := range p.Set {
+		if v != nil && uint(v.BitLen()) > g.Params.Lm {
+			return nil, errors.New("invalid proof")
+		}
+	}
+	return NewSetMembershipProofStructure(index, p.Set)
+}
+
+func sortedSet(set []*big.Int) []*big.Int {
+	result := make([]*big.Int, len(set))
+	for i, v := range set {
+		if v == nil {
+			return nil
+		}
+		result[i] = v
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Cmp(result[j]) < 0 })
+	return result
+}
+
+// ---
+// Commit structure keyproof interfaces
+// ---
+func (c *setMembershipProofCommit) Secret(name string) *big.Int {
+	if name == "m" {
+		return c.m
+	}
+	if name == "z" {
+		return c.z
+	}
+	if name[0] == 'w' {
+		i, err := strconv.Atoi(name[1:])
+		if err != nil || i < 0 || i >= len(c.w) {
+			return nil
+		}
+		return c.w[i]
+	}
+	return nil
+}
+
+func (c *setMembershipProofCommit) Randomizer(name string) *big.Int {
+	if name == "m" {
+		return c.mRandomizer
+	}
+	if name == "z" {
+		return c.zRandomizer
+	}
+	if name[0] == 'w' {
+		i, err := strconv.Atoi(name[1:])
+		if err != nil || i < 0 || i >= len(c.wRandomizers) {
+			return nil
+		}
+		return c.wRandomizers[i]
+	}
+	return nil
+}
+
+func (c *setMembershipProofCommit) Base(name string) *big.Int {
+	if name[0] == 'C' {
+		i, err := strconv.Atoi(name[1:])
+		if err != nil || i < 0 || i >= len(c.c) {
+			return nil
+		}
+		return c.c[i]
+	}
+	return nil
+}
+
+func (c *setMembershipProofCommit) Exp(ret *big.Int, name string, exp, n *big.Int) bool {
+	base := c.Base(name)
+	if base == nil {
+		return false
+	}
+	ret.Exp(base, exp, n)
+	return true
+}
+
+func (c *setMembershipProofCommit) Names() []string {
+	result := make([]string, 0, len(c.c))
+	for i := range c.c {
+		result = append(result, fmt.Sprintf("C%d", i))
+	}
+
+	return result
+}
+
+// ---
+// Proof structure keyproof interfaces
+// ---
+func (p *setMembershipProof) ProofResult(name string) *big.Int {
+	if name == "m" {
+		return p.MResponse
+	}
+	if name == "z" {
+		return p.ZResponse
+	}
+	if name[0] == 'w' {
+		i, err := strconv.Atoi(name[1:])
+		if err != nil || i < 0 || i >= len(p.WResponses) {
+			return nil
+		}
+		return p.WResponses[i]
+	}
+	return nil
+}
+
+func (p *setMembershipProof) Base(name string) *big.Int {
+	if name[0] == 'C' {
+		i, err := strconv.Atoi(name[1:])
+		if err != nil || i < 0 || i >= len(p.Cs) {
+			return nil
+		}
+		return p.Cs[i]
+	}
+	return nil
+}
+
+func (p *setMembershipProof) Exp(ret *big.Int, name string, exp, n *big.Int) bool {
+	base := p.Base(name)
+	if base == nil {
+		return false
+	}
+	ret.Exp(base, exp, n)
+	return true
+}
+
+func (p *setMembershipProof) Names() []string {
+	result := make([]string, 0, len(p.Cs))
+	for i := range p.Cs {
+		result = append(result, fmt.Sprintf("C%d", i))
+	}
+
+	return result
+}
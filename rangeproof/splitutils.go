@@ -10,7 +10,8 @@ import (
 type (
 	// SquareSplitter provides a combined interface for all facets describing a method for spliting positive numbers into a sum of squares.
 	SquareSplitter interface {
-		// Number of bits per square
+		// Number of bits per square, or 0 if it depends on the attribute size and statement (see
+		// ThreeSquaresSplitter)
 		Ld() uint
 		// Number of squares in result
 		SquareCount() int
@@ -21,6 +22,17 @@ type (
 	SquaresTable [][]int64
 
 	FourSquaresSplitter struct{}
+
+	// ThreeSquaresSplitter splits numbers of arbitrary size into three squares, using a
+	// randomized algorithm. Numbers of the form 4^a(8b+7) cannot be written as a sum of three
+	// squares; NewProofStructure avoids these by adjusting the factor and bound of the statement
+	// such that all deltas are 2 (mod 4).
+	ThreeSquaresSplitter struct {
+		// Maximum number of bits of the squares, so that deltas of up to twice as many bits can
+		// be split. When zero, it is derived from the attribute size Lm of the public key and
+		// the factor and bound of the statement, such that all deltas can be split.
+		Bits uint
+	}
 )
 
 // Generate lookup table for splitting numbers into 3 squares containing entries up-to and including limit
@@ -88,3 +100,19 @@ func (_ *FourSquaresSplitter) SquareCount() int {
 func (_ *FourSquaresSplitter) Ld() uint {
 	return 128
 }
+
+func (_ *ThreeSquaresSplitter) Split(delta *big.Int) ([]*big.Int, error) {
+	a, b, c, err := common.SumThreeSquares(delta)
+	if err != nil {
+		return nil, err
+	}
+	return []*big.Int{a, b, c}, nil
+}
+
+func (_ *ThreeSquaresSplitter) SquareCount() int {
+	return 3
+}
+
+func (s *ThreeSquaresSplitter) Ld() uint {
+	return s.Bits
+}
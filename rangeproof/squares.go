@@ -0,0 +1,277 @@
+package rangeproof
+
+import (
+	"fmt"
+
+	"github.com/privacybydesign/gabi/big"
+	"github.com/privacybydesign/gabi/gabikeys"
+	"github.com/privacybydesign/gabi/internal/common"
+	"github.com/privacybydesign/gabi/zkproof"
+
+	"github.com/go-errors/errors"
+)
+
+// Range proofs and linear proofs both prove that a linear expression delta in one or more
+// attributes is nonnegative, by writing it as a sum of squares d_i and proving the substatements
+//
+//    C_i = R^(d_i) S^(v_i)
+//    R^(lhs) = S^(-v5) (R^(m terms)) \product_i C_i^(d_i)
+//
+// (see proof.go and linear.go). The parts of their structures, commits and proofs concerning the
+// squares are shared between them using the types and functions below.
+
+type (
+	// squaresStructure contains the representation proof structures of a range or linear proof.
+	squaresStructure struct {
+		cRep     []zkproof.QrRepresentationProofStructure
+		mCorrect zkproof.QrRepresentationProofStructure
+	}
+
+	// squaresCommit contains the squares d_i, their hiders v_i and v5, their randomizers, and the
+	// commitments C_i to the squares.
+	squaresCommit struct {
+		// Bases
+		c []*big.Int
+
+		// Secrets
+		d            []*big.Int
+		dRandomizers []*big.Int
+		v            []*big.Int
+		vRandomizers []*big.Int
+		v5           *big.Int
+		v5Randomizer *big.Int
+	}
+
+	squaresSecrets interface {
+		zkproof.BaseLookup
+		zkproof.SecretLookup
+	}
+
+	squaresResults interface {
+		zkproof.BaseLookup
+		zkproof.ProofLookup
+	}
+)
+
+// newSquaresStructure returns the structure of a proof using count squares, committed to using the
+// base of the attribute at the specified index, with the specified power of that base in the left
+// hand side, and the specified contributions of the attributes in the right hand side.
+func newSquaresStructure(index int, lhs *big.Int, terms []zkproof.RhsContribution, count int) squaresStructure {
+	r := fmt.Sprintf("R%d", index)
+	result := squaresStructure{
+		mCorrect: zkproof.QrRepresentationProofStructure{
+			Lhs: []zkproof.LhsContribution{
+				{Base: r, Power: lhs},
+			},
+			Rhs: append([]zkproof.RhsContribution{
+				{Base: "S", Secret: "v5", Power: -1},
+			}, terms...),
+		},
+	}
+
+	for i := 0; i < count; i++ {
+		result.cRep = append(result.cRep, zkproof.QrRepresentationProofStructure{
+			Lhs: []zkproof.LhsContribution{
+				{Base: fmt.Sprintf("C%d", i), Power: big.NewInt(1)},
+			},
+			Rhs: []zkproof.RhsContribution{
+				{Base: r, Secret: fmt.Sprintf("d%d", i), Power: 1},
+				{Base: "S", Secret: fmt.Sprintf("v%d", i), Power: 1},
+			},
+		})
+
+		result.mCorrect.Rhs = append(result.mCorrect.Rhs, zkproof.RhsContribution{
+			Base:   fmt.Sprintf("C%d", i),
+			Secret: fmt.Sprintf("d%d", i),
+			Power:  1,
+		})
+	}
+
+	return result
+}
+
+// newSquaresCommit generates the hiders and randomizers of the specified squares of at most ld
+// bits, and commits to them using the base of the attribute at the specified index.
+func newSquaresCommit(g *gabikeys.PublicKey, index int, d []*big.Int, ld uint) (squaresCommit, error) {
+	var err error
+	commit := squaresCommit{d: d}
+
+	// Check d values and generate randomizers for them
+	commit.dRandomizers = make([]*big.Int, len(commit.d))
+	for i, v := range commit.d {
+		if uint(v.BitLen()) > ld {
+			return commit, errors.New("split function returned oversized d")
+		}
+		commit.dRandomizers[i], err = common.RandomBigInt(ld + g.Params.Lh + g.Params.Lstatzk)
+		if err != nil {
+			return commit, err
+		}
+	}
+
+	// Generate v and vRandomizers
+	commit.v = make([]*big.Int, len(commit.d))
+	commit.vRandomizers = make([]*big.Int, len(commit.d))
+	for i := range commit.d {
+		commit.v[i], err = common.RandomBigInt(g.Params.Lm)
+		if err != nil {
+			return commit, err
+		}
+		commit.vRandomizers[i], err = common.RandomBigInt(g.Params.Lm + g.Params.Lh + g.Params.Lstatzk)
+		if err != nil {
+			return commit, err
+		}
+	}
+
+	// Generate v5 and its randomizer
+	commit.v5 = big.NewInt(0)
+	for i := range commit.d {
+		commit.v5.Add(commit.v5, new(big.Int).Mul(commit.d[i], commit.v[i]))
+	}
+	commit.v5Randomizer, err = common.RandomBigInt(g.Params.Lm + ld + 2 + g.Params.Lh + g.Params.Lstatzk)
+	if err != nil {
+		return commit, err
+	}
+
+	// Calculate the bases
+	commit.c = make([]*big.Int, len(commit.d))
+	for i := range commit.d {
+		commit.c[i] = new(big.Int).Exp(g.R[index], commit.d[i], g.N)
+		commit.c[i].Mul(commit.c[i], new(big.Int).Exp(g.S, commit.v[i], g.N))
+		commit.c[i].Mod(commit.c[i], g.N)
+	}
+
+	return commit, nil
+}
+
+func (s *squaresStructure) commitmentsFromSecrets(g *gabikeys.PublicKey, commit squaresSecrets) []*big.Int {
+	bases := zkproof.NewBaseMerge(g, commit)
+
+	var contributions []*big.Int
+	contributions = s.mCorrect.CommitmentsFromSecrets(g, contributions, &bases, commit)
+	for i := range s.cRep {
+		contributions = s.cRep[i].CommitmentsFromSecrets(g, contributions, &bases, commit)
+	}
+
+	return contributions
+}
+
+func (s *squaresStructure) commitmentsFromProof(g *gabikeys.PublicKey, p squaresResults, challenge *big.Int) []*big.Int {
+	bases := zkproof.NewBaseMerge(g, p)
+
+	var contributions []*big.Int
+	contributions = s.mCorrect.CommitmentsFromProof(g, contributions, challenge, &bases, p)
+	for i := range s.cRep {
+		contributions = s.cRep[i].CommitmentsFromProof(g, contributions, challenge, &bases, p)
+	}
+
+	return contributions
+}
+
+// verifyResponses checks the number and sizes of the commitments and responses of the squares,
+// given squares of at most ld bits.
+func (s *squaresStructure) verifyResponses(g *gabikeys.PublicKey, ld uint, cs, ds, vs []*big.Int, v5 *big.Int) bool {
+	if len(s.cRep) != len(cs) || len(s.cRep) != len(ds) || len(s.cRep) != len(vs) {
+		return false
+	}
+
+	l := g.Params.Lh + g.Params.Lstatzk + 1
+	if v5 == nil || uint(v5.BitLen()) > g.Params.Lm+ld+2+l {
+		return false
+	}
+
+	for i := range s.cRep {
+		if cs[i] == nil || ds[i] == nil || vs[i] == nil {
+			return false
+		}
+
+		if cs[i].BitLen() > g.N.BitLen() ||
+			uint(ds[i].BitLen()) > ld+l ||
+			uint(vs[i].BitLen()) > g.Params.Lm+l {
+			return false
+		}
+	}
+
+	return true
+}
+
+// responses computes the commitments and responses of the squares for the specified challenge.
+func (c *squaresCommit) responses(challenge *big.Int) (cs, ds, vs []*big.Int, v5 *big.Int) {
+	response := func(secret, randomizer *big.Int) *big.Int {
+		return new(big.Int).Add(new(big.Int).Mul(challenge, secret), randomizer)
+	}
+
+	cs = make([]*big.Int, len(c.c))
+	ds = make([]*big.Int, len(c.d))
+	vs = make([]*big.Int, len(c.v))
+	for i := range c.c {
+		cs[i] = new(big.Int).Set(c.c[i])
+	}
+	for i := range c.d {
+		ds[i] = response(c.d[i], c.dRandomizers[i])
+	}
+	for i := range c.v {
+		vs[i] = response(c.v[i], c.vRandomizers[i])
+	}
+	return cs, ds, vs, response(c.v5, c.v5Randomizer)
+}
+
+// ---
+// Commit structure keyproof interfaces
+// ---
+func (c *squaresCommit) secret(name string) *big.Int {
+	return squaresValue(name, c.d, c.v, c.v5)
+}
+
+func (c *squaresCommit) randomizer(name string) *big.Int {
+	return squaresValue(name, c.dRandomizers, c.vRandomizers, c.v5Randomizer)
+}
+
+func (c *squaresCommit) Base(name string) *big.Int {
+	return squaresBase(name, c.c)
+}
+
+func (c *squaresCommit) Exp(ret *big.Int, name string, exp, n *big.Int) bool {
+	return squaresExp(ret, c.Base(name), exp, n)
+}
+
+func (c *squaresCommit) Names() []string {
+	return squaresNames(len(c.c))
+}
+
+// squaresValue returns the value with the specified name of a square (d_i), a hider (v_i) or v5.
+func squaresValue(name string, d, v []*big.Int, v5 *big.Int) *big.Int {
+	if name == "v5" {
+		return v5
+	}
+	if i := indexedName(name, 'd'); i >= 0 && i < len(d) {
+		return d[i]
+	}
+	if i := indexedName(name, 'v'); i >= 0 && i < len(v) {
+		return v[i]
+	}
+	return nil
+}
+
+// squaresBase returns the commitment with the specified name C_i.
+func squaresBase(name string, c []*big.Int) *big.Int {
+	if i := indexedName(name, 'C'); i >= 0 && i < len(c) {
+		return c[i]
+	}
+	return nil
+}
+
+func squaresExp(ret, base, exp, n *big.Int) bool {
+	if base == nil {
+		return false
+	}
+	ret.Exp(base, exp, n)
+	return true
+}
+
+func squaresNames(count int) []string {
+	result := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		result = append(result, fmt.Sprintf("C%d", i))
+	}
+	return result
+}
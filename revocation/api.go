@@ -30,12 +30,17 @@ which the verifier concludes that the credential is not currently revoked.
       revoked nonrevocation attribute e.
 
 To keep track of previous and current accumulators, each Accumulator has an index which is
-incremented each time a credential is revoked and the accumulator changes value.
+incremented each time a credential is revoked and the accumulator changes value. Several
+credentials can also be revoked in a single step, and thus a single event, using RemoveMultiple,
+which exponentiates Nu with the inverse of the product of their nonrevocation attributes.
 
 Issuers supporting revocation use ECDSA private/public keys to sign the accumulator update messages.
 All IRMA participants (client, verifier, issuer) require the latest revocation record to be able
 to function. The client additionally needs to know the complete chain of all events to be able to
-update its witness to the latest accumulator.
+update its witness to the latest accumulator. Issuers can persist this chain in an
+EventStore, from which NewUpdateFromStore creates Updates containing all events since a given index.
+Clients that are far behind can instead be sent a Checkpoint, signed by the issuer, containing the
+product of the e's of all events they missed (see NewCheckpoint).
 
 Notes
 
@@ -46,6 +51,8 @@ value of the accumulator (for example, the client has not received all revocatio
 while the verifier has), then the client cannot prove nonrevocation, leading the verifier to reject
 the client. The issuer thus has an important responsibility to ensure that all its revocation
 broadcast messages are always available to all IRMA participants.
+Conversely, verifiers can use a VerificationPolicy to specify how old an accumulator they accept,
+and ask clients whose accumulator is stale to update their witness and retry.
 
 If one thinks of the accumulator as a "nonrevocation public key", then the witness can be thought of
 as a "nonrevocation signature" which verifies against that public key (either directly or in zero
@@ -55,11 +62,19 @@ accumulator is updated, has however no equivalent in signature schemes.)
 Unlike ours, accumulators generally have both an Add and Remove algorithm, adding or removing stuff
 from the accumulator. The RSA-B has the property that the Add algorithm does nothing, i.e. all
 revocation witnesses e are added to it "automatically", and only removing one from the accumulator
-actually constitutes work (and broadcasting update messages).
+actually constitutes work (and broadcasting update messages). Mistakenly revoked e's can however be
+added again using Reinstate, which raises Nu to the power e. Witnesses of other e's are then updated
+by raising u to the power e as well, while the new u of the reinstated e is the accumulator before
+reinstating, which is included in the event.
 
 In the literature the agent that is able to revoke (using a PrivateKey) is usually called the
-"revocation authority", which generally need not be the same agent as the issuer. In IRMA the design
-choice was made instead that the issuer is always the revocation authority.
+"revocation authority", which generally need not be the same agent as the issuer. By default the
+issuer is the revocation authority, using its own Idemix private key. Alternatively, revocation can
+be managed by a separate revocation authority having its own modulus and ECDSA key (see
+gabikeys.RevocationPrivateKey), so that it never holds the issuer private key. The issuer public key
+then references the public key of the revocation authority, which all functions in this package
+that accept an issuer public key use instead (see gabikeys.PublicKey.RevocationKey). The revocation
+authority passes its private key to this package using gabikeys.RevocationPrivateKey.PrivateKey().
 */
 package revocation
 
@@ -100,11 +115,22 @@ type (
 
 	// Event contains the data clients need to update to the Accumulator of the specified index,
 	// after it has been updated by the issuer by revoking. Forms a chain through the
-	// ParentHash which is the SHA256 hash of its parent.
+	// ParentHash which is the hash of its parent, using the hash algorithm of
+	// the accumulator (see Accumulator.HashAlgorithm). Events revoking a single attribute
+	// contain it in E; events revoking several attributes at once (see Accumulator.RemoveMultiple)
+	// contain them in Es instead. Events reinstating a previously revoked attribute E (see
+	// Accumulator.Reinstate) additionally contain the value Nu of the accumulator before
+	// reinstating, which is the new witness of the reinstated attribute.
+	//
+	// Es and Nu are not stored when storing events using their gorm tags, which only suffices for
+	// events revoking a single attribute. Events must therefore be persisted using an EventStore,
+	// such as FileEventStore, which stores them entirely.
 	Event struct {
-		Index      uint64   `json:"i" gorm:"primary_key;column:eventindex"`
-		E          *big.Int `json:"e"`
-		ParentHash Hash     `json"parenthash"`
+		Index      uint64     `json:"i" gorm:"primary_key;column:eventindex"`
+		E          *big.Int   `json:"e"`
+		Es         []*big.Int `json:"es,omitempty" gorm:"-"`
+		Nu         *big.Int   `json:"nu,omitempty" gorm:"-"`
+		ParentHash Hash       `json:"ParentHash"` // key of existing encodings, which lacked a valid tag
 	}
 
 	EventList struct {
@@ -123,13 +149,16 @@ type (
 	// The accumulator contains the hash of the first Event, and each Event has a hash of its parent.
 	// Thus the signature over the accumulator effectively signs the entire Update message,
 	// and the partial tree specified by Events is verifiable regardless of its length.
+	// Instead of the full chain of events, an Update may contain a Checkpoint followed by the
+	// events after it, if any.
 	Update struct {
 		SignedAccumulator *SignedAccumulator
+		Checkpoint        *SignedCheckpoint
 		Events            []*Event
 		product           *big.Int
 	}
 
-	// Hash represents a SHA256 hash and has marshaling methods to/from JSON.
+	// Hash represents a multihash, by default using SHA256, and has marshaling methods to/from JSON.
 	Hash multihash.Multihash
 
 	// Witness is a witness for the RSA-B accumulator, used for proving nonrevocation against the
@@ -150,19 +179,52 @@ type (
 )
 
 const (
+	// HashAlgorithm is the default multihash algorithm of event chains.
 	HashAlgorithm = multihash.SHA2_256
+	// HashAlgorithmSHA3_256 and HashAlgorithmBLAKE2b_256 are the other multihash algorithms
+	// supported in event chains, see NewAccumulatorUsingHashAlgorithm and
+	// Accumulator.MigrateHashAlgorithm.
+	HashAlgorithmSHA3_256    = multihash.SHA3_256
+	HashAlgorithmBLAKE2b_256 = multihash.BLAKE2B_MIN + 31
 )
 
+// Types of the messages signed with the issuer's ECDSA key, which are included in them so that
+// messages of one type cannot be passed off as another. Accumulators signed before the type was
+// introduced do not contain it.
+const (
+	accumulatorMessageType = "accumulator"
+	checkpointMessageType  = "checkpoint"
+)
+
+// accumulatorMessage is the message of a SignedAccumulator.
+type accumulatorMessage struct {
+	*Accumulator
+	Type string `json:"type,omitempty"`
+}
+
 var Logger *logrus.Logger
 
 func NewAccumulator(sk *gabikeys.PrivateKey) (*Update, error) {
+	return NewAccumulatorUsingHashAlgorithm(sk, HashAlgorithm)
+}
+
+// NewAccumulatorUsingHashAlgorithm creates a new accumulator like NewAccumulator, whose event
+// chain uses the specified multihash algorithm.
+func NewAccumulatorUsingHashAlgorithm(sk *gabikeys.PrivateKey, code uint64) (*Update, error) {
+	if err := checkHashAlg(code); err != nil {
+		return nil, err
+	}
 	empty := [32]byte{}
-	emptyhash, err := multihash.Encode(empty[:], HashAlgorithm)
+	emptyhash, err := multihash.Encode(empty[:], code)
+	if err != nil {
+		return nil, err
+	}
 	initialEvent := &Event{
 		Index:      0,
 		E:          big.NewInt(1),
 		ParentHash: emptyhash,
 	}
+	eventHash, err := initialEvent.hashUsingAlg(code)
 	if err != nil {
 		return nil, err
 	}
@@ -170,7 +232,7 @@ func NewAccumulator(sk *gabikeys.PrivateKey) (*Update, error) {
 		Index:     0,
 		Nu:        common.RandomQR(sk.N),
 		Time:      time.Now().Unix(),
-		EventHash: initialEvent.hash(),
+		EventHash: eventHash,
 	}
 	sig, err := acc.Sign(sk)
 	if err != nil {
@@ -184,7 +246,7 @@ func NewAccumulator(sk *gabikeys.PrivateKey) (*Update, error) {
 
 // Sign the accumulator into a SignedAccumulator (c.f. SignedAccumulator.UnmarshalVerify()).
 func (acc *Accumulator) Sign(sk *gabikeys.PrivateKey) (*SignedAccumulator, error) {
-	sig, err := signed.MarshalSign(sk.ECDSA, acc)
+	sig, err := signed.MarshalSign(sk.ECDSA, &accumulatorMessage{Accumulator: acc, Type: accumulatorMessageType})
 	if err != nil {
 		return nil, err
 	}
@@ -205,28 +267,144 @@ func (acc *Accumulator) Remove(sk *gabikeys.PrivateKey, e *big.Int, parent *Even
 		Time:  time.Now().Unix(),
 	}
 	event := &Event{
-		Index:      newAcc.Index,
-		E:          e,
-		ParentHash: parent.hash(),
+		Index: newAcc.Index,
+		E:     e,
+	}
+	if err := acc.link(parent, event, newAcc); err != nil {
+		return nil, nil, err
 	}
-	newAcc.EventHash = event.hash()
 	return newAcc, event, nil
 }
 
+// RemoveMultiple generates a new accumulator with all of the specified e's removed from it, in
+// a single event, by exponentiating with the inverse of their product.
+func (acc *Accumulator) RemoveMultiple(sk *gabikeys.PrivateKey, es []*big.Int, parent *Event) (*Accumulator, *Event, error) {
+	if len(es) == 0 {
+		return nil, nil, errors.New("no revocation attributes specified")
+	}
+	event := &Event{
+		Index: acc.Index + 1,
+		Es:    make([]*big.Int, len(es)),
+	}
+	for i, e := range es {
+		if e == nil || e.Sign() <= 0 {
+			return nil, nil, errors.New("invalid revocation attribute")
+		}
+		event.Es[i] = new(big.Int).Set(e)
+	}
+	productInverse, ok := common.ModInverse(event.Product(), sk.Order)
+	if !ok {
+		// as in Remove, this happens if and only if one of the e's equals either P or Q
+		return nil, nil, errors.New("revocation attribute has no inverse")
+	}
+
+	newAcc := &Accumulator{
+		Nu:    new(big.Int).Exp(acc.Nu, productInverse, sk.N),
+		Index: event.Index,
+		Time:  time.Now().Unix(),
+	}
+	if err := acc.link(parent, event, newAcc); err != nil {
+		return nil, nil, err
+	}
+	return newAcc, event, nil
+}
+
+// Reinstate generates a new accumulator to which the specified e, which has previously been
+// removed from it, is added again, by raising Nu to the power e.
+func (acc *Accumulator) Reinstate(sk *gabikeys.PrivateKey, e *big.Int, parent *Event) (*Accumulator, *Event, error) {
+	if e == nil || e.Sign() <= 0 {
+		return nil, nil, errors.New("invalid revocation attribute")
+	}
+	event := &Event{
+		Index: acc.Index + 1,
+		E:     new(big.Int).Set(e),
+		Nu:    new(big.Int).Set(acc.Nu),
+	}
+	newAcc := &Accumulator{
+		Nu:    new(big.Int).Exp(acc.Nu, e, sk.N),
+		Index: event.Index,
+		Time:  time.Now().Unix(),
+	}
+	if err := acc.link(parent, event, newAcc); err != nil {
+		return nil, nil, err
+	}
+	return newAcc, event, nil
+}
+
+// HashAlgorithm returns the multihash algorithm of the accumulator, with which the hashes of its
+// next event are computed. This is the algorithm of its EventHash.
+func (acc *Accumulator) HashAlgorithm() uint64 {
+	alg, err := acc.EventHash.Algorithm()
+	if err != nil {
+		return HashAlgorithm
+	}
+	return alg
+}
+
+// MigrateHashAlgorithm returns a copy of the accumulator using the specified multihash algorithm,
+// whose EventHash is the hash of its last event using that algorithm. The hashes of the next
+// events are then computed using that algorithm, while the hashes of earlier events remain
+// unchanged, resulting in a mixed-algorithm event chain. The new accumulator must be signed and
+// distributed like accumulators resulting from revocations. Witnesses of the old accumulator
+// cannot be updated using checkpoints starting directly after it (see Checkpoint).
+func (acc *Accumulator) MigrateHashAlgorithm(code uint64, last *Event) (*Accumulator, error) {
+	if err := checkHashAlg(code); err != nil {
+		return nil, err
+	}
+	if last.Index != acc.Index {
+		return nil, errors.New("event is not the last event of the accumulator")
+	}
+	if err := last.hashEquals(acc.EventHash); err != nil {
+		return nil, errors.WrapPrefix(err, "event is not the last event of the accumulator", 0)
+	}
+	eventHash, err := last.hashUsingAlg(code)
+	if err != nil {
+		return nil, err
+	}
+	return &Accumulator{
+		Nu:        new(big.Int).Set(acc.Nu),
+		Index:     acc.Index,
+		Time:      time.Now().Unix(),
+		EventHash: eventHash,
+	}, nil
+}
+
+// link sets the ParentHash of the event, which is the next event of the accumulator, and the
+// EventHash of the resulting accumulator, using the hash algorithm of the accumulator.
+func (acc *Accumulator) link(parent, event *Event, newAcc *Accumulator) error {
+	alg := acc.HashAlgorithm()
+	var err error
+	if event.ParentHash, err = parent.hashUsingAlg(alg); err != nil {
+		return err
+	}
+	newAcc.EventHash, err = event.hashUsingAlg(alg)
+	return err
+}
+
 // UnmarshalVerify verifies the signature and unmarshals the accumulator
 // (c.f. Accumulator.Sign()).
 func (s *SignedAccumulator) UnmarshalVerify(pk *gabikeys.PublicKey) (*Accumulator, error) {
 	if s.Accumulator != nil {
 		return s.Accumulator, nil
 	}
-	msg := &Accumulator{}
+	pk = pk.RevocationKey()
+	msg := &accumulatorMessage{Accumulator: &Accumulator{}}
+	if pk.ECDSA == nil {
+		return nil, errors.New("public key does not support revocation")
+	}
 	if pk.Counter != s.PKCounter {
 		return nil, errors.New("wrong public key")
 	}
 	if err := signed.UnmarshalVerify(pk.ECDSA, s.Data, msg); err != nil {
 		return nil, err
 	}
-	s.Accumulator = msg
+	if msg.Type != "" && msg.Type != accumulatorMessageType {
+		return nil, errors.New("signed message is not an accumulator")
+	}
+	if msg.Nu == nil || msg.EventHash == nil {
+		return nil, errors.New("invalid accumulator")
+	}
+	s.Accumulator = msg.Accumulator
 	return s.Accumulator, nil
 }
 
@@ -246,6 +424,7 @@ func NewUpdate(sk *gabikeys.PrivateKey, acc *Accumulator, events []*Event) (*Upd
 
 type compressedUpdate struct {
 	SignedAccumulator *SignedAccumulator `json:"sacc"`
+	Checkpoint        *SignedCheckpoint  `json:"checkpoint,omitempty"`
 	E                 *EventList         `json:"e,omitempty"`
 }
 
@@ -256,12 +435,14 @@ func (update *Update) compress() *compressedUpdate {
 	}
 	return &compressedUpdate{
 		SignedAccumulator: update.SignedAccumulator,
+		Checkpoint:        update.Checkpoint,
 		E:                 el,
 	}
 }
 
 func (update *Update) uncompress(c *compressedUpdate) {
 	update.SignedAccumulator = c.SignedAccumulator
+	update.Checkpoint = c.Checkpoint
 	if c.E != nil {
 		update.Events = c.E.Events
 	} else {
@@ -298,25 +479,45 @@ func (update *Update) UnmarshalCBOR(data []byte) error {
 // Verify that the specified update message is a validly signed partial chain:
 // - the accumulator is validly signed
 // - the accumulator includes the hash of the last item in the hash chain
-// - the hash chain is valid (each chain item has the correct hash of its parent).
+// - the hash chain is valid (each chain item has the correct hash of its parent)
+// - if present, the checkpoint is validly signed and the events continue its chain.
 func (update *Update) Verify(pk *gabikeys.PublicKey) (*Accumulator, error) {
 	acc, err := update.SignedAccumulator.UnmarshalVerify(pk)
 	if err != nil {
 		return nil, err
 	}
+	if update.Checkpoint != nil {
+		checkpoint, err := update.Checkpoint.UnmarshalVerify(pk)
+		if err != nil {
+			return nil, err
+		}
+		return acc, update.verifyCheckpoint(checkpoint, acc)
+	}
 	return acc, NewEventList(update.Events...).Verify(acc)
 }
 
+// Product returns the product of the e's revoked by the events of the update from the specified
+// index onwards. If the update contains a checkpoint, its product is included and from is
+// ignored; in that case the caller must check that the checkpoint starts at the desired index.
+// Reinstatement events contribute 1 (see Event.Product), so that the product excludes the e's
+// they reinstate, and does not by itself suffice to update witnesses across them.
 func (update *Update) Product(from uint64) *big.Int {
 	if update.product != nil {
 		return update.product
 	}
 	update.product = big.NewInt(1)
+	if update.Checkpoint != nil {
+		update.product.Set(update.Checkpoint.Checkpoint.Product)
+		for _, event := range update.Events {
+			update.product.Mul(update.product, event.Product())
+		}
+		return update.product
+	}
 	if len(update.Events) == 0 {
 		return update.product
 	}
 	for _, event := range update.Events[from-update.Events[0].Index:] {
-		update.product.Mul(update.product, event.E)
+		update.product.Mul(update.product, event.Product())
 	}
 	return update.product
 }
@@ -326,6 +527,9 @@ func (update *Update) Prepend(eventlist *EventList) error {
 	if count == 0 {
 		return nil
 	}
+	if update.Checkpoint != nil {
+		return errors.New("cannot prepend events to update containing checkpoint")
+	}
 	ours := update.Events[0].Index
 	last := eventlist.Events[count-1].Index
 	if last < ours-1 {
@@ -356,10 +560,10 @@ func (update *Update) Prepend(eventlist *EventList) error {
 	return nil
 }
 
-// whitelist of hash algorithms that we currently accept consisting of just SHA256
+// whitelist of hash algorithms that we currently accept
 func checkHashAlg(code uint64) error {
 	switch code {
-	case multihash.SHA2_256:
+	case HashAlgorithm, HashAlgorithmSHA3_256, HashAlgorithmBLAKE2b_256:
 		return nil
 	default:
 		return errors.New("unsupported hash algorithm")
@@ -384,9 +588,14 @@ func FlattenEventLists(eventslist []*EventList) (*EventList, error) {
 }
 
 type compressedEventList struct {
-	Index      uint64     `json:"i"`
-	ParentHash Hash       `json:"hash"`
-	E          []*big.Int `json:"e"`
+	Index      uint64       `json:"i"`
+	ParentHash Hash         `json:"hash"`
+	E          []*big.Int   `json:"e"`
+	Es         [][]*big.Int `json:"es,omitempty"` // only present if some event revokes several e's
+	Nu         []*big.Int   `json:"nu,omitempty"` // only present if some event reinstates an e
+	// Algorithms contains the multihash algorithm of the ParentHash of each event, and is only
+	// present if they are not all equal to that of ParentHash
+	Algorithms []uint64 `json:"algs,omitempty"`
 }
 
 func (el *EventList) compress() *compressedEventList {
@@ -397,8 +606,27 @@ func (el *EventList) compress() *compressedEventList {
 	c.Index = el.Events[0].Index
 	c.ParentHash = el.Events[0].ParentHash
 	c.E = make([]*big.Int, len(el.Events))
+	algs := make([]uint64, len(el.Events))
+	mixed := false
 	for i := range el.Events {
 		c.E[i] = el.Events[i].E
+		algs[i], _ = el.Events[i].ParentHash.Algorithm()
+		mixed = mixed || algs[i] != algs[0]
+		if len(el.Events[i].Es) != 0 {
+			if c.Es == nil {
+				c.Es = make([][]*big.Int, len(el.Events))
+			}
+			c.Es[i] = el.Events[i].Es
+		}
+		if el.Events[i].Nu != nil {
+			if c.Nu == nil {
+				c.Nu = make([]*big.Int, len(el.Events))
+			}
+			c.Nu[i] = el.Events[i].Nu
+		}
+	}
+	if mixed {
+		c.Algorithms = algs
 	}
 	return &c
 }
@@ -410,19 +638,57 @@ func (el *EventList) uncompress(c *compressedEventList) {
 	if el.ComputeProduct {
 		el.product = big.NewInt(1)
 	}
+	if len(c.Es) != 0 && len(c.Es) != len(c.E) {
+		el.validationErr = errors.New("event list has wrong amount of batch events")
+		el.verified = true
+		return
+	}
+	if len(c.Nu) != 0 && len(c.Nu) != len(c.E) {
+		el.validationErr = errors.New("event list has wrong amount of reinstatement events")
+		el.verified = true
+		return
+	}
+	if len(c.Algorithms) != 0 && len(c.Algorithms) != len(c.E) {
+		el.validationErr = errors.New("event list has wrong amount of hash algorithms")
+		el.verified = true
+		return
+	}
+	alg, err := c.ParentHash.Algorithm()
+	if err != nil && len(c.E) != 0 {
+		el.validationErr = err
+		el.verified = true
+		return
+	}
 	for i := range el.Events {
-		e := c.E[i]
 		el.Events[i] = &Event{
-			E:     e,
+			E:     c.E[i],
 			Index: uint64(i) + c.Index,
 		}
+		if len(c.Es) != 0 {
+			el.Events[i].Es = c.Es[i]
+		}
+		if len(c.Nu) != 0 {
+			el.Events[i].Nu = c.Nu[i]
+		}
+		if err := el.Events[i].validate(); err != nil {
+			el.validationErr = err
+			el.verified = true
+			return
+		}
 		if i == 0 {
 			el.Events[i].ParentHash = c.ParentHash
 		} else {
-			el.Events[i].ParentHash = el.Events[i-1].hash()
+			if len(c.Algorithms) != 0 {
+				alg = c.Algorithms[i]
+			}
+			if el.Events[i].ParentHash, err = el.Events[i-1].hashUsingAlg(alg); err != nil {
+				el.validationErr = err
+				el.verified = true
+				return
+			}
 		}
 		if el.ComputeProduct {
-			el.product.Mul(el.product, e)
+			el.product.Mul(el.product, el.Events[i].Product())
 		}
 	}
 	// The indices and hashes of events that come from a compressed event are always valid
@@ -473,6 +739,11 @@ func (el *EventList) Verify(acc *Accumulator) error {
 		}
 		return nil
 	}
+	for _, event := range events {
+		if err = event.validate(); err != nil {
+			return err
+		}
+	}
 	if err = events[count-1].hashEquals(acc.EventHash); err != nil {
 		return errors.WrapPrefix(err, "update chain has wrong hash", 0)
 	}
@@ -498,6 +769,39 @@ func (el *EventList) Verify(acc *Accumulator) error {
 	return nil
 }
 
+// Product returns the product of the e's revoked by the event, which is 1 for reinstatement
+// events.
+func (event *Event) Product() *big.Int {
+	if event.Nu != nil {
+		return big.NewInt(1)
+	}
+	if len(event.Es) == 0 {
+		return event.E
+	}
+	product := big.NewInt(1)
+	for _, e := range event.Es {
+		product.Mul(product, e)
+	}
+	return product
+}
+
+// validate checks that the event revokes either a single e or several ones, or reinstates a
+// single e.
+func (event *Event) validate() error {
+	if (event.E == nil) == (len(event.Es) == 0) {
+		return errors.Errorf("event %d must contain either a single or multiple revocation attributes", event.Index)
+	}
+	for _, e := range event.Es {
+		if e == nil || e.Sign() <= 0 {
+			return errors.Errorf("event %d contains invalid revocation attribute", event.Index)
+		}
+	}
+	if event.Nu != nil && (event.E == nil || event.E.Sign() <= 0 || event.Nu.Sign() <= 0) {
+		return errors.Errorf("event %d is an invalid reinstatement", event.Index)
+	}
+	return nil
+}
+
 func (event *Event) hash() Hash {
 	hash, err := event.hashUsingAlg(HashAlgorithm)
 	if err != nil {
@@ -511,10 +815,31 @@ func (event *Event) hashBytes() []byte {
 	bts := make([]byte, 8, 8+len(event.ParentHash)+int(Parameters.AttributeSize)/8+1)
 	binary.BigEndian.PutUint64(bts, event.Index)
 	bts = append(bts, event.ParentHash[:]...)
-	bts = append(bts, event.E.Bytes()...)
+	if event.Nu != nil {
+		// Reinstatement events start with four zero bytes, unlike batch events (as their e's are
+		// nonzero) and single events
+		bts = append(bts, 0, 0, 0, 0)
+		bts = appendLengthPrefixed(bts, event.E)
+		return appendLengthPrefixed(bts, event.Nu)
+	}
+	if len(event.Es) == 0 {
+		return append(bts, event.E.Bytes()...)
+	}
+	// Length-prefix each of the e's so that batch events cannot collide with each other. Nor can
+	// they collide with single events: the prefix starts with a zero byte, unlike E.Bytes()
+	for _, e := range event.Es {
+		bts = appendLengthPrefixed(bts, e)
+	}
 	return bts
 }
 
+func appendLengthPrefixed(bts []byte, x *big.Int) []byte {
+	var length [4]byte
+	binary.BigEndian.PutUint32(length[:], uint32(len(x.Bytes())))
+	bts = append(bts, length[:]...)
+	return append(bts, x.Bytes()...)
+}
+
 func (event *Event) hashUsingAlg(code uint64) (Hash, error) {
 	if err := checkHashAlg(code); err != nil {
 		return nil, err
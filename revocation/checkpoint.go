@@ -0,0 +1,154 @@
+package revocation
+
+import (
+	"bytes"
+
+	"github.com/go-errors/errors"
+	"github.com/privacybydesign/gabi/big"
+	"github.com/privacybydesign/gabi/gabikeys"
+	"github.com/privacybydesign/gabi/signed"
+)
+
+// Checkpoints allow clients whose witness is far behind the current accumulator to update it
+// without fetching all events in between. A Checkpoint contains the product of the e's revoked by
+// all events from index From up to and including To, along with the hashes of the parent of event
+// From and of event To which bind it to the event chain. Signed by the issuer, it can be included
+// in an Update instead of those events; Witness.Update then uses its product directly.
+//
+// In event chains using several hash algorithms (see Accumulator.MigrateHashAlgorithm), the
+// ParentHash is that of event From, and the EventHash is computed using the algorithm of the
+// ParentHash of event To. Therefore a checkpoint cannot end at an event directly after which the
+// hash algorithm was migrated; the Update should then instead contain a checkpoint ending before
+// that event, followed by the event. Likewise, a checkpoint starting directly after the migration
+// has the ParentHash using the new algorithm, so that it cannot be used to update witnesses of the
+// accumulator from before the migration, whose EventHash uses the old algorithm. Such witnesses
+// must instead be updated using an Update containing the events after the migration (see
+// NewUpdate), or first to the migrated accumulator.
+
+type (
+	// Checkpoint contains the product of the e's revoked by the events from From up to and
+	// including To.
+	Checkpoint struct {
+		From       uint64   `json:"from"`
+		To         uint64   `json:"to"`
+		Product    *big.Int `json:"product"`
+		ParentHash Hash     `json:"parenthash"` // Hash of the parent of event From
+		EventHash  Hash     `json:"eventhash"`  // Hash of event To
+	}
+
+	// SignedCheckpoint is a Checkpoint signed with the issuer's ECDSA key, along with the key index.
+	SignedCheckpoint struct {
+		Data       signed.Message `json:"data"`
+		PKCounter  uint           `json:"pk"`
+		Checkpoint *Checkpoint    `json:"-"` // Checkpoint contained in this instance, set by UnmarshalVerify()
+	}
+)
+
+// checkpointMessage is the message of a SignedCheckpoint.
+type checkpointMessage struct {
+	*Checkpoint
+	Type string `json:"type"`
+}
+
+// NewCheckpoint computes and signs a checkpoint covering the specified events, which must form a
+// valid chain not containing reinstatement events.
+func NewCheckpoint(sk *gabikeys.PrivateKey, events []*Event) (*SignedCheckpoint, error) {
+	if len(events) == 0 {
+		return nil, errors.New("no events specified")
+	}
+	product := big.NewInt(1)
+	for i, event := range events {
+		if event.Nu != nil {
+			// the product of the e's does not suffice to update witnesses across reinstatements
+			return nil, errors.New("checkpoint cannot contain reinstatement events")
+		}
+		product.Mul(product, event.Product())
+		var parent *Event
+		if i > 0 {
+			parent = events[i-1]
+		}
+		if err := checkAppend(parent, event); err != nil {
+			return nil, err
+		}
+	}
+
+	first, last := events[0], events[len(events)-1]
+	alg, err := last.ParentHash.Algorithm()
+	if err != nil {
+		return nil, err
+	}
+	eventHash, err := last.hashUsingAlg(alg)
+	if err != nil {
+		return nil, err
+	}
+	checkpoint := &Checkpoint{
+		From:       first.Index,
+		To:         last.Index,
+		Product:    product,
+		ParentHash: first.ParentHash,
+		EventHash:  eventHash,
+	}
+	sig, err := signed.MarshalSign(sk.ECDSA, &checkpointMessage{Checkpoint: checkpoint, Type: checkpointMessageType})
+	if err != nil {
+		return nil, err
+	}
+	return &SignedCheckpoint{Data: sig, PKCounter: sk.Counter, Checkpoint: checkpoint}, nil
+}
+
+// NewCheckpointUpdate returns an Update containing the accumulator, signed with the private key,
+// the checkpoint, and the events following the checkpoint up to the accumulator, if any.
+func NewCheckpointUpdate(sk *gabikeys.PrivateKey, acc *Accumulator, checkpoint *SignedCheckpoint, events []*Event) (*Update, error) {
+	sacc, err := acc.Sign(sk)
+	if err != nil {
+		return nil, err
+	}
+	update := &Update{
+		SignedAccumulator: sacc,
+		Checkpoint:        checkpoint,
+		Events:            events,
+	}
+	if err = update.verifyCheckpoint(checkpoint.Checkpoint, acc); err != nil {
+		return nil, err // ensure we don't return an invalid Update
+	}
+	return update, nil
+}
+
+// UnmarshalVerify verifies the signature and unmarshals the checkpoint.
+func (s *SignedCheckpoint) UnmarshalVerify(pk *gabikeys.PublicKey) (*Checkpoint, error) {
+	pk = pk.RevocationKey()
+	msg := &checkpointMessage{Checkpoint: &Checkpoint{}}
+	if pk.ECDSA == nil {
+		return nil, errors.New("public key does not support revocation")
+	}
+	if pk.Counter != s.PKCounter {
+		return nil, errors.New("wrong public key")
+	}
+	if err := signed.UnmarshalVerify(pk.ECDSA, s.Data, msg); err != nil {
+		return nil, err
+	}
+	if msg.Type != checkpointMessageType {
+		return nil, errors.New("signed message is not a checkpoint")
+	}
+	if msg.Product == nil || msg.Product.Sign() <= 0 || msg.To < msg.From ||
+		msg.ParentHash == nil || msg.EventHash == nil {
+		return nil, errors.New("invalid checkpoint")
+	}
+	s.Checkpoint = msg.Checkpoint
+	return s.Checkpoint, nil
+}
+
+// verifyCheckpoint checks that the checkpoint, followed by the events of the update, forms a
+// valid chain ending in the accumulator.
+func (update *Update) verifyCheckpoint(checkpoint *Checkpoint, acc *Accumulator) error {
+	if len(update.Events) == 0 {
+		if checkpoint.To != acc.Index || !bytes.Equal(checkpoint.EventHash, acc.EventHash) {
+			return errors.New("checkpoint does not end in accumulator")
+		}
+		return nil
+	}
+	first := update.Events[0]
+	if first.Index != checkpoint.To+1 || !bytes.Equal(first.ParentHash, checkpoint.EventHash) {
+		return errors.New("events do not continue checkpoint")
+	}
+	return NewEventList(update.Events...).Verify(acc)
+}
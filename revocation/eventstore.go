@@ -0,0 +1,210 @@
+package revocation
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/go-errors/errors"
+	"github.com/privacybydesign/gabi/gabikeys"
+)
+
+type (
+	// EventStore persists the chain of events of an accumulator. Events can only be appended to
+	// it, and only if they are the child of the last event in the store.
+	EventStore interface {
+		// Append appends the event to the store, after checking that it is the child of the last
+		// event in the store.
+		Append(event *Event) error
+		// Events returns, in order, all stored events having an index of at least from.
+		Events(from uint64) ([]*Event, error)
+		// Last returns the last stored event, or nil if the store is empty.
+		Last() (*Event, error)
+	}
+
+	// MemoryEventStore is an EventStore that keeps its events in memory.
+	MemoryEventStore struct {
+		lock   sync.Mutex
+		events []*Event
+	}
+
+	// FileEventStore is an EventStore that keeps its events in an append-only file, containing
+	// one JSON-encoded event per line. The hash chain of the events is verified when opening it.
+	// Unlike storing events using their gorm tags, it stores all fields of the events.
+	FileEventStore struct {
+		lock   sync.Mutex
+		file   *os.File
+		memory *MemoryEventStore
+	}
+)
+
+// ErrEventsUnavailable is returned when requesting events from an EventStore that are older
+// than its first event.
+var ErrEventsUnavailable = errors.New("requested events not available")
+
+// NewUpdateFromStore returns an Update containing the accumulator, signed with the private key,
+// and all events in the store from the specified index onwards. The accumulator must be the one
+// resulting from the last event in the store.
+func NewUpdateFromStore(sk *gabikeys.PrivateKey, acc *Accumulator, store EventStore, from uint64) (*Update, error) {
+	last, err := store.Last()
+	if err != nil {
+		return nil, err
+	}
+	if last == nil || last.Index != acc.Index {
+		return nil, errors.New("accumulator does not belong to last event in store")
+	}
+	events, err := store.Events(from)
+	if err != nil {
+		return nil, err
+	}
+	if len(events) == 0 {
+		// NewUpdate does not check the accumulator against the event chain if there are no
+		// events, so we do it here
+		if err = last.hashEquals(acc.EventHash); err != nil {
+			return nil, errors.WrapPrefix(err, "accumulator has wrong event hash", 0)
+		}
+	}
+	return NewUpdate(sk, acc, events)
+}
+
+// checkAppend checks that the event may be appended to a chain whose last event is last, which
+// may be nil if the chain is empty.
+func checkAppend(last, event *Event) error {
+	if err := event.validate(); err != nil {
+		return err
+	}
+	if last == nil {
+		return nil
+	}
+	if event.Index != last.Index+1 {
+		return errors.Errorf("event has wrong index, found %d, expected %d", event.Index, last.Index+1)
+	}
+	if err := last.hashEquals(event.ParentHash); err != nil {
+		return errors.WrapPrefix(err, "event has wrong parent hash", 0)
+	}
+	return nil
+}
+
+// NewMemoryEventStore returns a new, empty MemoryEventStore.
+func NewMemoryEventStore() *MemoryEventStore {
+	return &MemoryEventStore{}
+}
+
+func (s *MemoryEventStore) Append(event *Event) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if err := checkAppend(s.last(), event); err != nil {
+		return err
+	}
+	s.events = append(s.events, event)
+	return nil
+}
+
+func (s *MemoryEventStore) Events(from uint64) ([]*Event, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if len(s.events) == 0 {
+		return []*Event{}, nil
+	}
+	first := s.events[0].Index
+	if from < first {
+		return nil, ErrEventsUnavailable
+	}
+	if from > s.last().Index {
+		return []*Event{}, nil
+	}
+	return append([]*Event{}, s.events[from-first:]...), nil
+}
+
+func (s *MemoryEventStore) Last() (*Event, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.last(), nil
+}
+
+func (s *MemoryEventStore) last() *Event {
+	if len(s.events) == 0 {
+		return nil
+	}
+	return s.events[len(s.events)-1]
+}
+
+// OpenFileEventStore opens the FileEventStore at the specified path, creating it if it does not
+// exist. It returns an error if the file does not contain a valid chain of events. The caller must
+// close the store after use.
+func OpenFileEventStore(path string) (*FileEventStore, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	s := &FileEventStore{file: file, memory: NewMemoryEventStore()}
+	if err = s.load(); err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// load reads the events from the file. An incomplete last line, left by an Append that was
+// interrupted before it completed, is truncated from the file.
+func (s *FileEventStore) load() error {
+	reader := bufio.NewReader(s.file)
+	var offset int64
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			if len(line) != 0 {
+				return s.file.Truncate(offset)
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		offset += int64(len(line))
+		var event Event
+		if err = json.Unmarshal(line, &event); err != nil {
+			return errors.WrapPrefix(err, "failed to parse event store", 0)
+		}
+		if err = s.memory.Append(&event); err != nil {
+			return errors.WrapPrefix(err, "invalid event chain in event store", 0)
+		}
+	}
+}
+
+func (s *FileEventStore) Append(event *Event) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	last, _ := s.memory.Last()
+	if err := checkAppend(last, event); err != nil {
+		return err
+	}
+	bts, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	if _, err = s.file.Write(append(bts, '\n')); err != nil {
+		return err
+	}
+	if err = s.file.Sync(); err != nil {
+		return err
+	}
+	return s.memory.Append(event)
+}
+
+func (s *FileEventStore) Events(from uint64) ([]*Event, error) {
+	return s.memory.Events(from)
+}
+
+func (s *FileEventStore) Last() (*Event, error) {
+	return s.memory.Last()
+}
+
+// Close closes the file of the store.
+func (s *FileEventStore) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.file.Close()
+}
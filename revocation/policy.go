@@ -0,0 +1,51 @@
+package revocation
+
+import (
+	"fmt"
+	"time"
+)
+
+type (
+	// VerificationPolicy specifies the accumulators against which a verifier accepts nonrevocation
+	// proofs. Its zero value accepts any accumulator.
+	VerificationPolicy struct {
+		// MaxAge is the maximum age of the accumulator, i.e. the time since it was signed by the
+		// issuer. Zero means that any age is accepted.
+		MaxAge time.Duration
+		// MinIndex is the minimum index of the accumulator.
+		MinIndex uint64
+		// Latest is the latest accumulator known to the verifier. If set, the accumulator must be
+		// at least as new as it.
+		Latest *Accumulator
+	}
+
+	// StaleAccumulatorError is returned when a nonrevocation proof is made against an accumulator
+	// that is not accepted by the VerificationPolicy of the verifier. The verifier can then ask
+	// the prover to update its witness and retry.
+	StaleAccumulatorError struct {
+		Index  uint64
+		Time   time.Time
+		Reason string
+	}
+)
+
+func (e *StaleAccumulatorError) Error() string {
+	return fmt.Sprintf("stale accumulator %d of %s: %s", e.Index, e.Time.Format(time.RFC3339), e.Reason)
+}
+
+// Check returns a *StaleAccumulatorError if the accumulator is not accepted by the policy.
+func (policy *VerificationPolicy) Check(acc *Accumulator) error {
+	stale := func(reason string) error {
+		return &StaleAccumulatorError{Index: acc.Index, Time: time.Unix(acc.Time, 0), Reason: reason}
+	}
+	if acc.Index < policy.MinIndex {
+		return stale(fmt.Sprintf("index below minimum %d", policy.MinIndex))
+	}
+	if policy.Latest != nil && acc.Index < policy.Latest.Index {
+		return stale(fmt.Sprintf("older than latest known accumulator %d", policy.Latest.Index))
+	}
+	if policy.MaxAge > 0 && time.Since(time.Unix(acc.Time, 0)) > policy.MaxAge {
+		return stale(fmt.Sprintf("older than %s", policy.MaxAge))
+	}
+	return nil
+}
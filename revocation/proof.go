@@ -1,6 +1,7 @@
 package revocation
 
 import (
+	"bytes"
 	"crypto/rand"
 	"time"
 
@@ -176,6 +177,7 @@ func RandomWitness(sk *gabikeys.PrivateKey, acc *Accumulator) (*Witness, error)
 func NewProofCommit(key *gabikeys.PublicKey, witn *Witness, randomizer *big.Int) ([]*big.Int, *ProofCommit, error) {
 	Logger.Tracef("revocation.NewProofCommit()")
 	defer Logger.Tracef("revocation.NewProofCommit() done")
+	key = key.RevocationKey()
 	witn.randomizer = randomizer
 	if randomizer == nil {
 		witn.randomizer = NewProofRandomizer()
@@ -204,6 +206,7 @@ func (p *Proof) SetExpected(pk *gabikeys.PublicKey, challenge, response *big.Int
 }
 
 func (p *Proof) ChallengeContributions(key *gabikeys.PublicKey) []*big.Int {
+	key = key.RevocationKey()
 	return proofstructure.commitmentsFromProof(key, []*big.Int{},
 		p.Challenge, key, (*proof)(p), (*proof)(p))
 }
@@ -271,6 +274,7 @@ func (w *Witness) Update(pk *gabikeys.PublicKey, update *Update) error {
 	Logger.Tracef("revocation.Witness.Update()")
 	defer Logger.Tracef("revocation.Witness.Update() done")
 
+	pk = pk.RevocationKey()
 	newAcc, err := update.Verify(pk)
 	ourAcc := w.SignedAccumulator.Accumulator
 	if err != nil {
@@ -285,29 +289,52 @@ func (w *Witness) Update(pk *gabikeys.PublicKey, update *Update) error {
 		return nil
 	}
 
-	if len(update.Events) == 0 {
+	if len(update.Events) == 0 && update.Checkpoint == nil {
 		return nil
 	}
-	startIndex, endIndex := update.Events[0].Index, newAcc.Index
-	if endIndex <= ourAcc.Index {
+	if newAcc.Index <= ourAcc.Index {
 		return nil
 	}
-	if startIndex > ourAcc.Index+1 {
+	product := big.NewInt(1)
+	events := update.Events
+	if checkpoint := update.Checkpoint; checkpoint != nil {
+		// The checkpoint product must cover exactly the events after our accumulator
+		if checkpoint.Checkpoint.From != ourAcc.Index+1 ||
+			!bytes.Equal(checkpoint.Checkpoint.ParentHash, ourAcc.EventHash) {
+			return errors.New("checkpoint does not start at witness accumulator")
+		}
+		product.Set(checkpoint.Checkpoint.Product)
+	} else if update.Events[0].Index > ourAcc.Index+1 {
 		return errors.New("update too new")
+	} else {
+		events = events[ourAcc.Index+1-update.Events[0].Index:]
+	}
+
+	// Process the revoked e's in one go, except when a reinstatement event intervenes: then we
+	// first update u to the accumulator before reinstating, which the event contains, and then
+	// raise it to the reinstated e. If our own e is reinstated, that accumulator is our new u.
+	newU, revoked := w.U, false
+	for _, event := range events {
+		if event.Nu == nil {
+			product.Mul(product, event.Product())
+			continue
+		}
+		if event.E.Cmp(w.E) == 0 {
+			newU, revoked = new(big.Int).Set(event.Nu), false
+		} else if !revoked {
+			if newU, revoked = updateU(newU, w.E, product, event.Nu, pk.N); !revoked {
+				newU.Exp(newU, event.E, pk.N)
+			}
+		}
+		product.SetInt64(1)
 	}
-
-	var a, b big.Int
-	if new(big.Int).GCD(&a, &b, w.E, update.Product(ourAcc.Index+1)).Cmp(bigOne) != 0 {
+	if !revoked {
+		newU, revoked = updateU(newU, w.E, product, newAcc.Nu, pk.N)
+	}
+	if revoked {
 		return ErrorRevoked
 	}
 
-	// u' = u^b * newNu^a mod n
-	newU := new(big.Int)
-	newU.Mul(
-		new(big.Int).Exp(w.U, &b, pk.N),
-		new(big.Int).Exp(newAcc.Nu, &a, pk.N),
-	).Mod(newU, pk.N)
-
 	if !verify(newU, w.E, newAcc, pk) {
 		return errors.New("nonrevocation witness invalidated by update")
 	}
@@ -320,8 +347,27 @@ func (w *Witness) Update(pk *gabikeys.PublicKey, update *Update) error {
 	return nil
 }
 
+// updateU returns u' = u^b * nu^a mod n, where a*e + b*product = 1, which is such that u'^e = nu if
+// u^e = nu^product. It returns true if no such u' exists, i.e. if e has been revoked.
+func updateU(u, e, product, nu, n *big.Int) (*big.Int, bool) {
+	if product.Cmp(bigOne) == 0 {
+		return new(big.Int).Set(u), false
+	}
+	var a, b big.Int
+	if new(big.Int).GCD(&a, &b, e, product).Cmp(bigOne) != 0 {
+		return nil, true
+	}
+	newU := new(big.Int)
+	newU.Mul(
+		new(big.Int).Exp(u, &b, n),
+		new(big.Int).Exp(nu, &a, n),
+	).Mod(newU, n)
+	return newU, false
+}
+
 // Verify the witness against its SignedAccumulator.
 func (w *Witness) Verify(pk *gabikeys.PublicKey) error {
+	pk = pk.RevocationKey()
 	_, err := w.SignedAccumulator.UnmarshalVerify(pk)
 	if err != nil {
 		return err
@@ -371,6 +417,7 @@ func (p *proof) ProofResult(name string) *big.Int {
 }
 
 func (p *proof) verify(pk *gabikeys.PublicKey) bool {
+	pk = pk.RevocationKey()
 	commitments := proofstructure.commitmentsFromProof(pk, []*big.Int{}, p.Challenge, pk, p, p)
 	return (*Proof)(p).VerifyWithChallenge(pk, common.HashCommit(commitments, false))
 }
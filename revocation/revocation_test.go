@@ -1,7 +1,12 @@
 package revocation
 
 import (
+	"bytes"
 	"crypto/rand"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -12,6 +17,8 @@ import (
 	"github.com/privacybydesign/gabi/signed"
 	"github.com/privacybydesign/gabi/zkproof"
 
+	"github.com/fxamacker/cbor"
+	"github.com/multiformats/go-multihash"
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/require"
 )
@@ -163,6 +170,65 @@ func TestAccumulatorRemove(t *testing.T) {
 	require.Equal(t, 0, new(big.Int).Exp(newAcc.Nu, e, pk.N).Cmp(acc.Nu))
 }
 
+func TestAccumulatorRemoveMultiple(t *testing.T) {
+	update, pk, sk, acc := generateUpdate(t)
+	revoked, err := RandomWitness(sk, acc)
+	require.NoError(t, err)
+	revoked.SignedAccumulator = update.SignedAccumulator
+	unrevoked, err := RandomWitness(sk, acc)
+	require.NoError(t, err)
+	unrevoked.SignedAccumulator = update.SignedAccumulator
+
+	es := []*big.Int{revoked.E}
+	for i := 0; i < 3; i++ {
+		e, err := common.RandomPrimeInRange(rand.Reader, 3, Parameters.AttributeSize)
+		require.NoError(t, err)
+		es = append(es, e)
+	}
+	parent := update.Events[len(update.Events)-1]
+	newAcc, event, err := acc.RemoveMultiple(sk, es, parent)
+	require.NoError(t, err)
+	require.Equal(t, parent.Index+1, event.Index)
+	require.Equal(t, acc.Index+1, newAcc.Index)
+	require.Nil(t, event.E)
+	require.Equal(t, 0, new(big.Int).Exp(newAcc.Nu, event.Product(), pk.N).Cmp(acc.Nu))
+	_, _, err = acc.RemoveMultiple(sk, nil, parent)
+	require.Error(t, err)
+
+	// Batch events are followed by a single event, and survive serialization
+	newAcc, single := revoke(t, newAcc, event, sk)
+	update, err = NewUpdate(sk, newAcc, append(update.Events, event, single))
+	require.NoError(t, err)
+	bts, err := json.Marshal(update)
+	require.NoError(t, err)
+	var decoded Update
+	require.NoError(t, json.Unmarshal(bts, &decoded))
+	_, err = decoded.Verify(pk)
+	require.NoError(t, err)
+	require.Equal(t, es, decoded.Events[len(decoded.Events)-2].Es)
+	bts, err = cbor.Marshal(update, cbor.EncOptions{})
+	require.NoError(t, err)
+	decoded = Update{}
+	require.NoError(t, cbor.Unmarshal(bts, &decoded))
+	_, err = decoded.Verify(pk)
+	require.NoError(t, err)
+
+	// Witnesses are updated as usual
+	require.NoError(t, unrevoked.Update(pk, &decoded))
+	require.NoError(t, unrevoked.Verify(pk))
+	require.Equal(t, newAcc.Index, unrevoked.SignedAccumulator.Accumulator.Index)
+	require.Equal(t, ErrorRevoked, revoked.Update(pk, &decoded))
+
+	// Modifying a batch event invalidates the chain
+	update.Events[len(update.Events)-2].Es = es[1:]
+	_, err = update.Verify(pk)
+	require.Error(t, err)
+	update.Events[len(update.Events)-2].E = big.NewInt(3)
+	update.Events[len(update.Events)-2].Es = es
+	_, err = update.Verify(pk)
+	require.Error(t, err)
+}
+
 func revoke(t *testing.T, acc *Accumulator, parent *Event, sk *gabikeys.PrivateKey) (*Accumulator, *Event) {
 	e, err := common.RandomPrimeInRange(rand.Reader, 3, Parameters.AttributeSize)
 	require.NoError(t, err)
@@ -249,6 +315,307 @@ func TestWitnessUpdate(t *testing.T) {
 	require.Equal(t, newacc.Time, witness.SignedAccumulator.Accumulator.Time)
 }
 
+func TestWitnessUpdateCheckpoint(t *testing.T) {
+	update, pk, sk, acc := generateUpdate(t)
+	witness, err := RandomWitness(sk, acc)
+	require.NoError(t, err)
+	witness.SignedAccumulator = update.SignedAccumulator
+	revoked, err := RandomWitness(sk, acc)
+	require.NoError(t, err)
+	revoked.SignedAccumulator = update.SignedAccumulator
+
+	events := update.Events
+	event := events[len(events)-1]
+	for i := 0; i < 5; i++ {
+		acc, event = revoke(t, acc, event, sk)
+		events = append(events, event)
+	}
+	acc, event, err = acc.Remove(sk, revoked.E, event)
+	require.NoError(t, err)
+	events = append(events, event)
+	start := witness.SignedAccumulator.Accumulator.Index + 1
+	end := len(events) - 1
+
+	// A checkpoint must start at the accumulator of the witness
+	checkpoint, err := NewCheckpoint(sk, events[start+1:])
+	require.NoError(t, err)
+	update, err = NewCheckpointUpdate(sk, acc, checkpoint, nil)
+	require.NoError(t, err)
+	require.Error(t, witness.Update(pk, update))
+
+	// A checkpoint must continue the chain of the witness's accumulator, not just its index
+	other, err := NewAccumulator(sk)
+	require.NoError(t, err)
+	otherAcc, otherEvents := other.SignedAccumulator.Accumulator, other.Events
+	for i := 0; i < len(events)-1; i++ {
+		var otherEvent *Event
+		otherAcc, otherEvent = revoke(t, otherAcc, otherEvents[i], sk)
+		otherEvents = append(otherEvents, otherEvent)
+	}
+	checkpoint, err = NewCheckpoint(sk, otherEvents[start:])
+	require.NoError(t, err)
+	update, err = NewCheckpointUpdate(sk, otherAcc, checkpoint, nil)
+	require.NoError(t, err)
+	err = witness.Update(pk, update)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "does not start at witness accumulator")
+
+	// Checkpoint followed by events, surviving serialization
+	checkpoint, err = NewCheckpoint(sk, events[start:end-1])
+	require.NoError(t, err)
+	_, err = NewCheckpointUpdate(sk, acc, checkpoint, events[end:])
+	require.Error(t, err)
+	update, err = NewCheckpointUpdate(sk, acc, checkpoint, events[end-1:])
+	require.NoError(t, err)
+	bts, err := json.Marshal(update)
+	require.NoError(t, err)
+	var decoded Update
+	require.NoError(t, json.Unmarshal(bts, &decoded))
+	require.Len(t, decoded.Events, 2)
+	require.NoError(t, witness.Update(pk, &decoded))
+	require.NoError(t, witness.Verify(pk))
+	require.Equal(t, acc.Index, witness.SignedAccumulator.Accumulator.Index)
+	require.Equal(t, ErrorRevoked, revoked.Update(pk, &decoded))
+
+	// Checkpoint without events
+	checkpoint, err = NewCheckpoint(sk, events[start:])
+	require.NoError(t, err)
+	update, err = NewCheckpointUpdate(sk, acc, checkpoint, nil)
+	require.NoError(t, err)
+	bts, err = cbor.Marshal(update, cbor.EncOptions{})
+	require.NoError(t, err)
+	decoded = Update{}
+	require.NoError(t, cbor.Unmarshal(bts, &decoded))
+	require.Equal(t, ErrorRevoked, revoked.Update(pk, &decoded))
+
+	// Signed checkpoints and accumulators cannot be passed off as each other
+	swapped := &SignedAccumulator{Data: checkpoint.Data, PKCounter: checkpoint.PKCounter}
+	_, err = swapped.UnmarshalVerify(pk)
+	require.Error(t, err)
+	require.Error(t, (&Proof{SignedAccumulator: swapped}).SetExpected(pk, big.NewInt(1), big.NewInt(1)))
+	_, err = (&SignedCheckpoint{Data: update.SignedAccumulator.Data, PKCounter: sk.Counter}).UnmarshalVerify(pk)
+	require.Error(t, err)
+
+	// Checkpoints not signed by the issuer are rejected
+	ecdsa, err := signed.GenerateKey()
+	require.NoError(t, err)
+	checkpoint.Checkpoint.Product = big.NewInt(3)
+	checkpoint.Data, err = signed.MarshalSign(ecdsa, checkpoint.Checkpoint)
+	require.NoError(t, err)
+	_, err = update.Verify(pk)
+	require.Error(t, err)
+}
+
+func TestEventEncoding(t *testing.T) {
+	update, _, _, _ := generateUpdate(t)
+	event := update.Events[1]
+	for _, marshal := range []func(interface{}) ([]byte, error){
+		json.Marshal,
+		func(v interface{}) ([]byte, error) { return cbor.Marshal(v, cbor.EncOptions{}) },
+	} {
+		bts, err := marshal(event)
+		require.NoError(t, err)
+		require.Contains(t, string(bts), "ParentHash")
+	}
+}
+
+func TestSignedAccumulator(t *testing.T) {
+	update, pk, sk, acc := generateUpdate(t)
+	decoded := &SignedAccumulator{Data: update.SignedAccumulator.Data, PKCounter: sk.Counter}
+	verified, err := decoded.UnmarshalVerify(pk)
+	require.NoError(t, err)
+	require.Equal(t, acc, verified)
+
+	// Accumulators signed before their type was included in the message are accepted
+	data, err := signed.MarshalSign(sk.ECDSA, acc)
+	require.NoError(t, err)
+	decoded = &SignedAccumulator{Data: data, PKCounter: sk.Counter}
+	verified, err = decoded.UnmarshalVerify(pk)
+	require.NoError(t, err)
+	require.Equal(t, acc, verified)
+
+	// Accumulators without Nu or EventHash are rejected
+	for _, invalid := range []*Accumulator{{Index: acc.Index, EventHash: acc.EventHash}, {Index: acc.Index, Nu: acc.Nu}} {
+		sacc, err := invalid.Sign(sk)
+		require.NoError(t, err)
+		_, err = (&SignedAccumulator{Data: sacc.Data, PKCounter: sk.Counter}).UnmarshalVerify(pk)
+		require.Error(t, err)
+	}
+}
+
+func TestWitnessUpdateReinstate(t *testing.T) {
+	update, pk, sk, acc := generateUpdate(t)
+	reinstated, err := RandomWitness(sk, acc)
+	require.NoError(t, err)
+	reinstated.SignedAccumulator = update.SignedAccumulator
+	other, err := RandomWitness(sk, acc)
+	require.NoError(t, err)
+	other.SignedAccumulator = update.SignedAccumulator
+
+	// Revoke the witness, revoke another one, reinstate the witness, and revoke yet another one
+	events := update.Events
+	acc, event, err := acc.Remove(sk, reinstated.E, events[len(events)-1])
+	require.NoError(t, err)
+	events = append(events, event)
+	revokedUpdate, err := NewUpdate(sk, acc, events)
+	require.NoError(t, err)
+	acc, event = revoke(t, acc, event, sk)
+	events = append(events, event)
+	acc, event, err = acc.Reinstate(sk, reinstated.E, event)
+	require.NoError(t, err)
+	require.NotNil(t, event.Nu)
+	require.Equal(t, 0, new(big.Int).Exp(event.Nu, reinstated.E, pk.N).Cmp(acc.Nu))
+	events = append(events, event)
+	_, err = NewCheckpoint(sk, events[len(events)-3:])
+	require.Error(t, err)
+	acc, event = revoke(t, acc, event, sk)
+	events = append(events, event)
+
+	update, err = NewUpdate(sk, acc, events)
+	require.NoError(t, err)
+	bts, err := json.Marshal(update)
+	require.NoError(t, err)
+	var decoded Update
+	require.NoError(t, json.Unmarshal(bts, &decoded))
+	bts, err = cbor.Marshal(&decoded, cbor.EncOptions{})
+	require.NoError(t, err)
+	decoded = Update{}
+	require.NoError(t, cbor.Unmarshal(bts, &decoded))
+	require.Equal(t, events[len(events)-2].Nu, decoded.Events[len(events)-2].Nu)
+
+	// The reinstated witness is revoked in between, but valid again afterwards
+	require.Equal(t, ErrorRevoked, reinstated.Update(pk, revokedUpdate))
+	for _, w := range []*Witness{reinstated, other} {
+		require.NoError(t, w.Update(pk, &decoded))
+		require.NoError(t, w.Verify(pk))
+		require.Equal(t, acc.Index, w.SignedAccumulator.Accumulator.Index)
+	}
+
+	// Tampering with a reinstatement event invalidates the chain
+	update.Events[len(update.Events)-2].Nu = big.NewInt(2)
+	_, err = update.Verify(pk)
+	require.Error(t, err)
+	update.Events[len(update.Events)-2].Nu = nil
+	_, err = update.Verify(pk)
+	require.Error(t, err)
+}
+
+func TestHashAlgorithms(t *testing.T) {
+	sk, pk := generateKeys(t)
+	_, err := NewAccumulatorUsingHashAlgorithm(sk, multihash.SHA1)
+	require.Error(t, err)
+
+	for _, alg := range []uint64{HashAlgorithm, HashAlgorithmSHA3_256, HashAlgorithmBLAKE2b_256} {
+		update, err := NewAccumulatorUsingHashAlgorithm(sk, alg)
+		require.NoError(t, err)
+		acc, err := update.Verify(pk)
+		require.NoError(t, err)
+		require.Equal(t, alg, acc.HashAlgorithm())
+		witness, err := RandomWitness(sk, acc)
+		require.NoError(t, err)
+		witness.SignedAccumulator = update.SignedAccumulator
+
+		events := update.Events
+		event := events[0]
+		for i := 0; i < 3; i++ {
+			acc, event = revoke(t, acc, event, sk)
+			events = append(events, event)
+			eventAlg, err := event.ParentHash.Algorithm()
+			require.NoError(t, err)
+			require.Equal(t, alg, eventAlg)
+		}
+
+		// A witness of the accumulator directly before the migration
+		update, err = NewUpdate(sk, acc, events)
+		require.NoError(t, err)
+		oldWitness := &Witness{U: witness.U, E: witness.E, SignedAccumulator: witness.SignedAccumulator}
+		require.NoError(t, oldWitness.Update(pk, update))
+
+		// Migrate to another algorithm halfway
+		var migrated uint64 = HashAlgorithmBLAKE2b_256
+		if alg == migrated {
+			migrated = HashAlgorithmSHA3_256
+		}
+		_, err = acc.MigrateHashAlgorithm(migrated, events[1])
+		require.Error(t, err)
+		acc, err = acc.MigrateHashAlgorithm(migrated, event)
+		require.NoError(t, err)
+		require.Equal(t, migrated, acc.HashAlgorithm())
+		for i := 0; i < 2; i++ {
+			acc, event = revoke(t, acc, event, sk)
+			events = append(events, event)
+		}
+		eventAlg, err := event.ParentHash.Algorithm()
+		require.NoError(t, err)
+		require.Equal(t, migrated, eventAlg)
+
+		update, err = NewUpdate(sk, acc, events)
+		require.NoError(t, err)
+		bts, err := json.Marshal(update)
+		require.NoError(t, err)
+		var decoded Update
+		require.NoError(t, json.Unmarshal(bts, &decoded))
+		bts, err = cbor.Marshal(&decoded, cbor.EncOptions{})
+		require.NoError(t, err)
+		decoded = Update{}
+		require.NoError(t, cbor.Unmarshal(bts, &decoded))
+		require.Equal(t, events[len(events)-1].ParentHash, decoded.Events[len(events)-1].ParentHash)
+		require.NoError(t, witness.Update(pk, &decoded))
+		require.NoError(t, witness.Verify(pk))
+
+		// Checkpoints spanning the migration work, except those ending directly before it
+		checkpoint, err := NewCheckpoint(sk, events[1:len(events)-1])
+		require.NoError(t, err)
+		_, err = NewCheckpointUpdate(sk, acc, checkpoint, events[len(events)-1:])
+		require.NoError(t, err)
+		checkpoint, err = NewCheckpoint(sk, events[1:len(events)-2])
+		require.NoError(t, err)
+		_, err = NewCheckpointUpdate(sk, acc, checkpoint, events[len(events)-2:])
+		require.Error(t, err)
+		checkpoint, err = NewCheckpoint(sk, events[1:len(events)-3])
+		require.NoError(t, err)
+		_, err = NewCheckpointUpdate(sk, acc, checkpoint, events[len(events)-3:])
+		require.NoError(t, err)
+
+		// Witnesses of the accumulator directly before the migration cannot be updated using a
+		// checkpoint starting after it, but can be using the events
+		migration := len(events) - 2
+		checkpoint, err = NewCheckpoint(sk, events[migration:])
+		require.NoError(t, err)
+		checkpointUpdate, err := NewCheckpointUpdate(sk, acc, checkpoint, nil)
+		require.NoError(t, err)
+		require.Error(t, oldWitness.Update(pk, checkpointUpdate))
+		eventsUpdate, err := NewUpdate(sk, acc, events[migration:])
+		require.NoError(t, err)
+		require.NoError(t, oldWitness.Update(pk, eventsUpdate))
+		require.NoError(t, oldWitness.Verify(pk))
+
+		// Modifying events invalidates the chain regardless of their hash algorithm
+		for _, i := range []int{2, len(events) - 1} {
+			update.Events[i].E = big.NewInt(42)
+			_, err = update.Verify(pk)
+			require.Error(t, err)
+		}
+	}
+}
+
+func TestVerificationPolicy(t *testing.T) {
+	acc := &Accumulator{Index: 5, Time: time.Now().Add(-time.Hour).Unix()}
+
+	require.NoError(t, (&VerificationPolicy{}).Check(acc))
+	require.NoError(t, (&VerificationPolicy{MaxAge: 2 * time.Hour, MinIndex: 5, Latest: acc}).Check(acc))
+
+	for _, policy := range []*VerificationPolicy{
+		{MaxAge: time.Minute},
+		{MinIndex: 6},
+		{Latest: &Accumulator{Index: 6}},
+	} {
+		err := policy.Check(acc)
+		require.IsType(t, &StaleAccumulatorError{}, err)
+		require.Equal(t, acc.Index, err.(*StaleAccumulatorError).Index)
+	}
+}
+
 func TestUpdateVerification(t *testing.T) {
 	t.Run("PartialEventChain", func(t *testing.T) {
 		update, pk, _, _ := generateUpdate(t)
@@ -300,3 +667,93 @@ func TestUpdateVerification(t *testing.T) {
 		require.Error(t, err)
 	})
 }
+
+func TestEventStore(t *testing.T) {
+	update, pk, sk, acc := generateUpdate(t)
+
+	dir, err := ioutil.TempDir("", "eventstore")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "events")
+	fileStore, err := OpenFileEventStore(path)
+	require.NoError(t, err)
+
+	for name, store := range map[string]EventStore{"Memory": NewMemoryEventStore(), "File": fileStore} {
+		t.Run(name, func(t *testing.T) {
+			last, err := store.Last()
+			require.NoError(t, err)
+			require.Nil(t, last)
+			for _, event := range update.Events {
+				require.NoError(t, store.Append(event))
+			}
+
+			// Events not continuing the chain are rejected
+			_, event := revoke(t, acc, update.Events[1], sk)
+			require.Error(t, store.Append(event))
+			newAcc, event := revoke(t, acc, update.Events[3], sk)
+			event.ParentHash = update.Events[2].ParentHash
+			require.Error(t, store.Append(event))
+
+			partial, err := NewUpdateFromStore(sk, acc, store, 2)
+			require.NoError(t, err)
+			_, err = partial.Verify(pk)
+			require.NoError(t, err)
+			require.Equal(t, update.Events[2:], partial.Events)
+
+			partial, err = NewUpdateFromStore(sk, acc, store, acc.Index+1)
+			require.NoError(t, err)
+			_, err = partial.Verify(pk)
+			require.NoError(t, err)
+			require.Empty(t, partial.Events)
+
+			_, err = NewUpdateFromStore(sk, newAcc, store, 0)
+			require.Error(t, err)
+		})
+	}
+
+	// Reopening the file store yields the same events
+	require.NoError(t, fileStore.Close())
+	fileStore, err = OpenFileEventStore(path)
+	require.NoError(t, err)
+	events, err := fileStore.Events(0)
+	require.NoError(t, err)
+	require.Len(t, events, len(update.Events))
+	partial, err := NewUpdateFromStore(sk, acc, fileStore, 0)
+	require.NoError(t, err)
+	_, err = partial.Verify(pk)
+	require.NoError(t, err)
+	require.NoError(t, fileStore.Close())
+
+	// An incomplete last line, left by an interrupted append, is dropped
+	last := update.Events[len(update.Events)-1]
+	_, event := revoke(t, acc, last, sk)
+	bts, err := json.Marshal(event)
+	require.NoError(t, err)
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	require.NoError(t, err)
+	_, err = file.Write(bts[:len(bts)/2])
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+	fileStore, err = OpenFileEventStore(path)
+	require.NoError(t, err)
+	events, err = fileStore.Events(0)
+	require.NoError(t, err)
+	require.Len(t, events, len(update.Events))
+	require.NoError(t, fileStore.Append(event))
+	require.NoError(t, fileStore.Close())
+	fileStore, err = OpenFileEventStore(path)
+	require.NoError(t, err)
+	events, err = fileStore.Events(0)
+	require.NoError(t, err)
+	require.Len(t, events, len(update.Events)+1)
+	require.NoError(t, fileStore.Close())
+
+	// A file store containing a broken chain cannot be opened
+	bts, err = ioutil.ReadFile(path)
+	require.NoError(t, err)
+	lines := bytes.Split(bts, []byte("\n"))
+	lines[1], lines[2] = lines[2], lines[1]
+	require.NoError(t, ioutil.WriteFile(path, bytes.Join(lines, []byte("\n")), 0644))
+	_, err = OpenFileEventStore(path)
+	require.Error(t, err)
+}
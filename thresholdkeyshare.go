@@ -0,0 +1,280 @@
+package gabi
+
+import (
+	"sort"
+
+	"github.com/go-errors/errors"
+	"github.com/privacybydesign/gabi/big"
+	"github.com/privacybydesign/gabi/gabikeys"
+	"github.com/privacybydesign/gabi/internal/common"
+)
+
+// Threshold keyshare secrets are shared among n keyshare servers using replicated secret sharing,
+// such that any t of them can together act as the keyshare server, while any t-1 of them cannot.
+// The keyshare secret is the sum of one subshare for each set of t-1 servers, which is given to
+// all servers not in that set. Thus any t servers together know all subshares, while any t-1
+// servers miss the subshare corresponding to themselves. As the secret is an integer sum of
+// subshares (instead of a polynomial over a field, as in Shamir secret sharing), this works in
+// the groups of unknown order of the issuer public keys.
+//
+// When a set of at least t servers cooperates in a session, each subshare is used by exactly one
+// of them, viz. the first participant that holds it. Each participant then acts as a keyshare
+// server whose secret is the sum of the subshares it uses, and the commitments and responses of
+// all participants are combined into one ProofPCommitment and ProofP using
+// CombineProofPCommitments and CombineProofPs, which are used as those of a single keyshare
+// server.
+
+type (
+	// KeyshareShare is the share of a threshold keyshare secret of one keyshare server.
+	KeyshareShare struct {
+		Index     int                 `json:"index"`
+		Threshold int                 `json:"threshold"`
+		Count     int                 `json:"count"`
+		SubShares []*KeyshareSubShare `json:"subshares"`
+	}
+
+	// KeyshareSubShare is a summand of a threshold keyshare secret, known to all keyshare servers
+	// except those in Excluded.
+	KeyshareSubShare struct {
+		Excluded []int    `json:"excluded"`
+		Value    *big.Int `json:"value"`
+	}
+)
+
+var (
+	// ErrTooFewKeyshareServers is returned when fewer keyshare servers than the threshold
+	// participate in a session.
+	ErrTooFewKeyshareServers = errors.New("too few keyshare servers participate")
+	// ErrInvalidKeyshareShare is returned when a KeyshareShare does not hold the subshares
+	// appropriate for its index.
+	ErrInvalidKeyshareShare = errors.New("invalid keyshare share")
+	// ErrTooManyKeyshareSubShares is returned when a threshold and amount of keyshare servers
+	// would require more than MaxKeyshareSubShares subshares.
+	ErrTooManyKeyshareSubShares = errors.New("too many keyshare subshares")
+)
+
+// MaxKeyshareSubShares is the maximum amount of subshares of a threshold keyshare secret, i.e. of
+// sets of threshold-1 out of count keyshare servers. Each subshare is shorter than the keyshare
+// secret by the bitsize of this amount, and the amount grows exponentially in the threshold.
+const MaxKeyshareSubShares = 1024
+
+// NewThresholdKeyshareSecret generates a new keyshare secret that is shared among count keyshare
+// servers, such that threshold of them are required to use it. It returns the share of each
+// server; the caller must distribute them and erase them afterwards. The threshold must be at
+// least 2, so that no single keyshare server can act on behalf of the user.
+func NewThresholdKeyshareSecret(threshold, count int) ([]*KeyshareShare, error) {
+	if threshold < 2 || threshold > count {
+		return nil, errors.New("threshold must be at least 2 and at most the amount of keyshare servers")
+	}
+	if !binomialAtMost(count, threshold-1, MaxKeyshareSubShares) {
+		return nil, ErrTooManyKeyshareSubShares
+	}
+
+	shares := make([]*KeyshareShare, count)
+	for i := range shares {
+		shares[i] = &KeyshareShare{Index: i, Threshold: threshold, Count: count}
+	}
+
+	// The subshares must sum to a value of the same size as that of NewKeyshareSecret
+	excludedSets := subsets(count, threshold-1)
+	length := gabikeys.DefaultSystemParameters[1024].Lm - 1 - uint(big.NewInt(int64(len(excludedSets))).BitLen())
+	for _, excluded := range excludedSets {
+		value, err := common.RandomBigInt(length)
+		if err != nil {
+			return nil, err
+		}
+		for _, share := range shares {
+			if !containsIndex(excluded, share.Index) {
+				share.SubShares = append(share.SubShares, &KeyshareSubShare{Excluded: excluded, Value: value})
+			}
+		}
+	}
+
+	return shares, nil
+}
+
+// NewThresholdKeyshareCommitments generates the commitments of the keyshare server holding the
+// specified share, in a session in which the specified keyshare servers participate, for the
+// given set of keys. The commitments of all participants are to be combined using
+// CombineProofPCommitments.
+func NewThresholdKeyshareCommitments(
+	share *KeyshareShare, participants []int, keys []*gabikeys.PublicKey,
+) (*big.Int, []*ProofPCommitment, error) {
+	secret, err := share.secret(participants)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// The randomizers of the participants are summed, so reduce their size accordingly
+	randomizer, err := common.RandomBigInt(keyshareRandomizerLength() - uint(big.NewInt(int64(share.Count)).BitLen()))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var commitments []*ProofPCommitment
+	for _, key := range keys {
+		commitments = append(commitments,
+			&ProofPCommitment{
+				P:       new(big.Int).Exp(key.R[0], secret, key.N),
+				Pcommit: new(big.Int).Exp(key.R[0], randomizer, key.N),
+			})
+	}
+
+	return randomizer, commitments, nil
+}
+
+// ThresholdKeyshareResponse generates the response of the keyshare server holding the specified
+// share for a given challenge and commit, in a session in which the specified keyshare servers
+// participate. The responses of all participants are to be combined using CombineProofPs.
+func ThresholdKeyshareResponse(
+	share *KeyshareShare, participants []int, commit, challenge *big.Int, key *gabikeys.PublicKey,
+) (*ProofP, error) {
+	secret, err := share.secret(participants)
+	if err != nil {
+		return nil, err
+	}
+	return KeyshareResponse(secret, commit, challenge, key), nil
+}
+
+// CombineProofPCommitments combines the commitments for the specified key of the keyshare
+// servers participating in a session into the commitment of a single keyshare server.
+func CombineProofPCommitments(key *gabikeys.PublicKey, commitments []*ProofPCommitment) *ProofPCommitment {
+	combined := &ProofPCommitment{P: big.NewInt(1), Pcommit: big.NewInt(1)}
+	for _, commitment := range commitments {
+		combined.P.Mul(combined.P, commitment.P).Mod(combined.P, key.N)
+		combined.Pcommit.Mul(combined.Pcommit, commitment.Pcommit).Mod(combined.Pcommit, key.N)
+	}
+	return combined
+}
+
+// CombineProofPs combines the responses for the specified key of the keyshare servers
+// participating in a session into the response of a single keyshare server.
+func CombineProofPs(key *gabikeys.PublicKey, proofPs []*ProofP) (*ProofP, error) {
+	if len(proofPs) == 0 {
+		return nil, ErrTooFewKeyshareServers
+	}
+	combined := &ProofP{P: big.NewInt(1), C: new(big.Int).Set(proofPs[0].C), SResponse: big.NewInt(0)}
+	for _, proofP := range proofPs {
+		if proofP.C.Cmp(combined.C) != 0 {
+			return nil, ErrInvalidProofP
+		}
+		combined.P.Mul(combined.P, proofP.P).Mod(combined.P, key.N)
+		combined.SResponse.Add(combined.SResponse, proofP.SResponse)
+	}
+	return combined, nil
+}
+
+// Verify checks that the share holds exactly the subshares of all sets of Threshold-1 keyshare
+// servers not containing its own index.
+func (share *KeyshareShare) Verify() error {
+	if share.Threshold < 2 || share.Threshold > share.Count || share.Index < 0 || share.Index >= share.Count {
+		return ErrInvalidKeyshareShare
+	}
+	var expected [][]int
+	for _, excluded := range subsets(share.Count, share.Threshold-1) {
+		if !containsIndex(excluded, share.Index) {
+			expected = append(expected, excluded)
+		}
+	}
+	if len(share.SubShares) != len(expected) {
+		return ErrInvalidKeyshareShare
+	}
+	for i, subshare := range share.SubShares {
+		if subshare.Value == nil || !equalIndices(subshare.Excluded, expected[i]) {
+			return ErrInvalidKeyshareShare
+		}
+	}
+	return nil
+}
+
+// secret returns the sum of the subshares that the keyshare server holding this share uses in a
+// session in which the specified keyshare servers participate.
+func (share *KeyshareShare) secret(participants []int) (*big.Int, error) {
+	if err := share.Verify(); err != nil {
+		return nil, err
+	}
+	if !sort.IntsAreSorted(participants) || !containsIndex(participants, share.Index) {
+		return nil, errors.New("participants must be sorted and include the keyshare server")
+	}
+	for i, p := range participants {
+		if p < 0 || p >= share.Count || (i > 0 && participants[i-1] == p) {
+			return nil, errors.New("invalid participant")
+		}
+	}
+	if len(participants) < share.Threshold {
+		return nil, ErrTooFewKeyshareServers
+	}
+
+	secret := big.NewInt(0)
+	for _, subshare := range share.SubShares {
+		// The subshare is used by the first participant not excluded from it
+		for _, p := range participants {
+			if !containsIndex(subshare.Excluded, p) {
+				if p == share.Index {
+					secret.Add(secret, subshare.Value)
+				}
+				break
+			}
+		}
+	}
+	return secret, nil
+}
+
+// subsets returns all subsets of {0, ..., n-1} of size k, in lexicographical order.
+func subsets(n, k int) [][]int {
+	if k == 0 {
+		return [][]int{{}}
+	}
+	var result [][]int
+	for first := 0; first <= n-k; first++ {
+		for _, rest := range subsets(n-first-1, k-1) {
+			subset := []int{first}
+			for _, i := range rest {
+				subset = append(subset, first+1+i)
+			}
+			result = append(result, subset)
+		}
+	}
+	return result
+}
+
+// binomialAtMost returns whether n choose k is at most max, without computing it when it is
+// larger.
+func binomialAtMost(n, k, max int) bool {
+	if n-k < k {
+		k = n - k
+	}
+	if k > 0 && n > max {
+		return false // also prevents overflow below
+	}
+	// The intermediate values c = n choose i are increasing in i for i <= n/2
+	c := 1
+	for i := 0; i < k; i++ {
+		c = c * (n - i) / (i + 1)
+		if c > max {
+			return false
+		}
+	}
+	return true
+}
+
+func containsIndex(indices []int, index int) bool {
+	for _, i := range indices {
+		if i == index {
+			return true
+		}
+	}
+	return false
+}
+
+func equalIndices(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,161 @@
+package verenc
+
+import (
+	"crypto/rand"
+
+	"github.com/go-errors/errors"
+	"github.com/privacybydesign/gabi/big"
+	"github.com/privacybydesign/gabi/gabikeys"
+	"github.com/privacybydesign/gabi/internal/common"
+)
+
+// The proof that a ciphertext (u, e, v) encrypts the attribute m proves knowledge of r and m such
+// that
+//     u^2 = g^{2r}, e^2 = y1^{2r} h^{2m}, v^2 = (y2 y3^H(u,e,L))^{2r},
+// where the squares ensure that the relations hold in the subgroup generated by g and h regardless
+// of the signs introduced by abs(). Together with the check that v = abs(v) this suffices for the
+// inspector to be able to decrypt the ciphertext to m.
+
+type (
+	// ProofStructure describes the encryption of the attribute at an index to an inspector.
+	ProofStructure struct {
+		index int
+		pk    *PublicKey
+		label []byte
+	}
+
+	// Proof proves that a ciphertext encrypts the attribute at an index.
+	Proof struct {
+		Ciphertext
+
+		RResponse *big.Int `json:"r_response"`
+		MResponse *big.Int `json:"-"` // Included elsewhere in the proof
+
+		// Proof structure description
+		PublicKey *PublicKey `json:"pk"`
+	}
+
+	ProofCommit struct {
+		ciphertext  *Ciphertext
+		r           *big.Int
+		rRandomizer *big.Int
+	}
+)
+
+// NewProofStructure creates a new proof structure for encrypting the attribute at the given
+// index to the inspector having the given public key, under the given label.
+func NewProofStructure(index int, pk *PublicKey, label []byte) (*ProofStructure, error) {
+	if pk == nil {
+		return nil, ErrInvalidPublicKey
+	}
+	if err := pk.validate(); err != nil {
+		return nil, err
+	}
+	return &ProofStructure{index: index, pk: pk, label: append([]byte{}, label...)}, nil
+}
+
+// rRandomizerBits returns the size of the randomizer of r, such that the response
+// statistically hides r.
+func (s *ProofStructure) rRandomizerBits(g *gabikeys.PublicKey) uint {
+	return uint(s.pk.N.BitLen()) + g.Params.Lh + g.Params.Lstatzk
+}
+
+func (s *ProofStructure) CommitmentsFromSecrets(g *gabikeys.PublicKey, m, mRandomizer *big.Int) ([]*big.Int, *ProofCommit, error) {
+	if uint(s.pk.N.BitLen()) <= g.Params.Lm {
+		return nil, nil, errors.New("modulus of inspector public key too small")
+	}
+	ciphertext, r, err := encrypt(s.pk, m, s.label)
+	if err != nil {
+		return nil, nil, err
+	}
+	rRandomizer, err := big.RandInt(rand.Reader, new(big.Int).Lsh(big.NewInt(1), s.rRandomizerBits(g)))
+	if err != nil {
+		return nil, nil, err
+	}
+	commit := &ProofCommit{
+		ciphertext:  ciphertext,
+		r:           r,
+		rRandomizer: rRandomizer,
+	}
+
+	n2 := s.pk.n2()
+	exp := new(big.Int).Lsh(rRandomizer, 1)
+	uCommit := new(big.Int).Exp(s.pk.G, exp, n2)
+	eCommit := new(big.Int).Exp(s.pk.Y1, exp, n2)
+	eCommit.Mul(eCommit, s.pk.h(new(big.Int).Lsh(mRandomizer, 1), n2)).Mod(eCommit, n2)
+	vCommit := new(big.Int).Exp(s.pk.vBase(ciphertext.U, ciphertext.E, s.label, n2), exp, n2)
+
+	return s.contributions(ciphertext, uCommit, eCommit, vCommit), commit, nil
+}
+
+func (s *ProofStructure) contributions(ciphertext *Ciphertext, uCommit, eCommit, vCommit *big.Int) []*big.Int {
+	return append(s.pk.values(),
+		common.IntHashSha256(s.label),
+		ciphertext.U, ciphertext.E, ciphertext.V,
+		uCommit, eCommit, vCommit,
+	)
+}
+
+func (s *ProofStructure) BuildProof(commit *ProofCommit, challenge *big.Int) *Proof {
+	return &Proof{
+		Ciphertext: Ciphertext{
+			U:     new(big.Int).Set(commit.ciphertext.U),
+			E:     new(big.Int).Set(commit.ciphertext.E),
+			V:     new(big.Int).Set(commit.ciphertext.V),
+			Label: append([]byte{}, s.label...),
+		},
+		RResponse: new(big.Int).Add(commit.rRandomizer, new(big.Int).Mul(challenge, commit.r)),
+		PublicKey: s.pk,
+	}
+}
+
+func (s *ProofStructure) VerifyProofStructure(g *gabikeys.PublicKey, p *Proof) bool {
+	if p.RResponse == nil || p.MResponse == nil {
+		return false
+	}
+	if uint(p.RResponse.BitLen()) > s.rRandomizerBits(g)+1 {
+		return false
+	}
+	return p.Ciphertext.wellFormed(s.pk.n2())
+}
+
+func (s *ProofStructure) CommitmentsFromProof(g *gabikeys.PublicKey, p *Proof, challenge *big.Int) []*big.Int {
+	n2 := s.pk.n2()
+	minusTwoC := new(big.Int).Lsh(challenge, 1)
+	minusTwoC.Neg(minusTwoC)
+	exp := new(big.Int).Lsh(p.RResponse, 1)
+
+	commitment := func(x, base *big.Int) *big.Int {
+		c, err := common.ModPow(x, minusTwoC, n2)
+		if err != nil {
+			// x is not invertible, so the proof cannot be valid; return a value that will
+			// make verification fail
+			return big.NewInt(0)
+		}
+		return c.Mul(c, new(big.Int).Exp(base, exp, n2)).Mod(c, n2)
+	}
+
+	uCommit := commitment(p.U, s.pk.G)
+	eCommit := commitment(p.E, s.pk.Y1)
+	eCommit.Mul(eCommit, s.pk.h(new(big.Int).Lsh(p.MResponse, 1), n2)).Mod(eCommit, n2)
+	vCommit := commitment(p.V, s.pk.vBase(p.U, p.E, s.label, n2))
+
+	return s.contributions(&p.Ciphertext, uCommit, eCommit, vCommit)
+}
+
+// Proves returns whether the Proof encrypts to the inspector having the specified
+// public key, under the specified label.
+//
+// NB: this method does not verify the proof.
+func (p *Proof) Proves(pk *PublicKey, label []byte) bool {
+	return p.PublicKey.Equal(pk) && string(p.Label) == string(label)
+}
+
+// Extract proof structure from proof
+func (p *Proof) ExtractStructure(index int, g *gabikeys.PublicKey) (*ProofStructure, error) {
+	if p.PublicKey == nil || p.PublicKey.N == nil || uint(p.PublicKey.N.BitLen()) <= g.Params.Lm ||
+		p.PublicKey.N.BitLen() > MaxModulusBits {
+		return nil, ErrInvalidPublicKey
+	}
+	return NewProofStructure(index, p.PublicKey, p.Label)
+}
@@ -0,0 +1,255 @@
+// Package verenc implements verifiable encryption of attributes to an inspector, using the
+// encryption scheme of Camenisch and Shoup ("Practical Verifiable Encryption and Decryption of
+// Discrete Logarithms", CRYPTO 2003).
+//
+// Public keys consist of an RSA modulus n = pq with p, q safe primes, and group elements
+// g, y1 = g^x1, y2 = g^x2, y3 = g^x3 of Z_{n^2}^* where g is a random 2n-th power. An attribute m
+// is encrypted with label L as
+//
+//	u = g^r, e = y1^r h^m, v = abs((y2 y3^H(u,e,L))^r)
+//
+// with h = 1+n and r random in [0, n/4). The prover proves in zero knowledge that the ciphertext
+// encrypts the attribute, using the same (integer) response for m as the disclosure proof, which
+// binds the ciphertext to the attribute in the credential. Only the inspector holding the private
+// key can decrypt the ciphertext.
+package verenc
+
+import (
+	"crypto/rand"
+
+	"github.com/go-errors/errors"
+	"github.com/privacybydesign/gabi/big"
+	"github.com/privacybydesign/gabi/internal/common"
+	"github.com/privacybydesign/gabi/safeprime"
+)
+
+type (
+	// PublicKey is the public key of an inspector, to which attributes are encrypted.
+	PublicKey struct {
+		N  *big.Int `json:"n"`
+		G  *big.Int `json:"g"`
+		Y1 *big.Int `json:"y1"`
+		Y2 *big.Int `json:"y2"`
+		Y3 *big.Int `json:"y3"`
+	}
+
+	// PrivateKey is the private key of an inspector, with which ciphertexts are decrypted.
+	PrivateKey struct {
+		X1 *big.Int `json:"x1"`
+		X2 *big.Int `json:"x2"`
+		X3 *big.Int `json:"x3"`
+
+		PublicKey *PublicKey `json:"pk"`
+	}
+
+	// Ciphertext is the encryption of an attribute under a label.
+	Ciphertext struct {
+		U     *big.Int `json:"u"`
+		E     *big.Int `json:"e"`
+		V     *big.Int `json:"v"`
+		Label []byte   `json:"label"`
+	}
+)
+
+// MaxModulusBits is the maximum size of the modulus of inspector public keys. This bounds the
+// work that verifiers do for prover-chosen public keys before checking them.
+const MaxModulusBits = 4096
+
+var (
+	// ErrInvalidCiphertext is returned when decrypting a ciphertext that was not
+	// correctly created under the public key of the inspector.
+	ErrInvalidCiphertext = errors.New("invalid ciphertext")
+	// ErrInvalidPublicKey is returned when using an invalid public key.
+	ErrInvalidPublicKey = errors.New("invalid public key")
+)
+
+// GenerateKey generates a new inspector private key with a modulus of the given size.
+func GenerateKey(bits int) (*PrivateKey, error) {
+	p, err := safeprime.Generate(bits/2, nil)
+	if err != nil {
+		return nil, err
+	}
+	var q *big.Int
+	for q == nil || q.Cmp(p) == 0 {
+		q, err = safeprime.Generate(bits-bits/2, nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return NewPrivateKey(p, q)
+}
+
+// NewPrivateKey generates a new inspector private key using the given safe primes.
+func NewPrivateKey(p, q *big.Int) (*PrivateKey, error) {
+	if p.Cmp(q) == 0 || !safeprime.ProbablySafePrime(p, 40) || !safeprime.ProbablySafePrime(q, 40) {
+		return nil, errors.New("p and q must be distinct safe primes")
+	}
+	n := new(big.Int).Mul(p, q)
+	n2 := new(big.Int).Mul(n, n)
+
+	// g = g'^{2n} for a random g' in Z_{n^2}^*, which generates the subgroup of order p'q'
+	gPrime, err := randomUnit(n2)
+	if err != nil {
+		return nil, err
+	}
+	g := new(big.Int).Exp(gPrime, new(big.Int).Lsh(n, 1), n2)
+
+	bound := new(big.Int).Rsh(n2, 2)
+	xs := make([]*big.Int, 3)
+	ys := make([]*big.Int, 3)
+	for i := range xs {
+		if xs[i], err = big.RandInt(rand.Reader, bound); err != nil {
+			return nil, err
+		}
+		ys[i] = new(big.Int).Exp(g, xs[i], n2)
+	}
+
+	return &PrivateKey{
+		X1: xs[0], X2: xs[1], X3: xs[2],
+		PublicKey: &PublicKey{N: n, G: g, Y1: ys[0], Y2: ys[1], Y3: ys[2]},
+	}, nil
+}
+
+// Equal returns whether the public keys are equal.
+func (pk *PublicKey) Equal(other *PublicKey) bool {
+	if pk == nil || other == nil {
+		return pk == other
+	}
+	for i, x := range pk.values() {
+		y := other.values()[i]
+		if x == nil || y == nil || x.Cmp(y) != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (pk *PublicKey) values() []*big.Int {
+	return []*big.Int{pk.N, pk.G, pk.Y1, pk.Y2, pk.Y3}
+}
+
+func (pk *PublicKey) validate() error {
+	if pk.N == nil || pk.N.Bit(0) == 0 || pk.N.BitLen() > MaxModulusBits {
+		return ErrInvalidPublicKey
+	}
+	n2 := pk.n2()
+	for _, x := range pk.values()[1:] {
+		if x == nil || !inGroup(x, n2) {
+			return ErrInvalidPublicKey
+		}
+	}
+	return nil
+}
+
+func (pk *PublicKey) n2() *big.Int {
+	return new(big.Int).Mul(pk.N, pk.N)
+}
+
+// h returns h^x = (1+n)^x = 1 + xn mod n^2.
+func (pk *PublicKey) h(x *big.Int, n2 *big.Int) *big.Int {
+	hx := new(big.Int).Mul(x, pk.N)
+	hx.Add(hx, big.NewInt(1))
+	return hx.Mod(hx, n2)
+}
+
+// vBase returns y2 y3^H(u,e,L), the base of v.
+func (pk *PublicKey) vBase(u, e *big.Int, label []byte, n2 *big.Int) *big.Int {
+	base := new(big.Int).Exp(pk.Y3, labelHash(u, e, label), n2)
+	return base.Mul(base, pk.Y2).Mod(base, n2)
+}
+
+// Encrypt encrypts m, which must be nonnegative and smaller than the modulus of the public key,
+// under the given label.
+func Encrypt(pk *PublicKey, m *big.Int, label []byte) (*Ciphertext, error) {
+	ct, _, err := encrypt(pk, m, label)
+	return ct, err
+}
+
+func encrypt(pk *PublicKey, m *big.Int, label []byte) (*Ciphertext, *big.Int, error) {
+	if err := pk.validate(); err != nil {
+		return nil, nil, err
+	}
+	if m.Sign() < 0 || m.Cmp(pk.N) >= 0 {
+		return nil, nil, errors.New("message out of range")
+	}
+	n2 := pk.n2()
+	r, err := big.RandInt(rand.Reader, new(big.Int).Rsh(pk.N, 2))
+	if err != nil {
+		return nil, nil, err
+	}
+	u := new(big.Int).Exp(pk.G, r, n2)
+	e := new(big.Int).Exp(pk.Y1, r, n2)
+	e.Mul(e, pk.h(m, n2)).Mod(e, n2)
+	v := new(big.Int).Exp(pk.vBase(u, e, label, n2), r, n2)
+	return &Ciphertext{U: u, E: e, V: abs(v, n2), Label: append([]byte{}, label...)}, r, nil
+}
+
+// Decrypt decrypts the ciphertext.
+func (sk *PrivateKey) Decrypt(ct *Ciphertext) (*big.Int, error) {
+	pk := sk.PublicKey
+	if err := pk.validate(); err != nil {
+		return nil, err
+	}
+	n2 := pk.n2()
+	if !ct.wellFormed(n2) {
+		return nil, ErrInvalidCiphertext
+	}
+
+	// Check that v^2 = u^{2(x2 + H x3)}
+	exp := new(big.Int).Mul(labelHash(ct.U, ct.E, ct.Label), sk.X3)
+	exp.Add(exp, sk.X2).Lsh(exp, 1)
+	if new(big.Int).Exp(ct.U, exp, n2).Cmp(new(big.Int).Exp(ct.V, big.NewInt(2), n2)) != 0 {
+		return nil, ErrInvalidCiphertext
+	}
+
+	// mhat = (e/u^{x1})^{2t} with t = 1/2 mod n, which equals h^m = 1 + mn for a valid ciphertext
+	mhat, err := common.ModPow(ct.U, new(big.Int).Neg(sk.X1), n2)
+	if err != nil {
+		return nil, ErrInvalidCiphertext
+	}
+	mhat.Mul(mhat, ct.E).Mod(mhat, n2)
+	t := new(big.Int).ModInverse(big.NewInt(2), pk.N)
+	mhat.Exp(mhat, t.Lsh(t, 1), n2)
+	m, rem := new(big.Int).QuoRem(mhat.Sub(mhat, big.NewInt(1)), pk.N, new(big.Int))
+	if rem.Sign() != 0 {
+		return nil, ErrInvalidCiphertext
+	}
+	return m, nil
+}
+
+func (ct *Ciphertext) wellFormed(n2 *big.Int) bool {
+	return ct.U != nil && ct.E != nil && ct.V != nil &&
+		inGroup(ct.U, n2) && inGroup(ct.E, n2) && inGroup(ct.V, n2) &&
+		ct.V.Cmp(new(big.Int).Rsh(n2, 1)) <= 0
+}
+
+// labelHash returns H(u,e,L).
+func labelHash(u, e *big.Int, label []byte) *big.Int {
+	return common.HashCommit([]*big.Int{u, e, common.IntHashSha256(label)}, false)
+}
+
+// inGroup returns whether 0 < x < n2.
+func inGroup(x, n2 *big.Int) bool {
+	return x.Sign() > 0 && x.Cmp(n2) < 0
+}
+
+// abs returns x if x <= n2/2, and n2 - x otherwise.
+func abs(x, n2 *big.Int) *big.Int {
+	if x.Cmp(new(big.Int).Rsh(n2, 1)) > 0 {
+		return x.Sub(n2, x)
+	}
+	return x
+}
+
+func randomUnit(modulus *big.Int) (*big.Int, error) {
+	one := big.NewInt(1)
+	for {
+		r, err := big.RandInt(rand.Reader, modulus)
+		if err != nil {
+			return nil, err
+		}
+		if r.Sign() > 0 && new(big.Int).GCD(nil, nil, r, modulus).Cmp(one) == 0 {
+			return r, nil
+		}
+	}
+}
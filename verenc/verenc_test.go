@@ -0,0 +1,39 @@
+package verenc_test
+
+import (
+	"testing"
+
+	"github.com/privacybydesign/gabi/big"
+	"github.com/privacybydesign/gabi/verenc"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncryptDecrypt(t *testing.T) {
+	sk, err := verenc.GenerateKey(256)
+	require.NoError(t, err)
+	other, err := verenc.GenerateKey(256)
+	require.NoError(t, err)
+	require.True(t, sk.PublicKey.Equal(sk.PublicKey))
+	require.False(t, sk.PublicKey.Equal(other.PublicKey))
+
+	for _, m := range []*big.Int{big.NewInt(0), big.NewInt(1), big.NewInt(123456789), new(big.Int).Sub(sk.PublicKey.N, big.NewInt(1))} {
+		ct, err := verenc.Encrypt(sk.PublicKey, m, []byte("label"))
+		require.NoError(t, err)
+		decrypted, err := sk.Decrypt(ct)
+		require.NoError(t, err)
+		require.Equal(t, m, decrypted)
+
+		// The label is bound to the ciphertext
+		ct.Label = []byte("other label")
+		_, err = sk.Decrypt(ct)
+		require.Equal(t, verenc.ErrInvalidCiphertext, err)
+
+		// Ciphertexts can only be decrypted by the inspector
+		ct.Label = []byte("label")
+		_, err = other.Decrypt(ct)
+		require.Error(t, err)
+	}
+
+	_, err = verenc.Encrypt(sk.PublicKey, sk.PublicKey.N, nil)
+	require.Error(t, err)
+}